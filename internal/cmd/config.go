@@ -25,6 +25,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -63,14 +64,47 @@ const configTemplate = `auth:
   # Default: ""
   vault-pass-file: %q
 
+  # Vault ids like 'prod@/path/vault-password-file-or-script' or 'staging@prompt',
+  # ciphertexts encrypted by a vault id carry its label, e.g. 'GOSSH-VAULT;2;prod;...'.
+  # Default: []
+  vault-id: []
+
 hosts:
-  # Default inventory file that holds the target hosts.
+  # Default inventory file or directory that holds the target hosts, or
+  # executable file that outputs them in json like Ansible dynamic inventory.
   # Default: ""
   inventory: %q
 
-  # Default port of target hosts.
+  # Seconds to cache the output of executable inventory file in
+  # $HOME/.gossh/inventory-cache/, 0 disables the cache.
+  # Default: 0
+  inventory-cache-ttl: %d
+
+  # Default port of target hosts, ports in ssh_config are not used if it is set.
   # Default: 22
-  port: %d
+  %s
+
+  # How to verify host keys of target hosts and proxy servers.
+  # Available values:
+  #   strict: only accept host keys that already exist in known_hosts files.
+  #   accept-new: record keys of new hosts to $HOME/.ssh/known_hosts,
+  #               but refuse keys that changed.
+  #   off: do not verify host keys.
+  # Default: accept-new
+  host-key-checking: %s
+
+  # Extra known_hosts files besides $HOME/.ssh/known_hosts.
+  # Default: []
+  known-hosts-files: []
+
+  # Use HostName, Port, User, IdentityFile and ProxyJump of hosts in
+  # ssh_config, if they are set by neither inventory nor flags.
+  # Default: false
+  use-ssh-config: %v
+
+  # ssh_config file used by 'use-ssh-config'.
+  # Default: "" (null means $HOME/.ssh/config)
+  ssh-config: %q
 
 run:
   # Use sudo to run task.
@@ -91,6 +125,61 @@ run:
   # Default: 1
   concurrency: %d
 
+  # Execute commands without pty, so that stdout and stderr are not mixed.
+  # Sudo reads password from stdin by 'sudo -S' in this mode.
+  # Default: false
+  no-pty: %v
+
+  # Run target hosts in sequential batches of the sizes, like "1,10%%,50%%",
+  # the last size is used for remaining batches.
+  # Default: "" (null means running all hosts in one batch)
+  serial: %q
+
+  # Stop running subsequent batches if more than the percentage of hosts
+  # failed in a batch, 0 stops them once any host failed.
+  # Default: 100
+  max-fail-percentage: %d
+
+  # Stop running any other target hosts once a host failed.
+  # Default: false
+  any-errors-fatal: %v
+
+  # Pause seconds between batches of 'serial'.
+  # Default: 0
+  batch-pause: %d
+
+  # Ask for confirmation before each batch of 'serial' except the first one.
+  # Default: false
+  batch-confirm: %v
+
+  # Number of retries for target hosts failed to connect.
+  # The delay before each retry starts from 1 second and doubles.
+  # Default: 0
+  retries: %d
+
+  # File that failed target hosts are written to after each task,
+  # rerun them by 'gossh command @retry' or '--hosts.limit @retry'.
+  # Default: ~/.gossh/retry ("" means do not write the file)
+  retry-file: %q
+
+  # Max number of open connections to target hosts, idle connections are
+  # closed when it is reached. Shared connections to jump hosts are not counted.
+  # Default: 0 (0 means no limit)
+  max-open-conns: %d
+
+  # Keep unused connections open for the seconds for reusing by the task.
+  # Default: 0 (0 means closing connections as soon as they are not used)
+  idle-timeout: %d
+
+  # Keep connections to target hosts open in background for the seconds,
+  # so that subsequent gossh commands reuse them instead of dialing again.
+  # Default: 0 (0 means do not keep connections)
+  control-persist: %d
+
+  # Directory for control sockets of the background connections.
+  # Default: ~/.gossh/cm
+  control-dir: %q
+
 output:
   # File to which messages are output.
   # Default: ""
@@ -108,6 +197,11 @@ output:
   # Default: false
   quite: %v
 
+  # Output lines of commands/script as soon as they arrive, each line is
+  # prefixed by the hostname.
+  # Default: false
+  stream: %v
+
 timeout:
   # Timeout seconds for connecting each target host.
   # Default: 10 (seconds)
@@ -124,12 +218,14 @@ timeout:
 
 proxy:
   # Proxy server address. It will enable proxy if it is not null.
+  # It can be a chain of jump hosts like "user@bastion1:22,user@jump2:2222",
+  # user and port of a jump host default to 'proxy.user' and 'proxy.port'.
   # Default: ""
   server: %q
 
-  # Proxy server port.
+  # Proxy server port, ports in ssh_config are not used if it is set.
   # Default: 22
-  port: %d
+  %s
 
   # Login user for proxy.
   # Default: value of 'auth.user'
@@ -177,24 +273,44 @@ and $PWD/.gossh.yaml has higher priority than $HOME/.gossh.yaml`,
 			configTemplate,
 			user, config.Auth.Password, config.Auth.AskPass,
 			config.Auth.PassFile, config.Auth.Passphrase, config.Auth.VaultPassFile,
-			config.Hosts.Inventory, config.Hosts.Port,
-			config.Run.Sudo, config.Run.AsUser, config.Run.Lang, config.Run.Concurrency,
+			config.Hosts.Inventory, config.Hosts.CacheTTL, portConfig("hosts.port", config.Hosts.Port), config.Hosts.HostKeyChecking,
+			config.Hosts.UseSSHConfig, config.Hosts.SSHConfig,
+			config.Run.Sudo, config.Run.AsUser, config.Run.Lang, config.Run.Concurrency, config.Run.NoPTY,
+			strings.Join(config.Run.Serial, ","), config.Run.MaxFailPercentage, config.Run.AnyErrorsFatal,
+			config.Run.BatchPause, config.Run.BatchConfirm,
+			config.Run.Retries, config.Run.RetryFile,
+			config.Run.MaxOpenConns, config.Run.IdleTimeout,
+			config.Run.ControlPersist, config.Run.ControlDir,
 			config.Output.File, config.Output.JSON, config.Output.Verbose, config.Output.Quiet,
+			config.Output.Stream,
 			config.Timeout.Conn, config.Timeout.Command, config.Timeout.Task,
-			config.Proxy.Server, config.Proxy.Port, config.Proxy.User,
+			config.Proxy.Server, portConfig("proxy.port", config.Proxy.Port), config.Proxy.User,
 			config.Proxy.Password, config.Proxy.Passphrase,
 		)
 	},
 }
 
+// portConfig returns the port line of config file, it is commented out if the
+// port is not set, so that ports in ssh_config are used.
+func portConfig(flag string, port int) string {
+	if !configflags.IsSet(flag) {
+		return fmt.Sprintf("# port: %d", port)
+	}
+
+	return fmt.Sprintf("port: %d", port)
+}
+
 func init() {
 	configCmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
 		util.CobraMarkHiddenGlobalFlags(
 			command,
 			"config",
 			"auth.identity-files",
+			"auth.vault-id",
 			"proxy.identity-files",
 			"hosts.list",
+			"hosts.limit",
+			"hosts.known-hosts-files",
 		)
 
 		command.Parent().HelpFunc()(command, strings)
@@ -0,0 +1,44 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/serialt/gosible/pkg/batchssh"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// controlMasterCmd represents the control-master command, it is started by
+// gossh itself in background for keeping connections of flag
+// '--run.control-persist'.
+var controlMasterCmd = &cobra.Command{
+	Use:    "control-master",
+	Short:  "Keep a connection to a target host for reusing",
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		util.CheckErr(batchssh.ServeControlMaster(os.Stdin, os.Stdout, os.Stderr))
+	},
+}
@@ -23,12 +23,11 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"path"
-
 	"github.com/spf13/cobra"
 
 	"github.com/serialt/gosible/internal/pkg/configflags"
 	"github.com/serialt/gosible/internal/pkg/sshtask"
+	"github.com/serialt/gosible/pkg/batchssh"
 	"github.com/serialt/gosible/pkg/util"
 )
 
@@ -36,6 +35,7 @@ var (
 	srcFiles    []string
 	localDstDir string
 	tmpDir      string
+	fetchMethod string
 )
 
 // fetchCmd represents the fetch command
@@ -51,22 +51,23 @@ Copy files and dirs from target hosts to local.`,
   # Copy files and dirs from target hosts to local dir /tmp/backup/.
   $ gossh fetch host[1-2] -f /path1/foo.txt,/path2/bar/ -d /tmp/backup
 
+  # Copy files that only root can read by sudo.
+  $ gossh fetch host[1-2] -f /var/log/messages -d /tmp/backup -s
+
   Find more examples at: https://github.com/serialt/gosible/blob/main/docs/fetch.md`,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		if errs := configflags.Config.Validate(); len(errs) != 0 {
 			util.CheckErr(errs)
 		}
+
+		util.CheckErr(configflags.ValidateTransferMethod(fetchMethod))
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		task := sshtask.NewTask(sshtask.FetchTask, configflags.Config)
 
 		task.SetTargetHosts(args)
 		task.SetFetchFiles(srcFiles)
-
-		if tmpDir == "$HOME" {
-			tmpDir = path.Join("/home", configflags.Config.Auth.User)
-		}
-		task.SetFetchOptions(localDstDir, tmpDir)
+		task.SetFetchOptions(localDstDir, fetchMethod)
 
 		task.Start()
 
@@ -83,7 +84,13 @@ func init() {
 		"local directory that files/dirs from target hosts will be copied to",
 	)
 
-	fetchCmd.Flags().StringVarP(&tmpDir, "tmp-dir", "t", "$HOME",
+	fetchCmd.Flags().StringVarP(&fetchMethod, "method", "m", batchssh.TransferAuto,
+		`method of copying files/dirs, available methods: auto, sftp, tar
+(auto uses tar with sudo, otherwise sftp; tar is always used with sudo)`,
+	)
+
+	fetchCmd.Flags().StringVarP(&tmpDir, "tmp-dir", "t", "",
 		"directory of target hosts for storing temporary zip file",
 	)
+	_ = fetchCmd.Flags().MarkDeprecated("tmp-dir", "files are streamed without temporary files now")
 }
@@ -0,0 +1,338 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/serialt/gosible/internal/cmd/vault"
+	"github.com/serialt/gosible/internal/pkg/aes"
+	"github.com/serialt/gosible/internal/pkg/configflags"
+	"github.com/serialt/gosible/internal/pkg/sshtask"
+	"github.com/serialt/gosible/pkg/inventory"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// maskedVaultValue replaces vault ciphertexts in outputs.
+const maskedVaultValue = "<vault encrypted>"
+
+// inventoryCmd represents the inventory command
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "Show and check the inventory",
+	Long: `
+Show hosts, groups and variables of the inventory, and check it for problems.
+The inventory is specified by flag '-i' or 'hosts.inventory' in config file.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if errs := configflags.Config.Validate(); len(errs) != 0 {
+			util.CheckErr(errs)
+		}
+
+		if configflags.Config.Hosts.Inventory == "" {
+			util.CobraCheckErrWithHelp(cmd, "need inventory, you can specify it by flag '-i'")
+		}
+	},
+}
+
+var inventoryListCmd = &cobra.Command{
+	Use:   "list [HOST|PATTERN|GROUP...]",
+	Short: "List hosts of the inventory",
+	Long: `
+List hosts of the inventory with their host, port, user and groups.`,
+	Example: `
+  # List all hosts of the inventory.
+  $ gossh inventory list -i /path/hosts.txt
+
+  # List hosts selected by patterns like the positional arguments of 'gossh command'.
+  $ gossh inventory list 'webserver:&prod:!web03' -i /path/hosts.txt`,
+	Run: func(cmd *cobra.Command, args []string) {
+		inv := loadInventory()
+
+		hosts := inv.Hosts()
+		if len(args) != 0 {
+			var err error
+			hosts, err = inv.Select(args...)
+			util.CheckErr(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ALIAS\tHOST\tPORT\tUSER\tGROUPS")
+
+		for _, v := range hosts {
+			v, err := inv.Decrypt(v)
+			util.CheckErr(err)
+
+			port := "-"
+			if v.Port != 0 {
+				port = strconv.Itoa(v.Port)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				v.Alias, maskVaultValue(v.Host), port, maskVaultValue(orDash(v.User)),
+				strings.Join(inv.HostGroups(v.Alias), ","))
+		}
+
+		util.CheckErr(w.Flush())
+	},
+}
+
+var inventoryGraphCmd = &cobra.Command{
+	Use:   "graph [GROUP...]",
+	Short: "Show the tree of groups and hosts",
+	Long: `
+Show the tree of groups, their children groups and hosts.
+Groups that are not children of other groups are shown by default.`,
+	Example: `
+  # Show the tree of all groups.
+  $ gossh inventory graph -i /path/hosts.txt
+
+  # Show the tree of group webserver.
+  $ gossh inventory graph webserver -i /path/hosts.txt`,
+	Run: func(cmd *cobra.Command, args []string) {
+		inv := loadInventory()
+
+		groups := args
+		if len(groups) == 0 {
+			groups = topGroups(inv)
+		}
+
+		for _, group := range groups {
+			if !hasGroup(inv, group) {
+				util.CheckErr(fmt.Sprintf("group '%s' not found in inventory", group))
+			}
+
+			fmt.Printf("@%s:\n", group)
+			printGroupTree(inv, group, "  ")
+		}
+	},
+}
+
+var inventoryHostCmd = &cobra.Command{
+	Use:   "host ALIAS",
+	Short: "Show merged variables of a host",
+	Long: `
+Show groups and merged variables of a host, vault encrypted values are masked.`,
+	Example: `
+  # Show variables of host web01.
+  $ gossh inventory host web01 -i /path/inventory/`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			util.CobraCheckErrWithHelp(cmd, "requires one arg to represent the host alias")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		inv := loadInventory()
+
+		alias := args[0]
+		if inv.HostByAlias(alias) == nil {
+			util.CheckErr(fmt.Sprintf("host '%s' not found in inventory", alias))
+		}
+
+		vars := make(map[string]interface{})
+		for k, v := range inv.HostVars(alias) {
+			vars[k] = maskVaultValue(v)
+		}
+
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+
+		util.CheckErr(encoder.Encode(&struct {
+			Alias  string                 `yaml:"alias"`
+			Groups []string               `yaml:"groups,flow"`
+			Vars   map[string]interface{} `yaml:"vars"`
+		}{alias, inv.HostGroups(alias), vars}))
+	},
+}
+
+var inventoryLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check the inventory for problems",
+	Long: `
+Check the inventory for problems and report all of them with file:line locations:
+unparseable files and host patterns, undefined children groups, cycles of children,
+duplicate aliases, invalid ports, and unknown variables that look like misspelled
+built-in variables.
+It exits with status 1 if there are errors.`,
+	Example: `
+  # Check an inventory directory.
+  $ gossh inventory lint -i /path/inventory/`,
+	Run: func(cmd *cobra.Command, args []string) {
+		issues, err := inventory.Lint(
+			configflags.Config.Hosts.Inventory,
+			inventory.WithEncrypted(aes.IsAES256CipherText),
+		)
+		util.CheckErr(err)
+
+		errorsCount := 0
+		for _, v := range issues {
+			if v.Level == inventory.LevelError {
+				errorsCount++
+			}
+
+			fmt.Println(v)
+		}
+
+		summary := fmt.Sprintf("%d errors, %d warnings", errorsCount, len(issues)-errorsCount)
+		if errorsCount != 0 {
+			util.CheckErr(summary)
+		}
+
+		fmt.Fprintln(os.Stderr, summary)
+	},
+}
+
+func init() {
+	util.CobraAddSubCommandInOrder(inventoryCmd,
+		inventoryListCmd, inventoryGraphCmd, inventoryHostCmd, inventoryLintCmd)
+
+	for _, command := range append([]*cobra.Command{inventoryCmd}, inventoryCmd.Commands()...) {
+		command.SetHelpFunc(func(command *cobra.Command, strings []string) {
+			util.CobraMarkHiddenGlobalFlagsExcept(
+				rootCmd,
+				"hosts.inventory",
+				"hosts.inventory-cache-ttl",
+				"auth.vault-pass-file",
+				"auth.vault-id",
+				"output.verbose",
+			)
+			rootCmd.HelpFunc()(command, strings)
+		})
+	}
+}
+
+// loadInventory loads the inventory whose hosts decrypt only vault
+// ciphertexts of ports, which must be numbers.
+func loadInventory() *inventory.Inventory {
+	inv, err := sshtask.LoadInventory(
+		configflags.Config,
+		inventory.WithDecrypt(func(alias, name, value string) (string, error) {
+			if name != "port" || !aes.IsAES256CipherText(value) {
+				return value, nil
+			}
+
+			return vault.Decrypt(value)
+		}),
+	)
+	if err != nil {
+		util.CheckErr(err)
+	}
+
+	return inv
+}
+
+// topGroups returns groups that are not children of other groups.
+func topGroups(inv *inventory.Inventory) []string {
+	children := make(map[string]bool)
+	for _, group := range inv.Groups() {
+		for _, child := range inv.Children(group) {
+			children[child] = true
+		}
+	}
+
+	var groups []string
+	for _, group := range inv.Groups() {
+		if !children[group] {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+func hasGroup(inv *inventory.Inventory, group string) bool {
+	for _, v := range inv.Groups() {
+		if v == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+// printGroupTree prints children groups and hosts of group like:
+//
+//	@webserver:
+//	  |--@canary:
+//	  |  |--web03
+//	  |--web01
+func printGroupTree(inv *inventory.Inventory, group, indent string) {
+	inChildren := make(map[string]bool)
+
+	for _, child := range inv.Children(group) {
+		fmt.Printf("%s|--@%s:\n", indent, child)
+		printGroupTree(inv, child, indent+"|  ")
+
+		for _, v := range inv.GroupHosts(child) {
+			inChildren[v.Alias] = true
+		}
+	}
+
+	for _, v := range inv.GroupHosts(group) {
+		if !inChildren[v.Alias] {
+			fmt.Printf("%s|--%s\n", indent, v.Alias)
+		}
+	}
+}
+
+// maskVaultValue masks vault ciphertexts in value.
+func maskVaultValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if aes.IsAES256CipherText(v) {
+			return maskedVaultValue
+		}
+	case []interface{}:
+		masked := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			masked = append(masked, maskVaultValue(item))
+		}
+
+		return masked
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			masked[k] = maskVaultValue(item)
+		}
+
+		return masked
+	}
+
+	return value
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
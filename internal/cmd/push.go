@@ -23,17 +23,13 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
 	"os"
-	"path"
-	"path/filepath"
-	"strings"
-	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/serialt/gosible/internal/pkg/configflags"
 	"github.com/serialt/gosible/internal/pkg/sshtask"
+	"github.com/serialt/gosible/pkg/batchssh"
 	"github.com/serialt/gosible/pkg/util"
 )
 
@@ -41,6 +37,7 @@ var (
 	files          []string
 	fileDstPath    string
 	allowOverwrite bool
+	transferMethod string
 )
 
 // pushCmd represents the push command
@@ -70,40 +67,15 @@ Copy local files and dirs to target hosts.`,
 				}
 			}
 		}
+
+		util.CheckErr(configflags.ValidateTransferMethod(transferMethod))
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		task := sshtask.NewTask(sshtask.PushTask, configflags.Config)
 
-		var zipFiles []string
-
-		workDir, err := os.Getwd()
-		if err != nil {
-			util.CheckErr(err)
-		}
-
-		for _, f := range files {
-			fileName := filepath.Base(f)
-			zipName := "." + fileName + "." + fmt.Sprintf("%d", time.Now().UnixMicro())
-			zipFile := path.Join(workDir, zipName)
-
-			if err := util.Zip(strings.TrimSuffix(f, string(os.PathSeparator)), zipFile); err != nil {
-				util.CheckErr(err)
-			}
-
-			zipFiles = append(zipFiles, zipFile)
-		}
-
-		defer func() {
-			for _, f := range zipFiles {
-				if err := os.Remove(f); err != nil {
-					fmt.Printf("Warning: %v\n", err)
-				}
-			}
-		}()
-
 		task.SetTargetHosts(args)
-		task.SetPushfiles(files, zipFiles)
-		task.SetPushOptions(fileDstPath, allowOverwrite)
+		task.SetPushfiles(files)
+		task.SetPushOptions(fileDstPath, allowOverwrite, transferMethod)
 
 		task.Start()
 
@@ -128,6 +100,11 @@ func init() {
 		"allow overwrite files/dirs if they already exist on target hosts",
 	)
 
+	pushCmd.Flags().StringVarP(&transferMethod, "method", "m", batchssh.TransferAuto,
+		`method of copying files/dirs, available methods: auto, sftp, tar
+(auto uses tar if it exists on target hosts, otherwise sftp)`,
+	)
+
 	pushCmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
 		util.CobraMarkHiddenGlobalFlags(
 			command,
@@ -65,9 +65,12 @@ func init() {
 		scriptCmd,
 		pushCmd,
 		fetchCmd,
+		syncCmd,
+		inventoryCmd,
 		vault.Cmd,
 		configCmd,
 		versionCmd,
+		controlMasterCmd,
 	)
 
 	localFlags := rootCmd.Flags()
@@ -78,6 +81,7 @@ func init() {
 
 	configFlags := configflags.New()
 	configFlags.AddFlagsTo(persistentFlags)
+	rootCmd.SetGlobalNormalizationFunc(configflags.NormalizeFlagName)
 
 	persistentFlags.StringVarP(&cfgFile, cfgFileFlag, "", "", "config file (default {$PWD,$HOME}/.gossh.yaml)")
 }
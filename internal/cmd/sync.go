@@ -0,0 +1,119 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/serialt/gosible/internal/pkg/configflags"
+	"github.com/serialt/gosible/internal/pkg/sshtask"
+	"github.com/serialt/gosible/pkg/batchssh"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+var (
+	syncFiles   []string
+	syncDstPath string
+	syncOptions batchssh.SyncOptions
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync [HOST...]",
+	Short: "Sync local files and dirs to target hosts",
+	Long: `
+Sync local files and dirs to target hosts, only changed files are copied.`,
+	Example: `
+  # Sync local dir /etc/nginx/ to /etc/nginx/ of the target hosts.
+  $ gossh sync host[1-2] -f /etc/nginx -d /etc -k
+
+  # Compare files by checksums, and delete remote files that do not exist locally.
+  $ gossh sync host[1-2] -f /etc/nginx -d /etc --checksum --delete -k
+
+  # Only sync '*.conf' files, except files in dir 'nginx/backup'.
+  $ gossh sync host[1-2] -f /etc/nginx -d /etc --include '*.conf' --exclude nginx/backup -k
+
+  Find more examples at: https://github.com/serialt/gosible/blob/main/docs/sync.md`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if errs := configflags.Config.Validate(); len(errs) != 0 {
+			util.CheckErr(errs)
+		}
+
+		for _, f := range syncFiles {
+			_, err := os.Stat(f)
+			if err != nil {
+				util.CheckErr(err)
+			}
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		task := sshtask.NewTask(sshtask.SyncTask, configflags.Config)
+
+		task.SetTargetHosts(args)
+		task.SetPushfiles(syncFiles)
+		task.SetSyncOptions(syncDstPath, syncOptions)
+
+		task.Start()
+
+		util.CobraCheckErrWithHelp(cmd, task.CheckErr())
+	},
+}
+
+func init() {
+	syncCmd.Flags().StringSliceVarP(&syncFiles, "files", "f", nil,
+		"local files/dirs to be synced to target hosts",
+	)
+
+	syncCmd.Flags().StringVarP(&syncDstPath, "dest-path", "d", "",
+		"path of target hosts where files/dirs will be synced to",
+	)
+
+	syncCmd.Flags().BoolVar(&syncOptions.Checksum, "checksum", false,
+		"compare files by sha256 checksums instead of sizes and modification times",
+	)
+
+	syncCmd.Flags().BoolVar(&syncOptions.Delete, "delete", false,
+		"delete files/dirs on target hosts that do not exist locally or are of different types",
+	)
+
+	syncCmd.Flags().StringSliceVar(&syncOptions.Includes, "include", nil,
+		"only sync files whose paths or names match the glob patterns",
+	)
+
+	syncCmd.Flags().StringSliceVar(&syncOptions.Excludes, "exclude", nil,
+		"do not sync or delete files/dirs whose paths or names match the glob patterns",
+	)
+
+	syncCmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		util.CobraMarkHiddenGlobalFlags(
+			command,
+			"run.sudo",
+			"run.as-user",
+			"run.lang",
+		)
+
+		command.Parent().HelpFunc()(command, strings)
+	})
+}
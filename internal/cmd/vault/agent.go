@@ -0,0 +1,470 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/serialt/gosible/pkg/log"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+const (
+	// agentSocketEnv overrides the default socket of the vault agent.
+	agentSocketEnv = "GOSSH_VAULT_AGENT_SOCK"
+
+	agentTimeout = 3 * time.Second
+
+	agentOpGet    = "get"
+	agentOpSet    = "set"
+	agentOpDelete = "delete"
+	agentOpStatus = "status"
+	agentOpStop   = "stop"
+)
+
+var (
+	agentTTL        int
+	agentForeground bool
+	agentStop       bool
+	agentStatus     bool
+)
+
+// agentCmd represents the vault agent command
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Cache vault passwords for subsequent gossh commands",
+	Long: `
+Start a vault agent in background, which caches vault passwords from terminal
+prompt for a while, so that subsequent gossh commands do not ask for them again.
+
+The agent listens on a unix socket that only accessible by the current user,
+default is $HOME/.gossh/vault-agent.sock, it can be changed by environment
+variable GOSSH_VAULT_AGENT_SOCK. The dir of the socket must be owned by the
+current user with permissions 0700. On Linux, connections from processes of
+other users are refused as well, and gossh commands do not send vault passwords
+to an agent run by another user.
+
+Vault passwords are cached only after they decrypted content successfully
+or were confirmed, and are removed from the agent when they expire or fail.`,
+	Example: `
+  # Start the vault agent, cached vault passwords expire in 15 minutes.
+  $ gossh vault agent
+
+  # Start the vault agent, cached vault passwords expire in 1 hour.
+  $ gossh vault agent --ttl 3600
+
+  # Show vault ids cached by the vault agent.
+  $ gossh vault agent --status
+
+  # Stop the vault agent and forget all vault passwords.
+  $ gossh vault agent --stop`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if agentTTL < 0 {
+			util.CobraCheckErrWithHelp(cmd, "--ttl must be equal to or gather than 0")
+		}
+
+		socket := agentSocket()
+
+		switch {
+		case agentStop:
+			_, err := agentRequest(agentMessage{Op: agentOpStop})
+			util.CheckErr(err)
+
+			fmt.Printf("Vault agent stopped\n")
+		case agentStatus:
+			printAgentStatus(socket)
+		case agentForeground:
+			util.CheckErr(serveAgent(socket, time.Duration(agentTTL)*time.Second))
+		default:
+			if _, err := agentRequest(agentMessage{Op: agentOpStatus}); err == nil {
+				fmt.Printf("Vault agent is already running, socket: %s\n", socket)
+				return
+			}
+
+			util.CheckErr(startAgent(socket))
+
+			fmt.Printf("Vault agent started, socket: %s\n", socket)
+		}
+	},
+}
+
+func init() {
+	agentCmd.Flags().IntVarP(&agentTTL, "ttl", "t", 900,
+		"seconds that vault passwords are cached, 0 means until the agent stops")
+	agentCmd.Flags().BoolVarP(&agentForeground, "foreground", "f", false,
+		"run the agent in foreground")
+	agentCmd.Flags().BoolVar(&agentStop, "stop", false, "stop the running agent")
+	agentCmd.Flags().BoolVar(&agentStatus, "status", false, "show vault ids cached by the running agent")
+}
+
+// agentMessage is both the request and the response of the vault agent,
+// one message is exchanged on each connection.
+type agentMessage struct {
+	Op       string             `json:"op,omitempty"`
+	Label    string             `json:"label,omitempty"`
+	Password string             `json:"password,omitempty"`
+	Entries  []agentEntryStatus `json:"entries,omitempty"`
+	Error    string             `json:"error,omitempty"`
+}
+
+type agentEntryStatus struct {
+	Label   string    `json:"label"`
+	Expires time.Time `json:"expires"`
+}
+
+func agentSocket() string {
+	if socket := os.Getenv(agentSocketEnv); socket != "" {
+		return socket
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".gossh", "vault-agent.sock")
+}
+
+// agentRequest sends a request to the running vault agent.
+func agentRequest(req agentMessage) (*agentMessage, error) {
+	socket := agentSocket()
+	if socket == "" {
+		return nil, errors.New("no socket of vault agent")
+	}
+
+	return requestAgent(socket, req)
+}
+
+// requestAgent sends a request to the vault agent listening on socket.
+// Vault passwords are sent to the agent, so the socket must be in a directory
+// only current user can access and listened by a process of current user.
+func requestAgent(socket string, req agentMessage) (*agentMessage, error) {
+	if err := util.CheckPrivateDir(filepath.Dir(socket)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("vault agent is not running: %w", err)
+		}
+
+		return nil, fmt.Errorf("unsafe vault agent dir: %w", err)
+	}
+
+	conn, err := net.DialTimeout("unix", socket, agentTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("vault agent is not running: %w", err)
+	}
+	defer conn.Close()
+
+	if err := util.CheckPeerUser(conn); err != nil {
+		return nil, fmt.Errorf("refuse vault agent: %w", err)
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(agentTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	var resp agentMessage
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// getAgentPassword returns the vault password of the label cached by the
+// vault agent, it is empty if there is no running agent or no such password.
+func getAgentPassword(label string) string {
+	resp, err := agentRequest(agentMessage{Op: agentOpGet, Label: label})
+	if err != nil {
+		log.Debugf("Vault: get vault password of '%s' from agent failed: %s", labelName(label), err)
+		return ""
+	}
+
+	return resp.Password
+}
+
+func setAgentPassword(label, password string) {
+	if _, err := agentRequest(agentMessage{Op: agentOpSet, Label: label, Password: password}); err != nil {
+		log.Debugf("Vault: cache vault password of '%s' in agent failed: %s", labelName(label), err)
+		return
+	}
+
+	log.Debugf("Vault: cached vault password of '%s' in agent", labelName(label))
+}
+
+func deleteAgentPassword(label string) {
+	if _, err := agentRequest(agentMessage{Op: agentOpDelete, Label: label}); err != nil {
+		log.Debugf("Vault: delete vault password of '%s' from agent failed: %s", labelName(label), err)
+	}
+}
+
+func labelName(label string) string {
+	if label == "" {
+		return "default"
+	}
+
+	return label
+}
+
+func printAgentStatus(socket string) {
+	resp, err := agentRequest(agentMessage{Op: agentOpStatus})
+	util.CheckErr(err)
+
+	fmt.Printf("Vault agent is running, socket: %s\n", socket)
+
+	for _, v := range resp.Entries {
+		expires := "never expires"
+		if !v.Expires.IsZero() {
+			expires = fmt.Sprintf("expires in %s", time.Until(v.Expires).Round(time.Second))
+		}
+
+		fmt.Printf("  %s: %s\n", labelName(v.Label), expires)
+	}
+}
+
+// startAgent runs 'gossh vault agent --foreground' in background, and waits
+// until it is ready.
+func startAgent(socket string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	//nolint:gosec
+	cmd := exec.Command(executable, "vault", "agent", "--foreground", "--ttl", strconv.Itoa(agentTTL),
+		"--output.quiet", "--output.file=", "--output.verbose=false")
+	cmd.Env = append(os.Environ(), agentSocketEnv+"="+socket)
+	util.DetachProcess(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	deadline := time.Now().Add(agentTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("vault agent exited: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		if _, err := agentRequest(agentMessage{Op: agentOpStatus}); err == nil {
+			return nil
+		}
+	}
+
+	return errors.New("vault agent is not ready in time")
+}
+
+// vaultAgent caches vault passwords by labels.
+type vaultAgent struct {
+	mu      sync.Mutex
+	entries map[string]agentEntry
+	ttl     time.Duration
+
+	ln net.Listener
+}
+
+type agentEntry struct {
+	password string
+	expires  time.Time
+}
+
+func serveAgent(socket string, ttl time.Duration) error {
+	//nolint:gomnd
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return err
+	}
+
+	// MkdirAll keeps the permissions of an existing dir.
+	if err := util.CheckPrivateDir(filepath.Dir(socket)); err != nil {
+		return fmt.Errorf("unsafe vault agent dir: %w", err)
+	}
+
+	// Remove the socket of a dead agent, but never the socket of a running
+	// agent or a file that is not a socket.
+	if info, err := os.Lstat(socket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("'%s' exists and is not a socket", socket)
+		}
+
+		if _, err := requestAgent(socket, agentMessage{Op: agentOpStatus}); err == nil {
+			return fmt.Errorf("vault agent is already running, socket: %s", socket)
+		}
+
+		log.Debugf("Vault Agent: remove stale socket '%s'", socket)
+		_ = os.Remove(socket)
+	}
+
+	//nolint:gomnd
+	oldMask := util.Umask(0077)
+	ln, err := net.Listen("unix", socket)
+	util.Umask(oldMask)
+	if err != nil {
+		return err
+	}
+
+	a := &vaultAgent{
+		entries: make(map[string]agentEntry),
+		ttl:     ttl,
+		ln:      ln,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		ln.Close()
+	}()
+
+	if ttl > 0 {
+		go a.expire()
+	}
+
+	log.Debugf("Vault Agent: listening on '%s'", socket)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			break
+		}
+
+		go a.handle(conn)
+	}
+
+	_ = os.Remove(socket)
+
+	return nil
+}
+
+func (a *vaultAgent) handle(conn net.Conn) {
+	defer conn.Close()
+
+	if err := util.CheckPeerUser(conn); err != nil {
+		log.Debugf("Vault Agent: refuse connection: %s", err)
+		return
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(agentTimeout))
+
+	var req agentMessage
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	resp := a.do(req)
+
+	_ = json.NewEncoder(conn).Encode(resp)
+
+	if req.Op == agentOpStop {
+		a.ln.Close()
+	}
+}
+
+func (a *vaultAgent) do(req agentMessage) agentMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch req.Op {
+	case agentOpGet:
+		entry, ok := a.entries[req.Label]
+		if !ok || a.expired(entry) {
+			delete(a.entries, req.Label)
+			return agentMessage{}
+		}
+
+		return agentMessage{Password: entry.password}
+	case agentOpSet:
+		entry := agentEntry{password: req.Password}
+		if a.ttl > 0 {
+			entry.expires = time.Now().Add(a.ttl)
+		}
+		a.entries[req.Label] = entry
+
+		return agentMessage{}
+	case agentOpDelete:
+		delete(a.entries, req.Label)
+
+		return agentMessage{}
+	case agentOpStatus:
+		resp := agentMessage{}
+		for label, entry := range a.entries {
+			if !a.expired(entry) {
+				resp.Entries = append(resp.Entries, agentEntryStatus{Label: label, Expires: entry.expires})
+			}
+		}
+		sort.Slice(resp.Entries, func(i, j int) bool { return resp.Entries[i].Label < resp.Entries[j].Label })
+
+		return resp
+	case agentOpStop:
+		a.entries = make(map[string]agentEntry)
+
+		return agentMessage{}
+	default:
+		return agentMessage{Error: fmt.Sprintf("unknown operation '%s'", req.Op)}
+	}
+}
+
+func (a *vaultAgent) expired(entry agentEntry) bool {
+	return !entry.expires.IsZero() && time.Now().After(entry.expires)
+}
+
+// expire removes expired vault passwords from memory.
+func (a *vaultAgent) expire() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.mu.Lock()
+		for label, entry := range a.entries {
+			if a.expired(entry) {
+				delete(a.entries, label)
+			}
+		}
+		a.mu.Unlock()
+	}
+}
@@ -0,0 +1,133 @@
+package vault
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestAgent serves a vault agent on socket until the test ends.
+func startTestAgent(t *testing.T, socket string) {
+	t.Helper()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- serveAgent(socket, 0)
+	}()
+
+	t.Cleanup(func() {
+		_, _ = requestAgent(socket, agentMessage{Op: agentOpStop})
+		<-served
+	})
+
+	deadline := time.Now().Add(agentTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-served:
+			served <- err
+			t.Fatalf("serveAgent() exited: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		if _, err := requestAgent(socket, agentMessage{Op: agentOpStatus}); err == nil {
+			return
+		}
+	}
+
+	t.Fatal("vault agent is not ready in time")
+}
+
+// agentTempDir returns a temp dir only current user can access, the vault
+// agent refuses sockets in other dirs.
+func agentTempDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestServeAgent(t *testing.T) {
+	t.Run("cache passwords", func(t *testing.T) {
+		socket := filepath.Join(agentTempDir(t), "agent.sock")
+		startTestAgent(t, socket)
+
+		if _, err := requestAgent(socket, agentMessage{Op: agentOpSet, Label: "prod", Password: "secret"}); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := requestAgent(socket, agentMessage{Op: agentOpGet, Label: "prod"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Password != "secret" {
+			t.Errorf("password of prod = %q, want %q", resp.Password, "secret")
+		}
+	})
+
+	t.Run("refuse to replace running agent", func(t *testing.T) {
+		socket := filepath.Join(agentTempDir(t), "agent.sock")
+		startTestAgent(t, socket)
+
+		err := serveAgent(socket, 0)
+		if err == nil || !strings.Contains(err.Error(), "already running") {
+			t.Fatalf("serveAgent() error = %v, want already running", err)
+		}
+
+		if _, err := requestAgent(socket, agentMessage{Op: agentOpStatus}); err != nil {
+			t.Errorf("running agent is broken: %v", err)
+		}
+	})
+
+	t.Run("refuse to replace file", func(t *testing.T) {
+		socket := filepath.Join(agentTempDir(t), "agent.sock")
+		if err := ioutil.WriteFile(socket, []byte("data"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		err := serveAgent(socket, 0)
+		if err == nil || !strings.Contains(err.Error(), "not a socket") {
+			t.Fatalf("serveAgent() error = %v, want not a socket", err)
+		}
+	})
+
+	t.Run("replace stale socket", func(t *testing.T) {
+		socket := filepath.Join(agentTempDir(t), "agent.sock")
+
+		ln, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln.(*net.UnixListener).SetUnlinkOnClose(false)
+		ln.Close()
+
+		startTestAgent(t, socket)
+	})
+	t.Run("unsafe dir", func(t *testing.T) {
+		dir := agentTempDir(t)
+		socket := filepath.Join(dir, "agent.sock")
+		startTestAgent(t, socket)
+
+		if err := os.Chmod(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(dir, 0o700) //nolint:errcheck
+
+		_, err := requestAgent(socket, agentMessage{Op: agentOpSet, Label: "prod", Password: "secret"})
+		if err == nil || !strings.Contains(err.Error(), "unsafe") {
+			t.Errorf("requestAgent() error = %v, want unsafe dir", err)
+		}
+
+		err = serveAgent(filepath.Join(dir, "other.sock"), 0)
+		if err == nil || !strings.Contains(err.Error(), "unsafe") {
+			t.Errorf("serveAgent() error = %v, want unsafe dir", err)
+		}
+	})
+}
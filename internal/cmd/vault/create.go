@@ -0,0 +1,108 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/serialt/gosible/internal/pkg/aes"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// createCmd represents the vault create command
+var createCmd = &cobra.Command{
+	Use:   "create FILENAME",
+	Short: "Create vault encrypted file",
+	Long: `
+Create vault encrypted file by editor.
+
+The content is written in a private temporary file like 'gossh vault edit'
+does, and the file is created with mode 0600 only if the content is not empty.`,
+	Example: `
+  # Create a vault encrypted file by asking for vault password.
+  $ gossh vault create /path/auth.txt
+
+  # Create a vault encrypted file by vault password file or script.
+  $ gossh vault create /path/auth.txt -V /path/vault-password-file-or-script`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			util.CobraCheckErrWithHelp(cmd, "requires one arg to represent the file to be created")
+		}
+
+		if len(args) > 1 {
+			util.CobraCheckErrWithHelp(cmd, "to many args, only need one")
+		}
+
+		if _, err := os.Lstat(args[0]); err == nil {
+			util.CheckErr(fmt.Sprintf("file '%s' already exists, use 'gossh vault edit' to edit it", args[0]))
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		id := getEncryptIdentity()
+		vaultPass := id.getConfirmPassword()
+
+		file := args[0]
+
+		content, err := editContent(file, "")
+		if errors.Is(err, errNotChanged) {
+			fmt.Printf("Empty content, file not created\n")
+			return
+		}
+		util.CheckErr(err)
+
+		encryptContent, err := aes.AES256EncodeWithLabel(content, vaultPass, id.label)
+		if err != nil {
+			err = fmt.Errorf("encrypt failed: %w", err)
+		}
+		util.CheckErr(err)
+
+		util.CheckErr(writeNewFile(file, encryptContent))
+
+		fmt.Printf("Encryption successful\n")
+	},
+}
+
+func init() {
+	addEncryptVaultIDFlag(createCmd)
+}
+
+// writeNewFile writes content to a file which must not exist.
+func writeNewFile(file, content string) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
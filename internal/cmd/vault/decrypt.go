@@ -39,10 +39,10 @@ var decryptCmd = &cobra.Command{
 Decrypt content encrypted by vault.`,
 	Example: `
   # Decrypt cipher text by asking for vault password.
-  $ gossh vault decrypt GOSSH-AES256:a5c1b3c0cdad4669f84
+  $ gossh vault decrypt 'GOSSH-VAULT;2;a5c1b3c0cdad4669f84'
 
   # Decrypt cipher text by vault password file or script.
-  $ gossh vault decrypt GOSSH-AES256:a5c1b3c0cdad4669f84 -V /path/vault-password-file-or-script`,
+  $ gossh vault decrypt 'GOSSH-VAULT;2;a5c1b3c0cdad4669f84' -V /path/vault-password-file-or-script`,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			util.CobraCheckErrWithHelp(cmd, "requires one arg to represent the vault encrypted content")
@@ -59,8 +59,7 @@ Decrypt content encrypted by vault.`,
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		vaultPass := GetVaultPassword()
-		plainText, err := aes.AES256Decode(args[0], vaultPass)
+		plainText, err := Decrypt(args[0])
 		if err != nil {
 			err = fmt.Errorf("decrypt failed: %w", err)
 		}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/serialt/gosible/internal/pkg/aes"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// decryptFieldsCmd represents the vault decrypt-fields command
+var decryptFieldsCmd = &cobra.Command{
+	Use:   "decrypt-fields FILENAME...",
+	Short: "Decrypt values of fields in inventory files and the configuration file",
+	Long: `
+Decrypt encrypted values of fields in place, it is the reverse of
+'gossh vault encrypt-fields'.
+
+All encrypted values are decrypted if flag '--key' is not given. Values
+which would be read as other types than string such as numbers are quoted
+after decrypted, other content of the files is kept unchanged.`,
+	Example: `
+  # Decrypt passwords and passphrases in an inventory file by asking for vault password.
+  $ gossh vault decrypt-fields /path/hosts.yaml --key password,passphrase
+
+  # Decrypt all encrypted values in an INI-like inventory file
+  # by vault password file or script.
+  $ gossh vault decrypt-fields /path/hosts -V /path/vault-password-file-or-script`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			util.CobraCheckErrWithHelp(cmd, "requires at least one arg to represent files to be decrypted")
+		}
+
+		for _, file := range args {
+			if !util.FileExists(file) {
+				util.CheckErr(fmt.Sprintf("file '%s' not found", file))
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		results, err := rewriteFieldsFiles(args, fieldKeys, func(key, value string) (string, error) {
+			if !aes.IsAES256CipherText(value) {
+				return value, nil
+			}
+
+			plainText, _, err := decrypt(value)
+
+			return plainText, err
+		})
+		util.CheckErr(err)
+
+		util.CheckErr(writeRewriteResults(results))
+
+		printRewriteResults(results, "values", "decrypted")
+	},
+}
+
+func init() {
+	decryptFieldsCmd.Flags().StringSliceVar(
+		&fieldKeys,
+		"key",
+		nil,
+		"names or dotted paths of fields to be decrypted, all encrypted values if not given",
+	)
+}
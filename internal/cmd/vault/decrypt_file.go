@@ -70,11 +70,9 @@ Decrypt vault encrypted file.`,
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		vaultPass := GetVaultPassword()
-
 		file := args[0]
 
-		content, err := decryptFile(file, vaultPass)
+		content, err := decryptFile(file)
 		util.CheckErr(err)
 
 		handleOutput(content, file, deOutputFile)
@@ -93,7 +91,21 @@ func init() {
 	)
 }
 
-func decryptFile(file, vaultPass string) (string, error) {
+func decryptFile(file string) (string, error) {
+	content, err := readEncryptedFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	decryptContent, err := Decrypt(content)
+	if err != nil {
+		return "", fmt.Errorf("decrypt failed: %w", err)
+	}
+
+	return decryptContent, nil
+}
+
+func readEncryptedFile(file string) (string, error) {
 	p, err := ioutil.ReadFile(file)
 	if err != nil {
 		return "", err
@@ -105,10 +117,5 @@ func decryptFile(file, vaultPass string) (string, error) {
 		return "", fmt.Errorf("'%s' is not vault encrypted file", file)
 	}
 
-	decryptContent, err := aes.AES256Decode(content, vaultPass)
-	if err != nil {
-		return "", fmt.Errorf("decrypt failed: %w", err)
-	}
-
-	return decryptContent, nil
+	return content, nil
 }
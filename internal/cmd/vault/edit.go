@@ -0,0 +1,245 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/serialt/gosible/internal/pkg/aes"
+	"github.com/serialt/gosible/pkg/log"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// errNotChanged is returned by editContent when the content is not changed.
+var errNotChanged = errors.New("content not changed")
+
+// editCmd represents the vault edit command
+var editCmd = &cobra.Command{
+	Use:   "edit FILENAME",
+	Short: "Edit vault encrypted file",
+	Long: `
+Edit vault encrypted file in editor.
+
+The file is decrypted into a private temporary file (mode 0600, in a tmpfs
+such as /dev/shm when available), which is opened by the editor of
+environment variable VISUAL or EDITOR (default vi). The file is re-encrypted
+only if the content is changed, and the temporary file is overwritten and
+removed after the editor exits.`,
+	Example: `
+  # Edit a vault encrypted file by asking for vault password.
+  $ gossh vault edit /path/auth.txt
+
+  # Edit a vault encrypted file by vault password file or script with another editor.
+  $ EDITOR=nano gossh vault edit /path/auth.txt -V /path/vault-password-file-or-script`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			util.CobraCheckErrWithHelp(cmd, "requires one arg to represent the vault encrypted file")
+		}
+
+		if len(args) > 1 {
+			util.CobraCheckErrWithHelp(cmd, "to many args, only need one")
+		}
+
+		if !util.FileExists(args[0]) {
+			util.CheckErr(fmt.Sprintf("file '%s' not found", args[0]))
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		file := args[0]
+
+		cipherText, err := readEncryptedFile(file)
+		util.CheckErr(err)
+
+		content, id, err := decrypt(cipherText)
+		if err != nil {
+			err = fmt.Errorf("decrypt failed: %w", err)
+		}
+		util.CheckErr(err)
+
+		newContent, err := editContent(file, content)
+		if errors.Is(err, errNotChanged) {
+			fmt.Printf("File not changed\n")
+			return
+		}
+		util.CheckErr(err)
+
+		// The label of the file is kept.
+		encryptContent, err := aes.AES256EncodeWithLabel(newContent, id.getPassword(), aes.CipherTextLabel(cipherText))
+		if err != nil {
+			err = fmt.Errorf("encrypt failed: %w", err)
+		}
+		util.CheckErr(err)
+
+		util.CheckErr(util.WriteFileAtomic(file, []byte(encryptContent)))
+
+		fmt.Printf("Encryption successful\n")
+	},
+}
+
+// editContent opens content in the editor through a private temporary file,
+// and returns the edited content. The name of the temporary file ends with
+// the base name of file, so that editors can detect the file type.
+func editContent(file, content string) (string, error) {
+	dir, err := privateTempDir()
+	if err != nil {
+		return "", fmt.Errorf("create temporary directory failed: %w", err)
+	}
+	defer removeTempDir(dir)
+
+	tmpFile := filepath.Join(dir, filepath.Base(file))
+	if err := ioutil.WriteFile(tmpFile, []byte(content), 0600); err != nil {
+		return "", err
+	}
+
+	log.Debugf("Vault: decrypted content is written to temporary file '%s'", tmpFile)
+
+	if err := runEditor(tmpFile); err != nil {
+		return "", err
+	}
+
+	p, err := ioutil.ReadFile(tmpFile)
+	if err != nil {
+		return "", err
+	}
+
+	if string(p) == content {
+		return "", errNotChanged
+	}
+
+	return string(p), nil
+}
+
+func runEditor(file string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// The editor may be given with args, e.g. 'code --wait'.
+	fields := strings.Fields(editor)
+
+	cmd := exec.Command(fields[0], append(fields[1:], file)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Ctrl-C is for the editor, gossh must not exit without removing
+	// the temporary file.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	log.Debugf("Vault: run editor '%s'", editor)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("run editor '%s' failed: %w", editor, err)
+	}
+
+	return nil
+}
+
+// privateTempDir creates a temporary directory only accessible by the
+// current user, directories in memory are preferred.
+func privateTempDir() (string, error) {
+	var candidates []string
+	if runtime.GOOS == "linux" {
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			candidates = append(candidates, dir)
+		}
+		candidates = append(candidates, "/dev/shm")
+	}
+	candidates = append(candidates, os.TempDir())
+
+	var err error
+	for _, v := range candidates {
+		var dir string
+		dir, err = ioutil.TempDir(v, "gossh-vault-")
+		if err != nil {
+			log.Debugf("Vault: create temporary directory in '%s' failed: %s", v, err)
+			continue
+		}
+
+		if err = os.Chmod(dir, 0700); err != nil {
+			os.Remove(dir)
+			continue
+		}
+
+		return dir, nil
+	}
+
+	return "", err
+}
+
+// removeTempDir overwrites all files in the directory with zeros before
+// removing the directory, including swap and backup files of editors.
+func removeTempDir(dir string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Debugf("Vault: read temporary directory '%s' failed: %s", dir, err)
+	}
+
+	for _, v := range files {
+		if !v.Mode().IsRegular() {
+			continue
+		}
+
+		file := filepath.Join(dir, v.Name())
+		if err := shredFile(file, v.Size()); err != nil {
+			log.Debugf("Vault: overwrite temporary file '%s' failed: %s", file, err)
+		}
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		log.Warnf("remove temporary directory '%s' failed: %s", dir, err)
+	}
+}
+
+func shredFile(file string, size int64) error {
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(bytes.Repeat([]byte{0}, int(size))); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
@@ -0,0 +1,77 @@
+package vault
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// setTestEditor sets an editor script that runs the shell command on the
+// file in $1, and records the file in record.
+func setTestEditor(t *testing.T, command string) (record string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	record = filepath.Join(dir, "record")
+
+	editor := filepath.Join(dir, "editor.sh")
+	content := "#!/bin/sh\necho \"$1\" > " + shellQuoteForTest(record) + "\n" + command + "\n"
+	if err := ioutil.WriteFile(editor, []byte(content), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("VISUAL", editor)
+
+	return record
+}
+
+func shellQuoteForTest(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func TestEditContent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on windows")
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		want    string
+		wantErr error
+	}{
+		{name: "edited", command: `echo "password: new" > "$1"`, want: "password: new\n"},
+		{name: "not changed", command: "true", wantErr: errNotChanged},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := setTestEditor(t, tt.command)
+
+			got, err := editContent("/path/vars.yaml", "password: old\n")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("editContent() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("editContent() = %q, want %q", got, tt.want)
+			}
+
+			p, err := ioutil.ReadFile(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tmpFile := strings.TrimSpace(string(p))
+
+			if filepath.Base(tmpFile) != "vars.yaml" {
+				t.Errorf("temporary file %s does not end with the base name of file", tmpFile)
+			}
+			if _, err := os.Stat(filepath.Dir(tmpFile)); !os.IsNotExist(err) {
+				t.Errorf("temporary directory of %s is not removed: %v", tmpFile, err)
+			}
+		})
+	}
+}
@@ -45,7 +45,10 @@ Encrypt sensitive content.`,
   $ gossh vault encrypt "your-sensitive-plaintext" -V /path/vault-password-file-or-script
 
   # Encrypt plaintext from terminal prompt.
-  $ gossh vault encrypt -V /path/vault-password-file`,
+  $ gossh vault encrypt -V /path/vault-password-file
+
+  # Encrypt plaintext by the vault id 'prod', the ciphertext carries the label 'prod'.
+  $ gossh vault encrypt "your-sensitive-plaintext" --auth.vault-id prod@/path/prod-password-file`,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) > 1 {
 			util.CobraCheckErrWithHelp(cmd, "to many args, only need one")
@@ -54,7 +57,8 @@ Encrypt sensitive content.`,
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		vaultPass := getVaultConfirmPassword()
+		id := getEncryptIdentity()
+		vaultPass := id.getConfirmPassword()
 
 		plainPassword, err := getPlainPassword(args)
 		if err != nil {
@@ -62,7 +66,7 @@ Encrypt sensitive content.`,
 		}
 		util.CheckErr(err)
 
-		encryptContent, err := aes.AES256Encode(plainPassword, vaultPass)
+		encryptContent, err := aes.AES256EncodeWithLabel(plainPassword, vaultPass, id.label)
 		if err != nil {
 			err = fmt.Errorf("encrypt failed: %w", err)
 		}
@@ -72,6 +76,10 @@ Encrypt sensitive content.`,
 	},
 }
 
+func init() {
+	addEncryptVaultIDFlag(encryptCmd)
+}
+
 func getPlainPassword(args []string) (string, error) {
 	if len(args) == 1 {
 		return args[0], nil
@@ -0,0 +1,113 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/serialt/gosible/internal/pkg/aes"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// encryptFieldsCmd represents the vault encrypt-fields command
+var encryptFieldsCmd = &cobra.Command{
+	Use:   "encrypt-fields FILENAME...",
+	Short: "Encrypt values of fields in inventory files and the configuration file",
+	Long: `
+Encrypt values of the fields given by flag '--key' in place.
+
+Supported files are INI-like, YAML and JSON inventory files, vars files in
+'group_vars' and 'host_vars', and the YAML configuration file. Only the values
+are replaced by ciphertexts, other content such as comments, blank lines and
+the order of fields is kept unchanged. Values that are already encrypted are
+skipped.
+
+A key matches fields of the name at any level, or fields of a dotted path
+such as 'auth.password' in YAML and JSON files.
+
+All values are encrypted before any file is written, and each file is
+replaced atomically.`,
+	Example: `
+  # Encrypt passwords and passphrases in an inventory file by asking for vault password.
+  $ gossh vault encrypt-fields /path/hosts.yaml --key password,passphrase
+
+  # Encrypt passwords in an INI-like inventory file and its vars files
+  # by vault password file or script.
+  $ gossh vault encrypt-fields /path/hosts /path/group_vars/all.yaml --key password \
+      -V /path/vault-password-file-or-script
+
+  # Encrypt the password of the configuration file.
+  $ gossh vault encrypt-fields ~/.gossh.yaml --key auth.password`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			util.CobraCheckErrWithHelp(cmd, "requires at least one arg to represent files to be encrypted")
+		}
+
+		if len(fieldKeys) == 0 {
+			util.CobraCheckErrWithHelp(cmd, "requires flag '--key' to represent fields to be encrypted")
+		}
+
+		for _, file := range args {
+			if !util.FileExists(file) {
+				util.CheckErr(fmt.Sprintf("file '%s' not found", file))
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		id := getEncryptIdentity()
+
+		// The vault password is asked only if there are values to be encrypted.
+		vaultPass := ""
+		results, err := rewriteFieldsFiles(args, fieldKeys, func(key, value string) (string, error) {
+			if aes.IsAES256CipherText(value) {
+				return value, nil
+			}
+
+			if vaultPass == "" {
+				vaultPass = id.getConfirmPassword()
+			}
+
+			return aes.AES256EncodeWithLabel(value, vaultPass, id.label)
+		})
+		util.CheckErr(err)
+
+		util.CheckErr(writeRewriteResults(results))
+
+		printRewriteResults(results, "values", "encrypted")
+	},
+}
+
+func init() {
+	encryptFieldsCmd.Flags().StringSliceVar(
+		&fieldKeys,
+		"key",
+		nil,
+		"names or dotted paths of fields to be encrypted, e.g. password,passphrase,auth.password",
+	)
+
+	addEncryptVaultIDFlag(encryptFieldsCmd)
+}
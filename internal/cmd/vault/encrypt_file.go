@@ -72,11 +72,12 @@ Encrypt a file.`,
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		vaultPass := getVaultConfirmPassword()
+		id := getEncryptIdentity()
+		vaultPass := id.getConfirmPassword()
 
 		file := args[0]
 
-		content, err := encryptFile(file, vaultPass)
+		content, err := encryptFile(file, vaultPass, id.label)
 		util.CheckErr(err)
 
 		handleOutput(content, file, outputFile)
@@ -93,6 +94,8 @@ func init() {
 		"",
 		"file that encrypted content is written to (use - for stdout)",
 	)
+
+	addEncryptVaultIDFlag(encryptFileCmd)
 }
 
 func handleOutput(content, originalFile, newFile string) {
@@ -110,7 +113,7 @@ func handleOutput(content, originalFile, newFile string) {
 	util.CheckErr(err)
 }
 
-func encryptFile(file, vaultPass string) (string, error) {
+func encryptFile(file, vaultPass, label string) (string, error) {
 	p, err := ioutil.ReadFile(file)
 	if err != nil {
 		return "", err
@@ -122,7 +125,7 @@ func encryptFile(file, vaultPass string) (string, error) {
 		return "", fmt.Errorf("file '%s' is already encrypted", file)
 	}
 
-	encryptContent, err := aes.AES256Encode(content, vaultPass)
+	encryptContent, err := aes.AES256EncodeWithLabel(content, vaultPass, label)
 	if err != nil {
 		return "", fmt.Errorf("encrypt failed: %w", err)
 	}
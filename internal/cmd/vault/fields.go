@@ -0,0 +1,424 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/serialt/gosible/pkg/inventory"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// keys of fields to be encrypted or decrypted, given by flag '-k, --key'.
+var fieldKeys []string
+
+var iniTokenRegexp = regexp.MustCompile(`\S+`)
+
+// fieldFunc returns the new value of the field key, or the value itself to
+// keep the field unchanged.
+type fieldFunc func(key, value string) (string, error)
+
+// fieldEdit is a scalar value of a YAML/JSON mapping to be rewritten.
+type fieldEdit struct {
+	key  string
+	node *yaml.Node
+	// flow is true if the value is inside a flow mapping or sequence such as
+	// JSON content.
+	flow bool
+}
+
+// rewriteFieldsFiles rewrites values of fields matching keys in files by the
+// results of fn, it fails without any result if any value can not be
+// rewritten.
+func rewriteFieldsFiles(files, keys []string, fn fieldFunc) ([]rewriteResult, error) {
+	results := make([]rewriteResult, 0, len(files))
+
+	for _, file := range files {
+		p, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		content, count, err := rewriteFields(file, string(p), keys, fn)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", file, err)
+		}
+
+		results = append(results, rewriteResult{file: file, content: content, count: count})
+	}
+
+	return results, nil
+}
+
+// rewriteFields rewrites values of fields matching keys in content of file,
+// other content such as comments, blank lines and the order of fields is
+// kept unchanged.
+func rewriteFields(file, content string, keys []string, fn fieldFunc) (string, int, error) {
+	switch inventory.FormatOf(file) {
+	case inventory.FormatYAML:
+		return rewriteYAMLFields(content, keys, fn, false)
+	case inventory.FormatJSON:
+		return rewriteYAMLFields(content, keys, fn, true)
+	}
+
+	if isVarsFile(file) {
+		return rewriteYAMLFields(content, keys, fn, false)
+	}
+
+	if ok, _ := util.IsExecutable(file); ok {
+		return "", 0, errors.New("dynamic inventory executable is not supported")
+	}
+
+	return rewriteINIFields(content, keys, fn)
+}
+
+// isVarsFile reports whether file is in 'group_vars' or 'host_vars', or in a
+// directory of them, vars files are YAML even without extension.
+func isVarsFile(file string) bool {
+	dir := filepath.Dir(file)
+	for _, v := range []string{filepath.Base(dir), filepath.Base(filepath.Dir(dir))} {
+		if v == "group_vars" || v == "host_vars" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchKey reports whether the field of path matches any of keys, a key
+// matches the name of the field, or the tail of its dotted path such as
+// 'auth.password'. All fields match if keys is empty.
+func matchKey(keys, path []string) bool {
+	if len(keys) == 0 {
+		return true
+	}
+
+	name := strings.Join(path, ".")
+	for _, key := range keys {
+		if name == key || strings.HasSuffix(name, "."+key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//nolint:funlen,gocyclo
+func rewriteYAMLFields(content string, keys []string, fn fieldFunc, isJSON bool) (string, int, error) {
+	var edits []fieldEdit
+
+	var walk func(node *yaml.Node, path []string, flow bool)
+	walk = func(node *yaml.Node, path []string, flow bool) {
+		flow = flow || node.Style&yaml.FlowStyle != 0
+
+		switch node.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, v := range node.Content {
+				walk(v, path, flow)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				k, v := node.Content[i], node.Content[i+1]
+				if k.Kind != yaml.ScalarNode {
+					continue
+				}
+
+				p := append(path[:len(path):len(path)], k.Value)
+
+				if v.Kind != yaml.ScalarNode {
+					walk(v, p, flow)
+					continue
+				}
+
+				if v.Tag == "!!null" || v.Value == "" || !matchKey(keys, p) {
+					continue
+				}
+
+				edits = append(edits, fieldEdit{key: k.Value, node: v, flow: flow})
+			}
+		}
+	}
+
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return "", 0, err
+		}
+
+		walk(&doc, nil, false)
+	}
+
+	// Rewriting from the end of each line keeps positions of the other values
+	// in the same line valid.
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].node.Line != edits[j].node.Line {
+			return edits[i].node.Line < edits[j].node.Line
+		}
+		return edits[i].node.Column > edits[j].node.Column
+	})
+
+	lines := strings.SplitAfter(content, "\n")
+	count := 0
+
+	for _, edit := range edits {
+		node := edit.node
+
+		if node.Line < 1 || node.Line > len(lines) {
+			return "", 0, fmt.Errorf("invalid position of field '%s' at line %d", edit.key, node.Line)
+		}
+		line := lines[node.Line-1]
+
+		start, end, err := scalarBounds(line, node, edit.flow)
+		if err != nil {
+			return "", 0, fmt.Errorf("field '%s' at line %d: %w", edit.key, node.Line, err)
+		}
+
+		value, err := fn(edit.key, node.Value)
+		if err != nil {
+			return "", 0, fmt.Errorf("field '%s' at line %d: %w", edit.key, node.Line, err)
+		}
+		if value == node.Value {
+			continue
+		}
+
+		lines[node.Line-1] = line[:start] + formatYAMLScalar(value, node.Style, edit.flow, isJSON) + line[end:]
+		count++
+	}
+
+	return strings.Join(lines, ""), count, nil
+}
+
+// scalarBounds returns the byte offsets of the raw text of the scalar node in
+// line, only single line scalars are supported.
+//
+//nolint:gocyclo
+func scalarBounds(line string, node *yaml.Node, flow bool) (int, int, error) {
+	errNotSupported := errors.New("only single line plain or quoted values are supported")
+
+	if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle|yaml.TaggedStyle) != 0 || node.Anchor != "" {
+		return 0, 0, errNotSupported
+	}
+
+	// Column of yaml node counts characters rather than bytes.
+	start := 0
+	for i := 1; i < node.Column && start < len(line); i++ {
+		_, size := utf8.DecodeRuneInString(line[start:])
+		start += size
+	}
+
+	switch {
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		if !strings.HasPrefix(line[start:], `"`) {
+			return 0, 0, errNotSupported
+		}
+
+		for i := start + 1; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '"':
+				return start, i + 1, nil
+			}
+		}
+
+		return 0, 0, errNotSupported
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		if !strings.HasPrefix(line[start:], "'") {
+			return 0, 0, errNotSupported
+		}
+
+		for i := start + 1; i < len(line); i++ {
+			if line[i] != '\'' {
+				continue
+			}
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++
+				continue
+			}
+
+			return start, i + 1, nil
+		}
+
+		return 0, 0, errNotSupported
+	}
+
+	raw := strings.TrimRight(line[start:], "\r\n")
+	for i := 1; i < len(raw); i++ {
+		if raw[i] == '#' && (raw[i-1] == ' ' || raw[i-1] == '\t') {
+			raw = raw[:i]
+			break
+		}
+	}
+	if flow {
+		if i := strings.IndexAny(raw, ",]}"); i != -1 {
+			raw = raw[:i]
+		}
+	}
+	raw = strings.TrimRight(raw, " \t")
+
+	// A plain scalar folded from multiple lines differs from the raw text of
+	// its first line.
+	if raw != node.Value {
+		return 0, 0, errNotSupported
+	}
+
+	return start, start + len(raw), nil
+}
+
+// formatYAMLScalar formats value in the style of the original value, plain
+// values which would be resolved as other types than string, such as numbers
+// and booleans, are quoted.
+func formatYAMLScalar(value string, style yaml.Style, flow, isJSON bool) string {
+	if isJSON || style&yaml.DoubleQuotedStyle != 0 {
+		return quoteString(value)
+	}
+
+	if style&yaml.SingleQuotedStyle != 0 {
+		if !isPrintableLine(value) {
+			return quoteString(value)
+		}
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+
+	if !isPrintableLine(value) || (flow && strings.ContainsAny(value, ",[]{}")) {
+		return quoteString(value)
+	}
+
+	var m map[string]interface{}
+	if err := yaml.Unmarshal([]byte("v: "+value), &m); err != nil {
+		return quoteString(value)
+	}
+	if v, ok := m["v"].(string); !ok || v != value {
+		return quoteString(value)
+	}
+
+	return value
+}
+
+// quoteString quotes s as a JSON string, which is a valid YAML double quoted
+// string as well.
+func quoteString(s string) string {
+	var buf bytes.Buffer
+
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s)
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func isPrintableLine(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return false
+	}
+
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rewriteINIFields rewrites 'key=value' of host entries and group vars in
+// INI-like inventory.
+//
+//nolint:gocyclo
+func rewriteINIFields(content string, keys []string, fn fieldFunc) (string, int, error) {
+	lines := strings.SplitAfter(content, "\n")
+	count := 0
+	isChildren, isVars := false, false
+
+	for n, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "[") {
+			isChildren = strings.HasSuffix(trimmed, ":children]")
+			isVars = strings.HasSuffix(trimmed, ":vars]")
+			continue
+		}
+
+		if isChildren {
+			continue
+		}
+
+		tokens := iniTokenRegexp.FindAllStringIndex(line, -1)
+		if !isVars {
+			// the first field of host entry is the host alias.
+			tokens = tokens[1:]
+		}
+
+		for i := len(tokens) - 1; i >= 0; i-- {
+			start, end := tokens[i][0], tokens[i][1]
+
+			kv := strings.Split(line[start:end], "=")
+			if len(kv) != 2 || kv[1] == "" || !matchKey(keys, kv[:1]) {
+				continue
+			}
+
+			value, err := fn(kv[0], kv[1])
+			if err != nil {
+				return "", 0, fmt.Errorf("field '%s' at line %d: %w", kv[0], n+1, err)
+			}
+			if value == kv[1] {
+				continue
+			}
+
+			if value == "" || strings.ContainsAny(value, "=") || strings.IndexFunc(value, unicode.IsSpace) != -1 {
+				return "", 0, fmt.Errorf(
+					"field '%s' at line %d: empty value or value containing spaces or '=' is not supported in INI-like inventory",
+					kv[0],
+					n+1,
+				)
+			}
+
+			line = line[:start] + kv[0] + "=" + value + line[end:]
+			count++
+		}
+
+		lines[n] = line
+	}
+
+	return strings.Join(lines, ""), count, nil
+}
@@ -0,0 +1,223 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// mapFields returns a fieldFunc replacing values by values, other values are
+// kept unchanged.
+func mapFields(values map[string]string) fieldFunc {
+	return func(key, value string) (string, error) {
+		if v, ok := values[value]; ok {
+			return v, nil
+		}
+
+		return value, nil
+	}
+}
+
+//nolint:funlen
+func TestRewriteFields(t *testing.T) {
+	encrypt := mapFields(map[string]string{"secret": "GOSSH-VAULT;2;prod;abcd", "it's": "GOSSH-VAULT;2;efgh"})
+
+	tests := []struct {
+		name      string
+		file      string
+		content   string
+		keys      []string
+		fn        fieldFunc
+		want      string
+		wantCount int
+		wantErr   string
+	}{
+		{
+			name: "yaml keeps comments and order",
+			file: "vars.yaml",
+			content: `# database
+db:
+  password: secret # keep me
+  host: 10.0.0.1
+
+  port: 5432
+auth:
+  password: 'it''s'
+  user: secret
+`,
+			keys: []string{"password"},
+			fn:   encrypt,
+			want: `# database
+db:
+  password: GOSSH-VAULT;2;prod;abcd # keep me
+  host: 10.0.0.1
+
+  port: 5432
+auth:
+  password: 'GOSSH-VAULT;2;efgh'
+  user: secret
+`,
+			wantCount: 2,
+		},
+		{
+			name:      "yaml dotted key",
+			file:      "vars.yml",
+			content:   "db:\n  password: secret\nauth:\n  password: secret\n",
+			keys:      []string{"auth.password"},
+			fn:        encrypt,
+			want:      "db:\n  password: secret\nauth:\n  password: GOSSH-VAULT;2;prod;abcd\n",
+			wantCount: 1,
+		},
+		{
+			name:      "yaml flow mapping and sequence",
+			file:      "vars.yaml",
+			content:   "db: {password: secret, user: root}\nusers: [{name: a, password: secret}]\n",
+			keys:      []string{"password"},
+			fn:        encrypt,
+			want:      "db: {password: GOSSH-VAULT;2;prod;abcd, user: root}\nusers: [{name: a, password: GOSSH-VAULT;2;prod;abcd}]\n",
+			wantCount: 2,
+		},
+		{
+			name:      "vars file without extension is yaml",
+			file:      "inventory/group_vars/web",
+			content:   "password: secret\n",
+			keys:      []string{"password"},
+			fn:        encrypt,
+			want:      "password: GOSSH-VAULT;2;prod;abcd\n",
+			wantCount: 1,
+		},
+		{
+			name: "json keeps order",
+			file: "hosts.json",
+			content: `{
+  "web": {"hosts": {"web01": {"password": "secret", "port": 22}}},
+  "db": {"vars": {"user": "root", "password": "secret"}}
+}
+`,
+			keys: []string{"password"},
+			fn:   encrypt,
+			want: `{
+  "web": {"hosts": {"web01": {"password": "GOSSH-VAULT;2;prod;abcd", "port": 22}}},
+  "db": {"vars": {"user": "root", "password": "GOSSH-VAULT;2;prod;abcd"}}
+}
+`,
+			wantCount: 2,
+		},
+		{
+			name: "ini host entries and vars",
+			file: "hosts",
+			content: `# web servers
+[web]
+web01 password=secret port=22 # comment
+secret password=secret
+
+[web:vars]
+password=secret user=root
+
+[web:children]
+password=secret
+`,
+			keys: []string{"password"},
+			fn:   encrypt,
+			want: `# web servers
+[web]
+web01 password=GOSSH-VAULT;2;prod;abcd port=22 # comment
+secret password=GOSSH-VAULT;2;prod;abcd
+
+[web:vars]
+password=GOSSH-VAULT;2;prod;abcd user=root
+
+[web:children]
+password=secret
+`,
+			wantCount: 3,
+		},
+		{
+			name:    "ini value with spaces",
+			file:    "hosts",
+			content: "web01 password=secret\n",
+			keys:    []string{"password"},
+			fn:      mapFields(map[string]string{"secret": "a b"}),
+			wantErr: "not supported in INI-like inventory",
+		},
+		{
+			name:    "yaml multiple lines",
+			file:    "vars.yaml",
+			content: "password: |\n  secret\n",
+			keys:    []string{"password"},
+			fn:      encrypt,
+			wantErr: "only single line",
+		},
+		{
+			name:    "error of fn",
+			file:    "vars.yaml",
+			content: "user: root\npassword: secret\n",
+			keys:    []string{"password"},
+			fn: func(key, value string) (string, error) {
+				return "", fmt.Errorf("wrong vault password")
+			},
+			wantErr: "field 'password' at line 2: wrong vault password",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, count, err := rewriteFields(tt.file, tt.content, tt.keys, tt.fn)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("rewriteFields() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("rewriteFields() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if count != tt.wantCount {
+				t.Errorf("rewriteFields() count = %d, want %d", count, tt.wantCount)
+			}
+		})
+	}
+}
+
+// Decrypted values are quoted if they would not be read back as the same
+// strings.
+func TestRewriteFieldsQuoting(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		value   string
+		want    string
+	}{
+		{name: "plain string", file: "a.yaml", content: "k: ENC\n", value: "password", want: "k: password\n"},
+		{name: "number", file: "a.yaml", content: "k: ENC\n", value: "123", want: "k: \"123\"\n"},
+		{name: "bool", file: "a.yaml", content: "k: ENC\n", value: "true", want: "k: \"true\"\n"},
+		{name: "null", file: "a.yaml", content: "k: ENC\n", value: "null", want: "k: \"null\"\n"},
+		{name: "colon", file: "a.yaml", content: "k: ENC # c\n", value: "a: b", want: "k: \"a: b\" # c\n"},
+		{name: "comment sign", file: "a.yaml", content: "k: ENC\n", value: "a #b", want: "k: \"a #b\"\n"},
+		{name: "leading space", file: "a.yaml", content: "k: ENC\n", value: " a", want: "k: \" a\"\n"},
+		{name: "line break", file: "a.yaml", content: "k: ENC\n", value: "a\nb", want: "k: \"a\\nb\"\n"},
+		{name: "comma in flow", file: "a.yaml", content: "m: {k: ENC}\n", value: "a,b", want: "m: {k: \"a,b\"}\n"},
+		{name: "single quoted", file: "a.yaml", content: "k: 'ENC'\n", value: "it's", want: "k: 'it''s'\n"},
+		{name: "double quoted", file: "a.yaml", content: "k: \"ENC\"\n", value: `say "hi"`, want: "k: \"say \\\"hi\\\"\"\n"},
+		{name: "json number", file: "a.json", content: "{\"k\": \"ENC\"}\n", value: "123", want: "{\"k\": \"123\"}\n"},
+		{name: "json html", file: "a.json", content: "{\"k\": \"ENC\"}\n", value: "<&>", want: "{\"k\": \"<&>\"}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := rewriteFields(tt.file, tt.content, []string{"k"}, mapFields(map[string]string{"ENC": tt.value}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("rewriteFields() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
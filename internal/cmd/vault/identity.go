@@ -0,0 +1,283 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/serialt/gosible/internal/pkg/aes"
+	"github.com/serialt/gosible/internal/pkg/configflags"
+	"github.com/serialt/gosible/pkg/log"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// vaultPasswordEnv is the environment variable of the vault password of
+// identities without explicit source, it is suffixed by '_<LABEL>' for
+// labelled identities, e.g. GOSSH_VAULT_PASSWORD_PROD.
+const vaultPasswordEnv = "GOSSH_VAULT_PASSWORD"
+
+// identity is a vault password with the label carried by ciphertexts
+// encrypted with it. The identity of flag '-V, --auth.vault-pass-file'
+// or the default terminal prompt has no label.
+type identity struct {
+	label  string
+	source string
+
+	password string
+	// fromAgent means the password is cached by the vault agent,
+	// fromPrompt means the password is from terminal prompt and can be
+	// cached by the vault agent.
+	fromAgent  bool
+	fromPrompt bool
+}
+
+// identities of flags '-V, --auth.vault-pass-file' and '--auth.vault-id',
+// they are loaded once, so each vault password is asked at most once.
+var identities []*identity
+
+var encryptVaultID string
+
+func getIdentities() []*identity {
+	if identities != nil {
+		return identities
+	}
+
+	if vaultPassFile := configflags.Config.Auth.VaultPassFile; vaultPassFile != "" {
+		identities = append(identities, &identity{source: vaultPassFile})
+	}
+
+	for _, v := range configflags.Config.Auth.VaultIDs {
+		label, source := configflags.ParseVaultID(v)
+		identities = append(identities, &identity{label: label, source: source})
+	}
+
+	if len(identities) == 0 {
+		identities = append(identities, &identity{source: configflags.VaultIDPrompt})
+	}
+
+	return identities
+}
+
+func (id *identity) String() string {
+	return labelName(id.label)
+}
+
+func (id *identity) prompt(prompt string) string {
+	if id.label == "" {
+		return prompt + ": "
+	}
+
+	return fmt.Sprintf("%s (%s): ", prompt, id.label)
+}
+
+func (id *identity) env() string {
+	if id.label == "" {
+		return vaultPasswordEnv
+	}
+
+	return vaultPasswordEnv + "_" + strings.ToUpper(envReplacer.Replace(id.label))
+}
+
+var envReplacer = strings.NewReplacer("-", "_", ".", "_")
+
+// getPassword of the identity for decryption. The password of identities
+// without explicit source is from the environment variable, the vault agent,
+// or terminal prompt in order.
+func (id *identity) getPassword() string {
+	if id.password != "" {
+		return id.password
+	}
+
+	if id.source != configflags.VaultIDPrompt {
+		id.password = readVaultPasswordSource(id.source, id.label)
+		return id.password
+	}
+
+	if id.getPasswordFromEnvOrAgent() {
+		return id.password
+	}
+
+	id.password = getVaultPasswordFromPrompt(id.prompt("Vault password"))
+	id.fromPrompt = true
+
+	return id.password
+}
+
+// getConfirmPassword of the identity for encryption, the password from
+// terminal prompt must be confirmed.
+func (id *identity) getConfirmPassword() string {
+	if id.password != "" || id.source != configflags.VaultIDPrompt {
+		return id.getPassword()
+	}
+
+	if id.getPasswordFromEnvOrAgent() {
+		return id.password
+	}
+
+	id.password = getVaultConfirmPassword(id.prompt("New Vault password"))
+	id.fromPrompt = true
+	id.remember()
+
+	return id.password
+}
+
+func (id *identity) getPasswordFromEnvOrAgent() bool {
+	if password := os.Getenv(id.env()); password != "" {
+		log.Debugf("Vault: read vault password of '%s' from environment variable '%s'", id, id.env())
+
+		id.password = password
+
+		return true
+	}
+
+	if password := getAgentPassword(id.label); password != "" {
+		log.Debugf("Vault: got vault password of '%s' from agent", id)
+
+		id.password = password
+		id.fromAgent = true
+
+		return true
+	}
+
+	return false
+}
+
+// remember the password from terminal prompt in the vault agent.
+func (id *identity) remember() {
+	if !id.fromPrompt {
+		return
+	}
+
+	setAgentPassword(id.label, id.password)
+	id.fromPrompt = false
+}
+
+// decode cipher text with the password of the identity. If forget is true
+// and the password cached by the vault agent is wrong, it is removed from
+// the agent, and the password is asked again.
+func (id *identity) decode(cipherText string, forget bool) (string, error) {
+	plainText, err := aes.AES256Decode(cipherText, id.getPassword())
+	if errors.Is(err, aes.ErrDecrypt) && forget && id.fromAgent {
+		log.Debugf("Vault: vault password of '%s' cached by agent is wrong, remove it from agent", id)
+
+		deleteAgentPassword(id.label)
+		id.password = ""
+		id.fromAgent = false
+
+		plainText, err = aes.AES256Decode(cipherText, id.getPassword())
+	}
+
+	if err == nil {
+		id.remember()
+	}
+
+	return plainText, err
+}
+
+func addEncryptVaultIDFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(
+		&encryptVaultID,
+		"encrypt-vault-id",
+		"",
+		"label of the vault id (--auth.vault-id) for encryption",
+	)
+}
+
+// getEncryptIdentity returns the identity of flag '--encrypt-vault-id',
+// or the identity without label, or the only identity.
+func getEncryptIdentity() *identity {
+	ids := getIdentities()
+
+	if encryptVaultID != "" {
+		for _, id := range ids {
+			if id.label == encryptVaultID {
+				return id
+			}
+		}
+
+		util.CheckErr(fmt.Sprintf("vault id '%s' of --encrypt-vault-id is not given by --auth.vault-id", encryptVaultID))
+	}
+
+	for _, id := range ids {
+		if id.label == "" {
+			return id
+		}
+	}
+
+	if len(ids) > 1 {
+		util.CheckErr("multiple vault ids are given, choose one for encryption by --encrypt-vault-id")
+	}
+
+	return ids[0]
+}
+
+// Decrypt vault ciphertext with the vault password of its label,
+// vault passwords of all vault ids are tried in order if it has no label
+// or the label is not given by '--auth.vault-id'.
+func Decrypt(cipherText string) (string, error) {
+	plainText, _, err := decrypt(cipherText)
+
+	return plainText, err
+}
+
+func decrypt(cipherText string) (string, *identity, error) {
+	ids := getIdentities()
+
+	if label := aes.CipherTextLabel(cipherText); label != "" {
+		for _, id := range ids {
+			if id.label != label {
+				continue
+			}
+
+			plainText, err := id.decode(cipherText, true)
+			if err != nil {
+				return "", nil, fmt.Errorf("vault id '%s': %w", label, err)
+			}
+
+			return plainText, id, nil
+		}
+
+		log.Debugf("Vault: vault id '%s' is not given, try all vault ids", label)
+	}
+
+	var err error
+	for _, id := range ids {
+		var plainText string
+		plainText, err = id.decode(cipherText, len(ids) == 1)
+		if err == nil {
+			log.Debugf("Vault: decrypted by vault id '%s'", id)
+			return plainText, id, nil
+		}
+
+		if !errors.Is(err, aes.ErrDecrypt) {
+			return "", nil, err
+		}
+	}
+
+	return "", nil, err
+}
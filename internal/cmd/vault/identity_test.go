@@ -0,0 +1,154 @@
+package vault
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/serialt/gosible/internal/pkg/aes"
+	"github.com/serialt/gosible/internal/pkg/configflags"
+)
+
+// setTestIdentities replaces identities of flags until the test ends.
+func setTestIdentities(t *testing.T, ids ...*identity) {
+	t.Helper()
+
+	// Passwords are never cached in or got from a running vault agent.
+	t.Setenv(agentSocketEnv, t.TempDir()+"/none.sock")
+
+	old, oldEncryptVaultID := identities, encryptVaultID
+	identities = ids
+	t.Cleanup(func() {
+		identities, encryptVaultID = old, oldEncryptVaultID
+	})
+}
+
+func encryptForTest(t *testing.T, plainText, password, label string) string {
+	t.Helper()
+
+	cipherText, err := aes.AES256EncodeWithLabel(plainText, password, label)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cipherText
+}
+
+//nolint:funlen
+func TestDecrypt(t *testing.T) {
+	tests := []struct {
+		name       string
+		cipherText string
+		wantLabel  string
+		wantErr    string
+	}{
+		{
+			name:       "label",
+			cipherText: encryptForTest(t, "hello", "prodpass", "prod"),
+			wantLabel:  "prod",
+		},
+		{
+			name:       "no label",
+			cipherText: encryptForTest(t, "hello", "stagingpass", ""),
+			wantLabel:  "staging",
+		},
+		{
+			name:       "no label of vault pass file",
+			cipherText: encryptForTest(t, "hello", "vaultpass", ""),
+			wantLabel:  "",
+		},
+		{
+			name:       "label not given",
+			cipherText: encryptForTest(t, "hello", "prodpass", "qa"),
+			wantLabel:  "prod",
+		},
+		{
+			name:       "wrong password of label",
+			cipherText: encryptForTest(t, "hello", "stagingpass", "prod"),
+			wantErr:    "vault id 'prod'",
+		},
+		{
+			name:       "no password",
+			cipherText: encryptForTest(t, "hello", "otherpass", ""),
+			wantErr:    aes.ErrDecrypt.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestIdentities(t,
+				&identity{source: "/path/vault-pass-file", password: "vaultpass"},
+				&identity{label: "prod", source: "/path/prod-pass-file", password: "prodpass"},
+				&identity{label: "staging", source: "/path/staging-pass-file", password: "stagingpass"},
+			)
+
+			plainText, id, err := decrypt(tt.cipherText)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decrypt() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				if !errors.Is(err, aes.ErrDecrypt) {
+					t.Errorf("decrypt() error = %v, want %v", err, aes.ErrDecrypt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if plainText != "hello" {
+				t.Errorf("decrypt() = %q, want %q", plainText, "hello")
+			}
+			if id.label != tt.wantLabel {
+				t.Errorf("decrypted by vault id '%s', want '%s'", id, labelName(tt.wantLabel))
+			}
+		})
+	}
+}
+
+func TestGetEncryptIdentity(t *testing.T) {
+	vaultPassFile := &identity{source: "/path/vault-pass-file"}
+	prod := &identity{label: "prod", source: "/path/prod-pass-file"}
+	staging := &identity{label: "staging", source: configflags.VaultIDPrompt}
+
+	tests := []struct {
+		name           string
+		ids            []*identity
+		encryptVaultID string
+		want           *identity
+	}{
+		{name: "chosen", ids: []*identity{vaultPassFile, prod, staging}, encryptVaultID: "staging", want: staging},
+		{name: "without label", ids: []*identity{prod, vaultPassFile, staging}, want: vaultPassFile},
+		{name: "only one", ids: []*identity{prod}, want: prod},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestIdentities(t, tt.ids...)
+			encryptVaultID = tt.encryptVaultID
+
+			if got := getEncryptIdentity(); got != tt.want {
+				t.Errorf("getEncryptIdentity() = '%s', want '%s'", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentityEnv(t *testing.T) {
+	tests := []struct {
+		label string
+		want  string
+	}{
+		{label: "", want: "GOSSH_VAULT_PASSWORD"},
+		{label: "prod", want: "GOSSH_VAULT_PASSWORD_PROD"},
+		{label: "eu-west.prod", want: "GOSSH_VAULT_PASSWORD_EU_WEST_PROD"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			if got := (&identity{label: tt.label}).env(); got != tt.want {
+				t.Errorf("env() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/serialt/gosible/internal/pkg/aes"
+	"github.com/serialt/gosible/internal/pkg/configflags"
+	"github.com/serialt/gosible/pkg/log"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+var (
+	newVaultPassFile string
+	rekeyDryRun      bool
+)
+
+// rekeyCmd represents the vault rekey command
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey PATH...",
+	Short: "Re-encrypt vault content with a new vault password",
+	Long: `
+Re-encrypt vault content with a new vault password.
+
+Vault encrypted files and ciphertexts inside other files such as inventory
+files and the configuration file are re-encrypted in place, other content of
+the files is kept unchanged. Directories are searched recursively, hidden
+files and binary files in them are skipped.
+
+All ciphertexts are decrypted with the old vault password before any file is
+written, and each file is replaced atomically, so a failure never leaves
+files half rewritten.
+
+The old vault password is given by flag '-V, --auth.vault-pass-file' or from
+terminal prompt, and the new one by flag '--new-vault-pass-file' or from
+terminal prompt.
+
+With vault ids (--auth.vault-id), only ciphertexts carrying the label of the
+vault id chosen by '--encrypt-vault-id' are re-encrypted, the label is kept.`,
+	Example: `
+  # Rekey a vault encrypted file by asking for old and new vault passwords.
+  $ gossh vault rekey /path/auth.txt
+
+  # Rekey all ciphertexts in an inventory directory and the configuration file
+  # by vault password files or scripts.
+  $ gossh vault rekey /path/inventory/ ~/.gossh.yaml \
+      -V /path/old-vault-password-file --new-vault-pass-file /path/new-vault-password-file
+
+  # Rekey ciphertexts of the vault id 'prod' only.
+  $ gossh vault rekey /path/inventory/ --auth.vault-id prod@prompt --auth.vault-id staging@prompt \
+      --encrypt-vault-id prod
+
+  # List files and counts of ciphertexts to be re-encrypted without changing them.
+  $ gossh vault rekey /path/inventory/ -V /path/old-vault-password-file --dry-run`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			util.CobraCheckErrWithHelp(cmd, "requires at least one arg to represent files or directories to be rekeyed")
+		}
+
+		for _, path := range args {
+			if !util.FileExists(path) && !util.DirExists(path) {
+				util.CheckErr(fmt.Sprintf("'%s' not found", path))
+			}
+		}
+
+		if newVaultPassFile != "" && !strings.HasPrefix(newVaultPassFile, configflags.VaultSourceEnvPrefix) {
+			if file, _ := configflags.SplitVaultPassSource(newVaultPassFile); !util.FileExists(file) {
+				util.CheckErr(fmt.Sprintf("new vault password file '%s' not found", file))
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		id := getEncryptIdentity()
+		oldVaultPass := id.getPassword()
+
+		files, err := findFiles(args)
+		util.CheckErr(err)
+
+		// Decrypting with the old vault password first, so that a wrong
+		// password is reported before asking for the new one.
+		results, err := rewriteFiles(files, func(cipherText string) (string, error) {
+			if aes.CipherTextLabel(cipherText) != id.label {
+				return cipherText, nil
+			}
+
+			return aes.AES256Decode(cipherText, oldVaultPass)
+		})
+		util.CheckErr(err)
+
+		if rekeyDryRun {
+			printRewriteResults(results, "ciphertexts", "would be re-encrypted")
+			return
+		}
+
+		newVaultPass := getNewVaultPassword(id)
+		if newVaultPass == oldVaultPass {
+			util.CheckErr("the new vault password is the same as the old one")
+		}
+
+		results, err = rewriteFiles(files, func(cipherText string) (string, error) {
+			if aes.CipherTextLabel(cipherText) != id.label {
+				return cipherText, nil
+			}
+
+			plainText, err := aes.AES256Decode(cipherText, oldVaultPass)
+			if err != nil {
+				return "", err
+			}
+
+			return aes.AES256EncodeWithLabel(plainText, newVaultPass, id.label)
+		})
+		util.CheckErr(err)
+
+		util.CheckErr(writeRewriteResults(results))
+
+		// The old vault password cached by the vault agent is stale.
+		if id.source == configflags.VaultIDPrompt {
+			setAgentPassword(id.label, newVaultPass)
+		}
+
+		printRewriteResults(results, "ciphertexts", "re-encrypted")
+	},
+}
+
+func init() {
+	rekeyCmd.Flags().StringVarP(
+		&newVaultPassFile,
+		"new-vault-pass-file",
+		"N",
+		"",
+		"text file or executable file (args can follow it) that holds the new vault password,\nor 'env:NAME' for environment variable",
+	)
+
+	rekeyCmd.Flags().BoolVarP(
+		&rekeyDryRun,
+		"dry-run",
+		"n",
+		false,
+		"only list files and counts of ciphertexts to be re-encrypted",
+	)
+
+	addEncryptVaultIDFlag(rekeyCmd)
+}
+
+func getNewVaultPassword(id *identity) string {
+	if newVaultPassFile != "" {
+		return readVaultPasswordSource(newVaultPassFile, id.label)
+	}
+
+	prompt := id.prompt("New Vault password")
+	password, err := getConfirmPasswordFromPrompt(prompt)
+	if err != nil {
+		util.CheckErr(fmt.Sprintf("get new vault password from terminal prompt failed: %s", err))
+	}
+
+	log.Debugf("Vault: confirmed new vault password that from terminal prompt")
+
+	return password
+}
+
+// rewriteResult is a file with its ciphertexts rewritten.
+type rewriteResult struct {
+	file    string
+	content string
+	count   int
+}
+
+// rewriteFiles replaces all ciphertexts in files by the results of fn,
+// it fails without any result if any ciphertext can not be rewritten.
+func rewriteFiles(files []string, fn func(cipherText string) (string, error)) ([]rewriteResult, error) {
+	results := make([]rewriteResult, 0, len(files))
+
+	for _, file := range files {
+		p, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		content, count, err := aes.ReplaceCipherTexts(string(p), fn)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", file, err)
+		}
+
+		results = append(results, rewriteResult{file: file, content: content, count: count})
+	}
+
+	return results, nil
+}
+
+func writeRewriteResults(results []rewriteResult) error {
+	for _, v := range results {
+		if v.count == 0 {
+			continue
+		}
+
+		if err := util.WriteFileAtomic(v.file, []byte(v.content)); err != nil {
+			return fmt.Errorf("write '%s' failed: %w", v.file, err)
+		}
+
+		log.Debugf("Vault: rewrote %d values in '%s'", v.count, v.file)
+	}
+
+	return nil
+}
+
+func printRewriteResults(results []rewriteResult, noun, action string) {
+	total := 0
+	for _, v := range results {
+		if v.count == 0 {
+			continue
+		}
+
+		total += v.count
+		fmt.Printf("%s: %d %s %s\n", v.file, v.count, noun, action)
+	}
+
+	if total == 0 {
+		fmt.Printf("no %s %s\n", noun, action)
+	}
+}
+
+// findFiles returns files of args, directories are searched recursively
+// for files containing ciphertexts, hidden files and binary files in them
+// are skipped.
+func findFiles(args []string) ([]string, error) {
+	var files []string
+
+	for _, arg := range args {
+		if !util.DirExists(arg) {
+			files = append(files, arg)
+			continue
+		}
+
+		err := filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if path != arg && strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			ok, err := containsCipherTexts(path)
+			if err != nil {
+				return err
+			}
+			if ok {
+				files = append(files, path)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+func containsCipherTexts(file string) (bool, error) {
+	p, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.IndexByte(p, 0) != -1 {
+		log.Debugf("Vault: skip binary file '%s'", file)
+		return false, nil
+	}
+
+	return aes.ContainsCipherText(string(p)), nil
+}
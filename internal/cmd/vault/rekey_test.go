@@ -0,0 +1,99 @@
+package vault
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	cipherText := "GOSSH-VAULT;2;" + strings.Repeat("ab", 40)
+	files := map[string]string{
+		"inventory/hosts":            "web01 password=" + cipherText + "\n",
+		"inventory/group_vars/web":   "password: " + cipherText + "\n",
+		"inventory/plain.yaml":       "password: secret\n",
+		"inventory/.git/config":      cipherText,
+		"inventory/.hidden.yaml":     cipherText,
+		"inventory/binary":           "\x00" + cipherText,
+		"gossh.yaml":                 "auth:\n  password: " + cipherText + "\n",
+		"inventory/host_vars/web01/": "",
+	}
+
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(file, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := findFiles([]string{filepath.Join(dir, "inventory"), filepath.Join(dir, "gossh.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(dir, "inventory", "group_vars", "web"),
+		filepath.Join(dir, "inventory", "hosts"),
+		filepath.Join(dir, "gossh.yaml"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findFiles() = %v, want %v", got, want)
+	}
+}
+
+func TestRewriteFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	oldText := "GOSSH-VAULT;2;" + strings.Repeat("ab", 40)
+	newText := "GOSSH-VAULT;2;" + strings.Repeat("cd", 40)
+
+	file1 := filepath.Join(dir, "hosts")
+	file2 := filepath.Join(dir, "vars.yaml")
+	if err := ioutil.WriteFile(file1, []byte("web01 password="+oldText+" sudo_pass="+oldText+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file2, []byte("# no secrets\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := rewriteFiles([]string{file1, file2}, func(cipherText string) (string, error) {
+		if cipherText != oldText {
+			t.Errorf("rewrite %q, want %q", cipherText, oldText)
+		}
+		return newText, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []rewriteResult{
+		{file: file1, content: "web01 password=" + newText + " sudo_pass=" + newText + "\n", count: 2},
+		{file: file2, content: "# no secrets\n", count: 0},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("rewriteFiles() = %v, want %v", results, want)
+	}
+
+	if err := writeRewriteResults(results); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, _ := ioutil.ReadFile(file1); string(p) != want[0].content {
+		t.Errorf("content of %s = %q, want %q", file1, p, want[0].content)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package vault
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/serialt/gosible/internal/pkg/aes"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// upgradeCmd represents the vault upgrade command
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade PATH...",
+	Short: "Upgrade legacy vault ciphertexts in files",
+	Long: `
+Re-encrypt ciphertexts of the legacy format 'GOSSH-AES256:' to the current
+format 'GOSSH-VAULT;2;' with the same vault password.
+
+Both vault encrypted files and ciphertexts inside other files such as
+inventory files and the configuration file are upgraded in place,
+other content of the files is kept unchanged. Directories are searched
+recursively, hidden files and binary files in them are skipped.`,
+	Example: `
+  # Upgrade a vault encrypted file by asking for vault password.
+  $ gossh vault upgrade /path/auth.txt
+
+  # Upgrade ciphertexts inside inventory files by vault password file or script.
+  $ gossh vault upgrade /path/hosts.txt /path/group_vars/ -V /path/vault-password-file-or-script`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			util.CobraCheckErrWithHelp(cmd, "requires at least one arg to represent files or directories to be upgraded")
+		}
+
+		for _, path := range args {
+			if !util.FileExists(path) && !util.DirExists(path) {
+				util.CheckErr(fmt.Sprintf("'%s' not found", path))
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		files, err := findFiles(args)
+		util.CheckErr(err)
+
+		results, err := rewriteFiles(files, func(cipherText string) (string, error) {
+			if !aes.IsLegacyCipherText(cipherText) {
+				return cipherText, nil
+			}
+
+			plainText, id, err := decrypt(cipherText)
+			if err != nil {
+				return "", err
+			}
+
+			return aes.AES256EncodeWithLabel(plainText, id.getPassword(), aes.CipherTextLabel(cipherText))
+		})
+		util.CheckErr(err)
+
+		util.CheckErr(writeRewriteResults(results))
+
+		printRewriteResults(results, "ciphertexts", "upgraded")
+	},
+}
@@ -28,12 +28,14 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 
+	"github.com/serialt/gosible/internal/pkg/aes"
 	"github.com/serialt/gosible/internal/pkg/configflags"
 	"github.com/serialt/gosible/pkg/log"
 	"github.com/serialt/gosible/pkg/util"
@@ -47,11 +49,20 @@ var Cmd = &cobra.Command{
 Encrypt sensitive content such as passwords so you can protect it rather than 
 leaving it visible as plaintext in public place. To use vault you need another 
 password(vault-pass) to encrypt and decrypt the content.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if errs := configflags.Config.Auth.Validate(); len(errs) != 0 {
+			util.CheckErr(errs)
+		}
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		aes.ClearKeyCache()
+	},
 }
 
 func init() {
 	util.CobraAddSubCommandInOrder(Cmd,
-		encryptCmd, decryptCmd, encryptFileCmd, decryptFileCmd, viewCmd)
+		encryptCmd, decryptCmd, encryptFileCmd, decryptFileCmd, viewCmd, editCmd, createCmd,
+		encryptFieldsCmd, decryptFieldsCmd, rekeyCmd, upgradeCmd, agentCmd)
 }
 
 // SetHelpFunc for vault command and its subcommands.
@@ -60,6 +71,7 @@ func SetHelpFunc(rootCmd *cobra.Command) {
 		util.CobraMarkHiddenGlobalFlagsExcept(
 			rootCmd,
 			"auth.vault-pass-file",
+			"auth.vault-id",
 			"output.verbose",
 		)
 	}
@@ -93,15 +105,44 @@ func SetHelpFunc(rootCmd *cobra.Command) {
 		markHiddenGlobalFlagsExceptsForVault()
 		command.Parent().Parent().HelpFunc()(command, strings)
 	})
-}
 
-func getVaultConfirmPassword() string {
-	password := getVaultPasswordFromFile()
-	if password != "" {
-		return password
-	}
+	editCmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		markHiddenGlobalFlagsExceptsForVault()
+		command.Parent().Parent().HelpFunc()(command, strings)
+	})
+
+	createCmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		markHiddenGlobalFlagsExceptsForVault()
+		command.Parent().Parent().HelpFunc()(command, strings)
+	})
 
-	prompt := "New Vault password: "
+	encryptFieldsCmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		markHiddenGlobalFlagsExceptsForVault()
+		command.Parent().Parent().HelpFunc()(command, strings)
+	})
+
+	decryptFieldsCmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		markHiddenGlobalFlagsExceptsForVault()
+		command.Parent().Parent().HelpFunc()(command, strings)
+	})
+
+	rekeyCmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		markHiddenGlobalFlagsExceptsForVault()
+		command.Parent().Parent().HelpFunc()(command, strings)
+	})
+
+	upgradeCmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		markHiddenGlobalFlagsExceptsForVault()
+		command.Parent().Parent().HelpFunc()(command, strings)
+	})
+
+	agentCmd.SetHelpFunc(func(command *cobra.Command, strings []string) {
+		markHiddenGlobalFlagsExceptsForVault()
+		command.Parent().Parent().HelpFunc()(command, strings)
+	})
+}
+
+func getVaultConfirmPassword(prompt string) string {
 	password, err := getConfirmPasswordFromPrompt(prompt)
 	if err != nil {
 		util.CheckErr(fmt.Sprintf("get vault password from terminal prompt failed: %s", err))
@@ -112,16 +153,12 @@ func getVaultConfirmPassword() string {
 	return password
 }
 
-// GetVaultPassword from terminal prompt or vault file.
-func GetVaultPassword() string {
-	var err error
-
-	password := getVaultPasswordFromFile()
-	if password != "" {
-		return password
-	}
+func getVaultPasswordFromPrompt(prompt string) string {
+	var (
+		password string
+		err      error
+	)
 
-	prompt := "Vault password: "
 	for {
 		password, err = getPasswordFromPrompt(prompt)
 		if err != nil {
@@ -139,57 +176,99 @@ func GetVaultPassword() string {
 	return password
 }
 
-func getVaultPasswordFromFile() string {
-	vaultPassFile := configflags.Config.Auth.VaultPassFile
-	if vaultPassFile != "" {
-		ok, err := isExectuable(vaultPassFile)
-		util.CheckErr(err)
+// readVaultPasswordSource reads the vault password from an environment
+// variable like 'env:NAME', the output of an executable file with args,
+// or a text file. The label of the vault id is passed to the executable
+// file by environment variable GOSSH_VAULT_ID.
+func readVaultPasswordSource(source, label string) string {
+	if strings.HasPrefix(source, configflags.VaultSourceEnvPrefix) {
+		name := strings.TrimPrefix(source, configflags.VaultSourceEnvPrefix)
 
-		if ok {
-			bin := fmt.Sprintf("./%s", vaultPassFile)
-			out, err1 := exec.Command(bin).Output()
-			if err1 != nil {
-				util.CheckErr(fmt.Errorf(
-					"problem executing file '%s': %s, if this is not a executable file, "+
-						"remove the executable bit from the file", vaultPassFile, err1))
-			}
-
-			vaultPass := strings.TrimSpace(string(out))
-			if vaultPass == "" {
-				util.CheckErr(fmt.Sprintf(
-					"problem executing file '%s': output cannot be empty, if this is not a script, "+
-						"remove the executable bit from the file", vaultPassFile))
-			}
-
-			log.Debugf("Vault: get vault password by executing file '%s'", vaultPassFile)
-
-			return vaultPass
+		vaultPass := os.Getenv(name)
+		if vaultPass == "" {
+			util.CheckErr(fmt.Sprintf("environment variable '%s' of the vault password is empty", name))
 		}
 
-		passwordContent, err := ioutil.ReadFile(vaultPassFile)
-		if err != nil {
-			err = fmt.Errorf("read vault password file '%s' failed: %w", vaultPassFile, err)
-		}
+		log.Debugf("Vault: read vault password from environment variable '%s'", name)
+
+		return vaultPass
+	}
+
+	vaultPassFile, args := configflags.SplitVaultPassSource(source)
+
+	ok, err := util.IsExecutable(vaultPassFile)
+	util.CheckErr(err)
+
+	if ok {
+		return runVaultPassExecutable(vaultPassFile, args, label)
+	}
+
+	if len(args) != 0 {
+		util.CheckErr(fmt.Sprintf(
+			"'%s' is not an executable file, but args '%s' are given",
+			vaultPassFile, strings.Join(args, " "),
+		))
+	}
+
+	passwordContent, err := ioutil.ReadFile(vaultPassFile)
+	if err != nil {
+		err = fmt.Errorf("read vault password file '%s' failed: %w", vaultPassFile, err)
+	}
+	util.CheckErr(err)
+
+	vaultPass := strings.TrimSpace(string(passwordContent))
+	if vaultPass == "" {
+		util.CheckErr("vault password file cannot be empty")
+	}
+
+	if strings.HasPrefix(vaultPass, "#!/") {
+		util.CheckErr(fmt.Sprintf(
+			"'%s' looks like a script file, please add the executable bit to this file",
+			vaultPassFile,
+		))
+	}
+
+	log.Debugf("Vault: read vault password from file '%s'", vaultPassFile)
+
+	return vaultPass
+}
+
+func runVaultPassExecutable(vaultPassFile string, args []string, label string) string {
+	bin := vaultPassFile
+	if strings.HasPrefix(bin, "~/") {
+		home, err := os.UserHomeDir()
 		util.CheckErr(err)
 
-		vaultPass := strings.TrimSpace(string(passwordContent))
-		if vaultPass == "" {
-			util.CheckErr("vault password file cannot be empty")
-		}
+		bin = filepath.Join(home, bin[2:])
+	}
 
-		if strings.HasPrefix(vaultPass, "#!/") {
-			util.CheckErr(fmt.Sprintf(
-				"'%s' looks like a script file, please add the executable bit to this file",
-				vaultPassFile,
-			))
-		}
+	// A relative path must not be looked up in $PATH.
+	if !filepath.IsAbs(bin) {
+		bin = "." + string(os.PathSeparator) + bin
+	}
 
-		log.Debugf("Vault: read vault password from file '%s'", vaultPassFile)
+	//nolint:gosec
+	cmd := exec.Command(bin, args...)
+	cmd.Env = append(os.Environ(), "GOSSH_VAULT_ID="+label)
+	cmd.Stderr = os.Stderr
 
-		return vaultPass
+	out, err := cmd.Output()
+	if err != nil {
+		util.CheckErr(fmt.Errorf(
+			"problem executing file '%s': %s, if this is not a executable file, "+
+				"remove the executable bit from the file", vaultPassFile, err))
+	}
+
+	vaultPass := strings.TrimSpace(string(out))
+	if vaultPass == "" {
+		util.CheckErr(fmt.Sprintf(
+			"problem executing file '%s': output cannot be empty, if this is not a script, "+
+				"remove the executable bit from the file", vaultPassFile))
 	}
 
-	return ""
+	log.Debugf("Vault: get vault password by executing file '%s'", vaultPassFile)
+
+	return vaultPass
 }
 
 func getPasswordFromPrompt(prompt string) (string, error) {
@@ -247,12 +326,3 @@ func getConfirmPasswordFromPrompt(prompt string) (string, error) {
 
 	return password, nil
 }
-
-func isExectuable(file string) (bool, error) {
-	f, err := os.Stat(file)
-	if err != nil {
-		return false, err
-	}
-
-	return f.Mode().Perm()&0111 != 0, nil
-}
@@ -0,0 +1,49 @@
+package vault
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestReadVaultPasswordSource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on windows")
+	}
+
+	dir := t.TempDir()
+
+	passFile := filepath.Join(dir, "vault pass")
+	if err := ioutil.WriteFile(passFile, []byte("  filepass\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	script := filepath.Join(dir, "vault-pass.sh")
+	content := "#!/bin/sh\necho \"$1-$2-$GOSSH_VAULT_ID\"\n"
+	if err := ioutil.WriteFile(script, []byte(content), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_VAULT_PASS", "envpass")
+
+	tests := []struct {
+		name   string
+		source string
+		label  string
+		want   string
+	}{
+		{name: "env", source: "env:TEST_VAULT_PASS", want: "envpass"},
+		{name: "file with spaces in name", source: passFile, want: "filepass"},
+		{name: "executable", source: script, label: "prod", want: "--prod"},
+		{name: "executable with args", source: script + " a,b ops@bastion", label: "prod", want: "a,b-ops@bastion-prod"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readVaultPasswordSource(tt.source, tt.label); got != tt.want {
+				t.Errorf("readVaultPasswordSource() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
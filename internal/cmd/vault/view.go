@@ -58,11 +58,9 @@ View vault encrypted file.`,
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		vaultPass := GetVaultPassword()
-
 		file := args[0]
 
-		decryptContent, err := decryptFile(file, vaultPass)
+		decryptContent, err := decryptFile(file)
 		util.CheckErr(err)
 
 		err = util.LessContent(decryptContent)
@@ -24,52 +24,228 @@ package aes
 
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/serialt/gosible/pkg/aes"
-	"github.com/serialt/gosible/pkg/util"
 )
 
 const (
-	// cipherTextHead for identifying encrypted strings.
-	cipherTextHead = "GOSSH-AES256:"
+	// cipherTextHead for identifying encrypted strings,
+	// it is followed by the format version, an optional vault id label and
+	// the hex encoded cipher text,
+	// e.g. GOSSH-VAULT;2;5f3a... or GOSSH-VAULT;2;prod;5f3a...
+	cipherTextHead = "GOSSH-VAULT;"
+
+	// cipherTextVersion is the version of the current format:
+	// AES-256-GCM with the key derived from the vault password by scrypt.
+	cipherTextVersion = "2"
+
+	// legacyCipherTextHead for identifying strings encrypted by AES-256-CBC
+	// without MAC, they can still be decrypted, and be upgraded by
+	// 'gossh vault upgrade'. It may carry a vault id label like
+	// GOSSH-AES256@prod:5f3a...
+	legacyCipherTextHead = "GOSSH-AES256"
+
+	labelPattern = `[a-zA-Z0-9_.-]+`
 )
 
-// AES256Encode ...
+// ErrDecrypt is returned when decryption failed because of a wrong vault
+// password or corrupted data.
+var ErrDecrypt = errors.New("wrong vault password or corrupted data")
+
+var (
+	// cipherTextRegexp matches cipher texts of all formats inside other content,
+	// such as inventory files.
+	cipherTextRegexp = regexp.MustCompile(
+		regexp.QuoteMeta(cipherTextHead) + `[0-9]+;(?:` + labelPattern + `;)?[0-9a-fA-F]+|` +
+			regexp.QuoteMeta(legacyCipherTextHead) + `(?:@` + labelPattern + `)?:[0-9a-fA-F]+`,
+	)
+
+	labelRegexp = regexp.MustCompile(`^` + labelPattern + `$`)
+)
+
+// IsValidLabel reports whether the vault id label can be used in cipher texts.
+func IsValidLabel(label string) bool {
+	return labelRegexp.MatchString(label)
+}
+
+// AES256Encode encrypts plain text to the current vault format.
 func AES256Encode(plainText, key string) (string, error) {
-	keyLen := 32
+	return AES256EncodeWithLabel(plainText, key, "")
+}
 
-	cipherText, err := aes.Encode([]byte(plainText), []byte(key), keyLen)
+// AES256EncodeWithLabel encrypts plain text to the current vault format,
+// the cipher text carries the vault id label if it is not empty.
+func AES256EncodeWithLabel(plainText, key, label string) (string, error) {
+	if label != "" && !IsValidLabel(label) {
+		return "", fmt.Errorf("invalid vault id label '%s'", label)
+	}
+
+	cipherText, err := aes.EncodeGCM([]byte(plainText), []byte(key))
 	if err != nil {
 		return "", err
 	}
 
-	hexCipherText := hex.EncodeToString(cipherText)
+	head := cipherTextHead + cipherTextVersion + ";"
+	if label != "" {
+		head += label + ";"
+	}
 
-	return cipherTextHead + hexCipherText, nil
+	return head + hex.EncodeToString(cipherText), nil
 }
 
-// AES256Decode ...
+// AES256Decode decrypts cipher text of the current or the legacy vault format,
+// ErrDecrypt is returned if the vault password is wrong or the data is corrupted.
 func AES256Decode(hexCipherText, key string) (string, error) {
-	defer func() {
-		if err := recover(); err != nil {
-			util.CheckErr("decryption failed: wrong vault password")
+	hexCipherText = strings.TrimSpace(hexCipherText)
+
+	if IsLegacyCipherText(hexCipherText) {
+		i := strings.Index(hexCipherText, ":")
+		if i == -1 {
+			return "", ErrDecrypt
 		}
-	}()
 
-	keyLen := 32
+		return legacyAES256Decode(hexCipherText[i+1:], key)
+	}
+
+	if !strings.HasPrefix(hexCipherText, cipherTextHead) {
+		return "", errors.New("not a vault cipher text")
+	}
+
+	fields := strings.Split(strings.TrimPrefix(hexCipherText, cipherTextHead), ";")
+	if len(fields) != 2 && len(fields) != 3 {
+		return "", ErrDecrypt
+	}
+
+	if fields[0] != cipherTextVersion {
+		return "", fmt.Errorf("unsupported vault format version '%s', please upgrade gossh", fields[0])
+	}
+
+	cipherText, err := hex.DecodeString(fields[len(fields)-1])
+	if err != nil {
+		return "", ErrDecrypt
+	}
+
+	plainText, err := aes.DecodeGCM(cipherText, []byte(key))
+	if err != nil {
+		if errors.Is(err, aes.ErrDecrypt) {
+			return "", ErrDecrypt
+		}
+
+		return "", err
+	}
+
+	return string(plainText), nil
+}
+
+// CipherTextLabel returns the vault id label of the cipher text,
+// it is empty if the cipher text has no label.
+func CipherTextLabel(text string) string {
+	text = strings.TrimSpace(text)
+
+	if IsLegacyCipherText(text) {
+		text = strings.TrimPrefix(text, legacyCipherTextHead)
+		if !strings.HasPrefix(text, "@") {
+			return ""
+		}
 
-	hexCipherText = strings.TrimPrefix(hexCipherText, cipherTextHead)
+		i := strings.Index(text, ":")
+		if i == -1 {
+			return ""
+		}
+
+		return text[1:i]
+	}
+
+	fields := strings.Split(strings.TrimPrefix(text, cipherTextHead), ";")
+	if len(fields) != 3 {
+		return ""
+	}
+
+	return fields[1]
+}
+
+func legacyAES256Decode(hexCipherText, key string) (string, error) {
+	keyLen := 32
 
 	cipherText, err := hex.DecodeString(hexCipherText)
 	if err != nil {
+		return "", ErrDecrypt
+	}
+
+	plainText, err := aes.Decode(cipherText, []byte(key), keyLen)
+	if err != nil {
+		if errors.Is(err, aes.ErrDecrypt) {
+			return "", ErrDecrypt
+		}
+
 		return "", err
 	}
 
-	return aes.Decode(cipherText, []byte(key), keyLen)
+	// There is no MAC in the legacy format, a wrong vault password passes
+	// the padding check by chance sometimes, but the result is hardly a valid
+	// utf-8 text.
+	if !utf8.ValidString(plainText) {
+		return "", ErrDecrypt
+	}
+
+	return plainText, nil
 }
 
-// IsAES256CipherText or not.
+// IsAES256CipherText or not, both the current and the legacy formats are
+// treated as cipher texts.
 func IsAES256CipherText(text string) bool {
-	return strings.HasPrefix(text, cipherTextHead)
+	return strings.HasPrefix(text, cipherTextHead) || IsLegacyCipherText(text)
+}
+
+// IsLegacyCipherText reports whether the text is encrypted in the legacy
+// AES-256-CBC format.
+func IsLegacyCipherText(text string) bool {
+	return strings.HasPrefix(text, legacyCipherTextHead+":") || strings.HasPrefix(text, legacyCipherTextHead+"@")
+}
+
+// ContainsCipherText reports whether there is any cipher text inside content.
+func ContainsCipherText(content string) bool {
+	return cipherTextRegexp.MatchString(content)
+}
+
+// ReplaceCipherTexts calls fn for each cipher text inside content,
+// and replaces the cipher text with the result of fn.
+// It returns the new content and the number of replaced cipher texts.
+func ReplaceCipherTexts(content string, fn func(cipherText string) (string, error)) (string, int, error) {
+	var (
+		count int
+		err   error
+	)
+
+	newContent := cipherTextRegexp.ReplaceAllStringFunc(content, func(cipherText string) string {
+		if err != nil {
+			return cipherText
+		}
+
+		var s string
+		s, err = fn(cipherText)
+		if err != nil || s == cipherText {
+			return cipherText
+		}
+
+		count++
+
+		return s
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	return newContent, count, nil
+}
+
+// ClearKeyCache zeroes the keys derived from vault passwords, it is called
+// once all cipher texts needed are decrypted.
+func ClearKeyCache() {
+	aes.ClearKeyCache()
 }
@@ -0,0 +1,181 @@
+package aes
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAES256EncodeWithLabel(t *testing.T) {
+	tests := []struct {
+		label    string
+		wantHead string
+		wantErr  bool
+	}{
+		{label: "", wantHead: "GOSSH-VAULT;2;"},
+		{label: "prod", wantHead: "GOSSH-VAULT;2;prod;"},
+		{label: "dev_1.a-b", wantHead: "GOSSH-VAULT;2;dev_1.a-b;"},
+		{label: "a;b", wantErr: true},
+		{label: "a b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			got, err := AES256EncodeWithLabel("secret", "password", tt.label)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AES256EncodeWithLabel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if !strings.HasPrefix(got, tt.wantHead) {
+				t.Errorf("AES256EncodeWithLabel() = %s, want prefix %s", got, tt.wantHead)
+			}
+
+			if label := CipherTextLabel(got); label != tt.label {
+				t.Errorf("CipherTextLabel() = %q, want %q", label, tt.label)
+			}
+
+			if !IsAES256CipherText(got) || IsLegacyCipherText(got) || !ContainsCipherText("k: "+got) {
+				t.Errorf("%s is not recognized as a cipher text of the current format", got)
+			}
+
+			plainText, err := AES256Decode(" "+got+"\n", "password")
+			if err != nil || plainText != "secret" {
+				t.Errorf("AES256Decode() = %q, %v, want %q", plainText, err, "secret")
+			}
+
+			if _, err := AES256Decode(got, "wrong"); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("AES256Decode() with wrong password error = %v, want %v", err, ErrDecrypt)
+			}
+		})
+	}
+}
+
+func TestAES256DecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		cipherText string
+		wantErr    string
+	}{
+		{name: "not vault", cipherText: "secret", wantErr: "not a vault cipher text"},
+		{name: "unsupported version", cipherText: "GOSSH-VAULT;3;abcd", wantErr: "unsupported vault format version '3'"},
+		{name: "unsupported version with label", cipherText: "GOSSH-VAULT;1;prod;abcd", wantErr: "unsupported vault format version '1'"},
+		{name: "too many fields", cipherText: "GOSSH-VAULT;2;prod;x;abcd", wantErr: ErrDecrypt.Error()},
+		{name: "no cipher text", cipherText: "GOSSH-VAULT;2", wantErr: ErrDecrypt.Error()},
+		{name: "not hex", cipherText: "GOSSH-VAULT;2;prod;xyz", wantErr: ErrDecrypt.Error()},
+		{name: "too short", cipherText: "GOSSH-VAULT;2;abcd", wantErr: ErrDecrypt.Error()},
+		{name: "legacy without cipher text", cipherText: "GOSSH-AES256@prod", wantErr: ErrDecrypt.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := AES256Decode(tt.cipherText, "password")
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("AES256Decode() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCipherTextFormat(t *testing.T) {
+	tests := []struct {
+		text       string
+		wantLabel  string
+		wantAES256 bool
+		wantLegacy bool
+	}{
+		{text: "GOSSH-VAULT;2;abcd", wantAES256: true},
+		{text: "GOSSH-VAULT;2;prod;abcd", wantLabel: "prod", wantAES256: true},
+		{text: " GOSSH-VAULT;2;prod;abcd\n", wantLabel: "prod"},
+		{text: "GOSSH-AES256:abcd", wantAES256: true, wantLegacy: true},
+		{text: "GOSSH-AES256@prod:abcd", wantLabel: "prod", wantAES256: true, wantLegacy: true},
+		{text: "GOSSH-AES256@prod", wantAES256: true, wantLegacy: true},
+		{text: "GOSSH-AES256abcd"},
+		{text: "secret"},
+		{text: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := CipherTextLabel(tt.text); got != tt.wantLabel {
+				t.Errorf("CipherTextLabel() = %q, want %q", got, tt.wantLabel)
+			}
+			if got := IsAES256CipherText(tt.text); got != tt.wantAES256 {
+				t.Errorf("IsAES256CipherText() = %v, want %v", got, tt.wantAES256)
+			}
+			if got := IsLegacyCipherText(tt.text); got != tt.wantLegacy {
+				t.Errorf("IsLegacyCipherText() = %v, want %v", got, tt.wantLegacy)
+			}
+		})
+	}
+}
+
+func TestReplaceCipherTexts(t *testing.T) {
+	content := `password: GOSSH-VAULT;2;0a1b
+token: GOSSH-VAULT;2;prod;2c3d
+key: "GOSSH-AES256@dev:4e5f"
+old: GOSSH-AES256:6a7b
+plain: GOSSH-VAULT;x;abcd
+`
+
+	tests := []struct {
+		name        string
+		fn          func(string) (string, error)
+		wantContent string
+		wantCount   int
+		wantErr     bool
+	}{
+		{
+			name: "replace all",
+			fn: func(s string) (string, error) {
+				return "<" + CipherTextLabel(s) + ">", nil
+			},
+			wantContent: `password: <>
+token: <prod>
+key: "<dev>"
+old: <>
+plain: GOSSH-VAULT;x;abcd
+`,
+			wantCount: 4,
+		},
+		{
+			name: "unchanged are not counted",
+			fn: func(s string) (string, error) {
+				if IsLegacyCipherText(s) {
+					return "upgraded", nil
+				}
+				return s, nil
+			},
+			wantContent: strings.NewReplacer(
+				"GOSSH-AES256@dev:4e5f", "upgraded",
+				"GOSSH-AES256:6a7b", "upgraded",
+			).Replace(content),
+			wantCount: 2,
+		},
+		{
+			name: "error",
+			fn: func(s string) (string, error) {
+				if CipherTextLabel(s) == "prod" {
+					return "", ErrDecrypt
+				}
+				return "ok", nil
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, count, err := ReplaceCipherTexts(content, tt.fn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReplaceCipherTexts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.wantContent || count != tt.wantCount {
+				t.Errorf("ReplaceCipherTexts() = %q, %d, want %q, %d", got, count, tt.wantContent, tt.wantCount)
+			}
+		})
+	}
+}
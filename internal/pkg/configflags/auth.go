@@ -25,9 +25,11 @@ package configflags
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/pflag"
 
+	"github.com/serialt/gosible/internal/pkg/aes"
 	"github.com/serialt/gosible/pkg/util"
 )
 
@@ -40,6 +42,7 @@ const (
 	flagAuthIdentityFiles = "auth.identity-files"
 	flagAuthPassphrase    = "auth.passphrase"
 	flagAuthVaultPassFile = "auth.vault-pass-file"
+	flagAuthVaultID       = "auth.vault-id"
 )
 
 // Auth config.
@@ -51,6 +54,7 @@ type Auth struct {
 	IdentityFiles []string `json:"identity-files" mapstructure:"identity-files"`
 	Passphrase    string   `json:"passphrase" mapstructure:"passphrase"`
 	VaultPassFile string   `json:"vault-pass-file" mapstructure:"vault-pass-file"`
+	VaultIDs      []string `json:"vault-id" mapstructure:"vault-id"`
 }
 
 // NewAuth ...
@@ -63,6 +67,7 @@ func NewAuth() *Auth {
 		IdentityFiles: []string{},
 		Passphrase:    "",
 		VaultPassFile: "",
+		VaultIDs:      []string{},
 	}
 }
 
@@ -78,8 +83,12 @@ func (a *Auth) AddFlagsTo(fs *pflag.FlagSet) {
 	fs.StringVarP(&a.Passphrase, flagAuthPassphrase, "K", a.Passphrase,
 		"passphrase of the identity files")
 	fs.StringVarP(&a.VaultPassFile, flagAuthVaultPassFile, "V", a.VaultPassFile,
-		`text file or executable file that holds the vault password
-for encryption and decryption`)
+		`text file or executable file (args can follow it) that holds the vault
+password for encryption and decryption, or 'env:NAME' for environment variable`)
+	fs.StringArrayVar(&a.VaultIDs, flagAuthVaultID, nil,
+		`vault ids like 'prod@/path/vault-password-file-or-script', 'staging@env:NAME'
+or 'dev@prompt', the label is carried by ciphertexts encrypted with the vault password,
+give the flag once for each vault id`)
 }
 
 // Complete some flags value.
@@ -103,13 +112,87 @@ func (a *Auth) Validate() (errs []error) {
 		errs = append(errs, fmt.Errorf("invalid %s: %s not found", flagAuthPassFile, a.PassFile))
 	}
 
-	if a.VaultPassFile != "" && !util.FileExists(a.VaultPassFile) {
-		errs = append(errs, fmt.Errorf("invalid %s: %s not found", flagAuthVaultPassFile, a.VaultPassFile))
+	if a.VaultPassFile != "" {
+		if err := validateVaultPassSource(flagAuthVaultPassFile, a.VaultPassFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, v := range a.VaultIDs {
+		label, source := ParseVaultID(v)
+		if label != "" && !aes.IsValidLabel(label) {
+			errs = append(errs, fmt.Errorf(
+				"invalid %s: label '%s' of '%s' can only contain letters, digits, '_', '.' and '-'",
+				flagAuthVaultID, label, v,
+			))
+		}
+
+		if err := validateVaultPassSource(flagAuthVaultID, source); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	return
 }
 
+const (
+	// VaultIDPrompt is the source of vault ids that asks for the vault password
+	// from terminal prompt.
+	VaultIDPrompt = "prompt"
+
+	// VaultSourceEnvPrefix is the prefix of vault password sources that read
+	// the vault password from an environment variable, e.g. 'env:PROD_VAULT_PASS'.
+	VaultSourceEnvPrefix = "env:"
+)
+
+// ParseVaultID parses vault id like 'label@source', the label is empty if
+// there is no '@' in the vault id. It is split at the first '@', labels can
+// not contain '@' but sources can, e.g. 'prod@/path/script ops@bastion'.
+func ParseVaultID(vaultID string) (label, source string) {
+	i := strings.Index(vaultID, "@")
+	if i == -1 {
+		return "", vaultID
+	}
+
+	return vaultID[:i], vaultID[i+1:]
+}
+
+// SplitVaultPassSource splits vault password source like '/path/script arg...'
+// into the file and args, unless the whole source is an existing file.
+func SplitVaultPassSource(source string) (file string, args []string) {
+	if util.FileExists(source) {
+		return source, nil
+	}
+
+	fields := strings.Fields(source)
+	if len(fields) == 0 {
+		return source, nil
+	}
+
+	return fields[0], fields[1:]
+}
+
+func validateVaultPassSource(flag, source string) error {
+	if source == VaultIDPrompt {
+		return nil
+	}
+
+	if strings.HasPrefix(source, VaultSourceEnvPrefix) {
+		if strings.TrimPrefix(source, VaultSourceEnvPrefix) == "" {
+			return fmt.Errorf("invalid %s: environment variable name is empty in '%s'", flag, source)
+		}
+
+		return nil
+	}
+
+	file, _ := SplitVaultPassSource(source)
+	if !util.FileExists(file) {
+		return fmt.Errorf("invalid %s: %s not found", flag, file)
+	}
+
+	return nil
+}
+
 func getDefaultIdentityFiles() ([]string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
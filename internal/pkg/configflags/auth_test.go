@@ -0,0 +1,83 @@
+package configflags
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestParseVaultID(t *testing.T) {
+	tests := []struct {
+		vaultID    string
+		wantLabel  string
+		wantSource string
+	}{
+		{vaultID: "/path/pass-file", wantLabel: "", wantSource: "/path/pass-file"},
+		{vaultID: "prod@/path/pass-file", wantLabel: "prod", wantSource: "/path/pass-file"},
+		{vaultID: "dev@prompt", wantLabel: "dev", wantSource: "prompt"},
+		{vaultID: "staging@env:STAGING_PASS", wantLabel: "staging", wantSource: "env:STAGING_PASS"},
+		{vaultID: "prod@/path/script ops@bastion", wantLabel: "prod", wantSource: "/path/script ops@bastion"},
+		{vaultID: "@/path/pass-file", wantLabel: "", wantSource: "/path/pass-file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.vaultID, func(t *testing.T) {
+			label, source := ParseVaultID(tt.vaultID)
+			if label != tt.wantLabel || source != tt.wantSource {
+				t.Errorf("ParseVaultID() = %q, %q, want %q, %q", label, source, tt.wantLabel, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestVaultIDFlag(t *testing.T) {
+	a := NewAuth()
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	a.AddFlagsTo(fs)
+
+	err := fs.Parse([]string{
+		"--auth.vault-id", "prod@/path/script --labels a,b",
+		"--auth.vault-id", "dev@prompt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"prod@/path/script --labels a,b", "dev@prompt"}
+	if !reflect.DeepEqual(a.VaultIDs, want) {
+		t.Errorf("VaultIDs = %q, want %q", a.VaultIDs, want)
+	}
+}
+
+func TestSplitVaultPassSource(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vault pass")
+	if err := ioutil.WriteFile(file, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		wantFile string
+		wantArgs []string
+	}{
+		{name: "file", source: "/path/vault-pass", wantFile: "/path/vault-pass", wantArgs: []string{}},
+		{name: "existing file with spaces", source: file, wantFile: file},
+		{name: "args", source: "/path/script --label prod", wantFile: "/path/script", wantArgs: []string{"--label", "prod"}},
+		{name: "extra spaces", source: "  /path/script   a  ", wantFile: "/path/script", wantArgs: []string{"a"}},
+		{name: "empty", source: "", wantFile: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, args := SplitVaultPassSource(tt.source)
+			if file != tt.wantFile || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("SplitVaultPassSource() = %q, %q, want %q, %q", file, args, tt.wantFile, tt.wantArgs)
+			}
+		})
+	}
+}
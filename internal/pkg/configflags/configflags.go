@@ -24,13 +24,23 @@ package configflags
 
 import (
 	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/serialt/gosible/pkg/batchssh"
 )
 
 // Config instance.
 var Config *ConfigFlags
 
+// flagSet the config flags added to, IsSet looks up flags changed by command
+// line in it.
+var flagSet *pflag.FlagSet
+
 // ConfigFlags is cli flags that also in config file.
 type ConfigFlags struct {
 	Auth    *Auth    `json:"auth" mapstructure:"auth"`
@@ -55,6 +65,12 @@ func New() *ConfigFlags {
 
 // AddFlagsTo flagset.
 func (c *ConfigFlags) AddFlagsTo(flags *pflag.FlagSet) {
+	c.addFlagsTo(flags)
+
+	flagSet = flags
+}
+
+func (c *ConfigFlags) addFlagsTo(flags *pflag.FlagSet) {
 	c.Auth.AddFlagsTo(flags)
 	c.Hosts.AddFlagsTo(flags)
 	c.Run.AddFlagsTo(flags)
@@ -63,6 +79,39 @@ func (c *ConfigFlags) AddFlagsTo(flags *pflag.FlagSet) {
 	c.Timeout.AddFlagsTo(flags)
 }
 
+// IsSet reports whether the flag is changed by command line, or set by
+// environment or config file explicitly, even if it is set to the default
+// value. Empty values in config file, like 'user: ""' generated by
+// 'gossh config', are not treated as set.
+func IsSet(flag string) bool {
+	fs := flagSet
+	if fs == nil {
+		fs = pflag.NewFlagSet("config", pflag.ContinueOnError)
+		New().addFlagsTo(fs)
+	}
+
+	f := fs.Lookup(flag)
+	if f != nil && f.Changed {
+		return true
+	}
+
+	// Environment variables are looked up by viper.AutomaticEnv without
+	// prefix and key replacer.
+	if value, ok := os.LookupEnv(strings.ToUpper(flag)); ok && value != "" {
+		return true
+	}
+
+	if !viper.InConfig(flag) {
+		return false
+	}
+
+	if f != nil && strings.HasSuffix(f.Value.Type(), "Slice") {
+		return len(viper.GetStringSlice(flag)) != 0
+	}
+
+	return viper.GetString(flag) != ""
+}
+
 // String ...
 func (c *ConfigFlags) String() string {
 	data, _ := json.Marshal(c)
@@ -94,3 +143,17 @@ func (c *ConfigFlags) Validate() (errs []error) {
 
 	return
 }
+
+// ValidateTransferMethod validates flag '-m, --method' of subcommands that
+// copy files.
+func ValidateTransferMethod(method string) error {
+	if !hasEntry(batchssh.TransferMethods, method) {
+		return fmt.Errorf(
+			"invalid method '%s', available methods: %s",
+			method,
+			strings.Join(batchssh.TransferMethods, ", "),
+		)
+	}
+
+	return nil
+}
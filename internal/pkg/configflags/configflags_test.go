@@ -0,0 +1,86 @@
+package configflags
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func TestValidateTransferMethod(t *testing.T) {
+	tests := []struct {
+		method  string
+		wantErr bool
+	}{
+		{method: "auto"},
+		{method: "sftp"},
+		{method: "tar"},
+		{method: "zip", wantErr: true},
+		{method: "", wantErr: true},
+		{method: "TAR", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			if err := ValidateTransferMethod(tt.method); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTransferMethod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsSet(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		env    map[string]string
+		args   []string
+		flag   string
+		want   bool
+	}{
+		{name: "not set", flag: "hosts.port"},
+		{name: "commented out in config", config: "hosts:\n  # port: 22\n", flag: "hosts.port"},
+		{name: "empty string in config", config: "auth:\n  user: \"\"\n", flag: "auth.user"},
+		{name: "empty list in config", config: "auth:\n  identity-files: []\n", flag: "auth.identity-files"},
+		{name: "default value in config", config: "hosts:\n  port: 22\n", flag: "hosts.port", want: true},
+		{name: "other value in config", config: "hosts:\n  port: 2222\n", flag: "hosts.port", want: true},
+		{name: "list in config", config: "auth:\n  identity-files: [~/.ssh/id_rsa]\n", flag: "auth.identity-files", want: true},
+		{name: "default value by env", env: map[string]string{"HOSTS.PORT": "22"}, flag: "hosts.port", want: true},
+		{name: "empty env", env: map[string]string{"AUTH.USER": ""}, flag: "auth.user"},
+		{name: "default value by flag", args: []string{"--hosts.port", "22"}, flag: "hosts.port", want: true},
+		{name: "other flag changed", args: []string{"--auth.user", "root"}, flag: "hosts.port"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			viper.AutomaticEnv()
+
+			if tt.config != "" {
+				viper.SetConfigType("yaml")
+				if err := viper.ReadConfig(strings.NewReader(tt.config)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			New().AddFlagsTo(fs)
+			if err := viper.BindPFlags(fs); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := IsSet(tt.flag); got != tt.want {
+				t.Errorf("IsSet(%q) = %v, want %v", tt.flag, got, tt.want)
+			}
+		})
+	}
+}
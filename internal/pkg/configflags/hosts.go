@@ -24,31 +24,52 @@ package configflags
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/spf13/pflag"
 
+	"github.com/serialt/gosible/pkg/batchssh"
 	"github.com/serialt/gosible/pkg/util"
 )
 
 const (
-	flagHostsFile = "hosts.inventory"
-	flagHostsPort = "hosts.port"
-	flagHostsList = "hosts.list"
+	flagHostsFile            = "hosts.inventory"
+	flagHostsCacheTTL        = "hosts.inventory-cache-ttl"
+	flagHostsPort            = "hosts.port"
+	flagHostsList            = "hosts.list"
+	flagHostsHostKeyChecking = "hosts.host-key-checking"
+	flagHostsKnownHostsFiles = "hosts.known-hosts-files"
+	flagHostsLimit           = "hosts.limit"
+	flagHostsUseSSHConfig    = "hosts.use-ssh-config"
+	flagHostsSSHConfig       = "hosts.ssh-config"
+
+	// flagLimit is the short name of flagHostsLimit.
+	flagLimit = "limit"
 )
 
 // Hosts ...
 type Hosts struct {
-	Inventory string `json:"inventory" mapstructure:"inventory"`
-	Port      int    `json:"port" mapstructure:"port"`
-	List      bool   `json:"list" mapstructure:"list"`
+	Inventory       string   `json:"inventory" mapstructure:"inventory"`
+	CacheTTL        int      `json:"inventory-cache-ttl" mapstructure:"inventory-cache-ttl"`
+	Port            int      `json:"port" mapstructure:"port"`
+	List            bool     `json:"list" mapstructure:"list"`
+	HostKeyChecking string   `json:"host-key-checking" mapstructure:"host-key-checking"`
+	KnownHostsFiles []string `json:"known-hosts-files" mapstructure:"known-hosts-files"`
+	Limit           []string `json:"limit" mapstructure:"limit"`
+	UseSSHConfig    bool     `json:"use-ssh-config" mapstructure:"use-ssh-config"`
+	SSHConfig       string   `json:"ssh-config" mapstructure:"ssh-config"`
 }
 
 // NewHosts ...
 func NewHosts() *Hosts {
 	return &Hosts{
-		Inventory: "",
-		Port:      22,
-		List:      false,
+		Inventory:       "",
+		Port:            22,
+		List:            false,
+		HostKeyChecking: batchssh.HostKeyCheckingAcceptNew,
+		KnownHostsFiles: []string{},
+		UseSSHConfig:    false,
+		SSHConfig:       "",
 	}
 }
 
@@ -59,7 +80,15 @@ func (h *Hosts) AddFlagsTo(fs *pflag.FlagSet) {
 		flagHostsFile,
 		"i",
 		h.Inventory,
-		`file that holds the target hosts`,
+		`file or directory that holds the target hosts, or executable file that outputs them
+in json like Ansible dynamic inventory`,
+	)
+	fs.IntVarP(
+		&h.CacheTTL,
+		flagHostsCacheTTL,
+		"",
+		h.CacheTTL,
+		"seconds to cache the output of executable inventory file (0 disables the cache)",
 	)
 	fs.IntVarP(
 		&h.Port,
@@ -75,6 +104,54 @@ func (h *Hosts) AddFlagsTo(fs *pflag.FlagSet) {
 		h.List,
 		"outputs a list of target hosts, and does not do anything else",
 	)
+	fs.StringVarP(
+		&h.HostKeyChecking,
+		flagHostsHostKeyChecking,
+		"",
+		h.HostKeyChecking,
+		`how to verify host keys of target hosts and proxy servers
+(strict|accept-new|off)`,
+	)
+	fs.StringSliceVarP(
+		&h.KnownHostsFiles,
+		flagHostsKnownHostsFiles,
+		"",
+		h.KnownHostsFiles,
+		"extra known_hosts files besides $HOME/.ssh/known_hosts",
+	)
+	fs.StringSliceVarP(
+		&h.Limit,
+		flagHostsLimit,
+		"",
+		h.Limit,
+		`only run target hosts that are selected by the patterns like 'web:&prod:!web03' (alias '--limit'),
+'@FILE' reads them from a file, '@retry' from the retry file`,
+	)
+	fs.BoolVarP(
+		&h.UseSSHConfig,
+		flagHostsUseSSHConfig,
+		"",
+		h.UseSSHConfig,
+		`use HostName, Port, User, IdentityFile and ProxyJump of hosts in ssh_config
+if they are set by neither inventory nor flags`,
+	)
+	fs.StringVarP(
+		&h.SSHConfig,
+		flagHostsSSHConfig,
+		"",
+		h.SSHConfig,
+		"ssh_config file used by '--hosts.use-ssh-config' (default $HOME/.ssh/config)",
+	)
+}
+
+// NormalizeFlagName normalizes short names of flags like '--limit' to their
+// full names.
+func NormalizeFlagName(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+	if name == flagLimit {
+		name = flagHostsLimit
+	}
+
+	return pflag.NormalizedName(name)
 }
 
 // Complete ...
@@ -92,9 +169,40 @@ func (h *Hosts) Validate() (errs []error) {
 		))
 	}
 
-	if h.Inventory != "" && !util.FileExists(h.Inventory) {
+	if h.CacheTTL < 0 {
+		errs = append(errs, fmt.Errorf(
+			"invalid %s: %d - must be equal to or gather than 0",
+			flagHostsCacheTTL,
+			h.CacheTTL,
+		))
+	}
+
+	if h.Inventory != "" && !util.FileExists(h.Inventory) && !util.DirExists(h.Inventory) {
 		errs = append(errs, fmt.Errorf("invalid %s: %s not found", flagHostsFile, h.Inventory))
 	}
 
+	if h.SSHConfig != "" && !util.FileExists(h.SSHConfig) {
+		errs = append(errs, fmt.Errorf("invalid %s: %s not found", flagHostsSSHConfig, h.SSHConfig))
+	}
+
+	if !hasEntry(batchssh.HostKeyCheckingModes, h.HostKeyChecking) {
+		errs = append(errs, fmt.Errorf(
+			"invalid %s: %s - available values: %s",
+			flagHostsHostKeyChecking,
+			h.HostKeyChecking,
+			strings.Join(batchssh.HostKeyCheckingModes, ", "),
+		))
+	}
+
 	return
 }
+
+func hasEntry(items []string, item string) bool {
+	for _, v := range items {
+		if v == item {
+			return true
+		}
+	}
+
+	return false
+}
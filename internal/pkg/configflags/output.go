@@ -30,6 +30,7 @@ const (
 	flagOutputCondense = "output.condense"
 	flagOutputQuite    = "output.quiet"
 	flagOutputVerbose  = "output.verbose"
+	flagOutputStream   = "output.stream"
 )
 
 // Output ...
@@ -39,6 +40,7 @@ type Output struct {
 	Condense bool   `json:"condense" mapstructure:"condense"`
 	Quiet    bool   `json:"quiet" mapstructure:"quiet"`
 	Verbose  bool   `json:"verbose" mapstructure:"verbose"`
+	Stream   bool   `json:"stream" mapstructure:"stream"`
 }
 
 // NewOutput ...
@@ -49,6 +51,7 @@ func NewOutput() *Output {
 		Condense: false,
 		Quiet:    false,
 		Verbose:  false,
+		Stream:   false,
 	}
 }
 
@@ -60,6 +63,8 @@ func (o *Output) AddFlagsTo(flags *pflag.FlagSet) {
 	flags.BoolVarP(&o.Quiet, flagOutputQuite, "q", o.Quiet,
 		"do not output messages to screen (except error messages)")
 	flags.BoolVarP(&o.Verbose, flagOutputVerbose, "v", o.Verbose, "show debug messages")
+	flags.BoolVar(&o.Stream, flagOutputStream, o.Stream,
+		"output lines of commands/script as soon as they arrive, prefixed by hostname")
 }
 
 // Complete ...
@@ -62,7 +62,9 @@ func NewProxy() *Proxy {
 
 // AddFlagsTo pflagSet.
 func (p *Proxy) AddFlagsTo(fs *pflag.FlagSet) {
-	fs.StringVarP(&p.Server, flagProxyServer, "X", p.Server, "proxy server address")
+	fs.StringVarP(&p.Server, flagProxyServer, "X", p.Server,
+		`proxy server address, or chain of jump hosts
+(e.g. user@bastion1:22,user@jump2:2222)`)
 	fs.IntVarP(&p.Port, flagProxyPort, "", p.Port, "proxy server port")
 	fs.StringVarP(&p.User, flagProxyUser, "", p.User,
 		"login user for proxy (default same as 'auth.user')")
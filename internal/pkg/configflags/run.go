@@ -26,6 +26,8 @@ import (
 	"fmt"
 
 	"github.com/spf13/pflag"
+
+	"github.com/serialt/gosible/pkg/batchssh"
 )
 
 const (
@@ -33,6 +35,20 @@ const (
 	flagRunAsUser      = "run.as-user"
 	flagRunLang        = "run.lang"
 	flagRunConcurrency = "run.concurrency"
+	flagRunNoPTY       = "run.no-pty"
+
+	flagRunSerial            = "run.serial"
+	flagRunMaxFailPercentage = "run.max-fail-percentage"
+	flagRunAnyErrorsFatal    = "run.any-errors-fatal"
+	flagRunBatchPause        = "run.batch-pause"
+	flagRunBatchConfirm      = "run.batch-confirm"
+	flagRunRetries           = "run.retries"
+	flagRunRetryFile         = "run.retry-file"
+	flagRunMaxOpenConns      = "run.max-open-conns"
+	flagRunIdleTimeout       = "run.idle-timeout"
+
+	flagRunControlPersist = "run.control-persist"
+	flagRunControlDir     = "run.control-dir"
 )
 
 // Run ...
@@ -41,6 +57,20 @@ type Run struct {
 	AsUser      string `json:"as-user" mapstructure:"as-user"`
 	Lang        string `json:"lang" mapstructure:"lang"`
 	Concurrency int    `json:"concurrency" mapstructure:"concurrency"`
+	NoPTY       bool   `json:"no-pty" mapstructure:"no-pty"`
+
+	Serial            []string `json:"serial" mapstructure:"serial"`
+	MaxFailPercentage int      `json:"max-fail-percentage" mapstructure:"max-fail-percentage"`
+	AnyErrorsFatal    bool     `json:"any-errors-fatal" mapstructure:"any-errors-fatal"`
+	BatchPause        int      `json:"batch-pause" mapstructure:"batch-pause"`
+	BatchConfirm      bool     `json:"batch-confirm" mapstructure:"batch-confirm"`
+	Retries           int      `json:"retries" mapstructure:"retries"`
+	RetryFile         string   `json:"retry-file" mapstructure:"retry-file"`
+	MaxOpenConns      int      `json:"max-open-conns" mapstructure:"max-open-conns"`
+	IdleTimeout       int      `json:"idle-timeout" mapstructure:"idle-timeout"`
+
+	ControlPersist int    `json:"control-persist" mapstructure:"control-persist"`
+	ControlDir     string `json:"control-dir" mapstructure:"control-dir"`
 }
 
 // NewRun ...
@@ -49,6 +79,10 @@ func NewRun() *Run {
 		Sudo:        false,
 		AsUser:      "root",
 		Concurrency: 1,
+		ControlDir:  "~/.gossh/cm",
+
+		MaxFailPercentage: 100,
+		RetryFile:         "~/.gossh/retry",
 	}
 }
 
@@ -66,6 +100,38 @@ func (r *Run) AddFlagsTo(flags *pflag.FlagSet) {
 	)
 	flags.IntVarP(&r.Concurrency, flagRunConcurrency, "c", r.Concurrency,
 		"number of concurrent connections")
+	flags.BoolVar(&r.NoPTY, flagRunNoPTY, r.NoPTY,
+		`execute commands without pty, so that stdout and stderr are not mixed,
+and sudo reads password from stdin by 'sudo -S'`)
+	flags.StringSliceVar(&r.Serial, flagRunSerial, r.Serial,
+		`run target hosts in sequential batches of the sizes,
+the last size is used for remaining batches (e.g. 1,10%,50%)`)
+	flags.IntVar(&r.MaxFailPercentage, flagRunMaxFailPercentage, r.MaxFailPercentage,
+		`stop running subsequent batches if more than the percentage
+of hosts failed in a batch, 0 stops them once any host failed`)
+	flags.BoolVar(&r.AnyErrorsFatal, flagRunAnyErrorsFatal, r.AnyErrorsFatal,
+		"stop running any other target hosts once a host failed")
+	flags.IntVar(&r.BatchPause, flagRunBatchPause, r.BatchPause,
+		"pause seconds between batches of '--run.serial'")
+	flags.BoolVar(&r.BatchConfirm, flagRunBatchConfirm, r.BatchConfirm,
+		"ask for confirmation before each batch of '--run.serial' except the first one")
+	flags.IntVar(&r.Retries, flagRunRetries, r.Retries,
+		"number of retries for target hosts failed to connect, with increasing delays")
+	flags.StringVar(&r.RetryFile, flagRunRetryFile, r.RetryFile,
+		`file that failed target hosts are written to, rerun them by '@retry'
+(empty means do not write the file)`)
+	flags.IntVar(&r.MaxOpenConns, flagRunMaxOpenConns, r.MaxOpenConns,
+		`max number of open connections to target hosts, idle connections are
+closed when it is reached, connections to jump hosts are not counted
+(0 means no limit)`)
+	flags.IntVar(&r.IdleTimeout, flagRunIdleTimeout, r.IdleTimeout,
+		`keep unused connections open for reusing, unit: seconds
+(0 means closing connections as soon as they are not used)`)
+	flags.IntVar(&r.ControlPersist, flagRunControlPersist, r.ControlPersist,
+		`keep connections to target hosts open in background for reusing by
+subsequent gossh commands, unit: seconds (0 disables it)`)
+	flags.StringVar(&r.ControlDir, flagRunControlDir, r.ControlDir,
+		"directory for control sockets of background connections")
 }
 
 // Complete ...
@@ -83,5 +149,57 @@ func (r *Run) Validate() (errs []error) {
 		))
 	}
 
+	if _, err := batchssh.BatchSizes(r.Serial, 100); err != nil {
+		errs = append(errs, fmt.Errorf("invalid %s: %s", flagRunSerial, err))
+	}
+
+	if r.MaxFailPercentage < 0 || r.MaxFailPercentage > 100 {
+		errs = append(errs, fmt.Errorf(
+			"invalid %s: %d - must be between 0 and 100",
+			flagRunMaxFailPercentage,
+			r.MaxFailPercentage,
+		))
+	}
+
+	if r.BatchPause < 0 {
+		errs = append(errs, fmt.Errorf(
+			"invalid %s: %d - must be equal to or gather than 0",
+			flagRunBatchPause,
+			r.BatchPause,
+		))
+	}
+
+	if r.Retries < 0 {
+		errs = append(errs, fmt.Errorf(
+			"invalid %s: %d - must be equal to or gather than 0",
+			flagRunRetries,
+			r.Retries,
+		))
+	}
+
+	if r.MaxOpenConns < 0 {
+		errs = append(errs, fmt.Errorf(
+			"invalid %s: %d - must be equal to or gather than 0",
+			flagRunMaxOpenConns,
+			r.MaxOpenConns,
+		))
+	}
+
+	if r.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf(
+			"invalid %s: %d - must be equal to or gather than 0",
+			flagRunIdleTimeout,
+			r.IdleTimeout,
+		))
+	}
+
+	if r.ControlPersist < 0 {
+		errs = append(errs, fmt.Errorf(
+			"invalid %s: %d - must be equal to or gather than 0",
+			flagRunControlPersist,
+			r.ControlPersist,
+		))
+	}
+
 	return
 }
@@ -0,0 +1,159 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package sshtask
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/serialt/gosible/pkg/inventory"
+	"github.com/serialt/gosible/pkg/log"
+)
+
+// retryHostsFile is the name of '@retry' for the retry file.
+const retryHostsFile = "retry"
+
+// retryFile returns path of run.retry-file, '~' is expanded.
+func (t *Task) retryFile() string {
+	file := t.configFlags.Run.RetryFile
+	if strings.HasPrefix(file, "~/") {
+		file = strings.Replace(file, "~", os.Getenv("HOME"), 1)
+	}
+
+	return file
+}
+
+// expandHostFiles replaces items like '@FILE' with the hosts in FILE, one
+// host per line, '@retry' is the retry file.
+func (t *Task) expandHostFiles(items []string) ([]string, error) {
+	var hosts []string
+
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if !strings.HasPrefix(item, "@") {
+			hosts = append(hosts, item)
+			continue
+		}
+
+		file := strings.TrimPrefix(item, "@")
+		if file == retryHostsFile {
+			if file = t.retryFile(); file == "" {
+				return nil, fmt.Errorf("'%s' needs flag '--run.retry-file'", item)
+			}
+		} else if strings.HasPrefix(file, "~/") {
+			file = strings.Replace(file, "~", os.Getenv("HOME"), 1)
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) && item == "@"+retryHostsFile {
+				return nil, fmt.Errorf("retry file '%s' not found, no hosts failed last time", file)
+			}
+
+			return nil, fmt.Errorf("read hosts of '%s' failed: %w", item, err)
+		}
+
+		count := 0
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			hosts = append(hosts, line)
+			count++
+		}
+
+		log.Debugf("Host Info: read %d hosts from '%s'", count, file)
+	}
+
+	return hosts, nil
+}
+
+// limitSet returns aliases that are selected by hosts.limit, nil means no
+// limit.
+func (t *Task) limitSet(aliases []string) (map[string]bool, error) {
+	if len(t.configFlags.Hosts.Limit) == 0 {
+		return nil, nil
+	}
+
+	inv, err := t.getInventory()
+	if err != nil {
+		return nil, err
+	}
+
+	if inv == nil {
+		inv = inventory.New()
+	}
+
+	hosts := make([]*inventory.Host, 0, len(aliases))
+	for _, v := range aliases {
+		hosts = append(hosts, &inventory.Host{Alias: v, Host: v})
+	}
+
+	limited, err := inv.Filter(hosts, t.configFlags.Hosts.Limit...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid '--hosts.limit': %w", err)
+	}
+
+	log.Debugf("Host Info: %d of %d hosts are selected by limit '%s'",
+		len(limited), len(hosts), strings.Join(t.configFlags.Hosts.Limit, ","))
+
+	limit := make(map[string]bool, len(limited))
+	for _, v := range limited {
+		limit[v.Alias] = true
+	}
+
+	return limit, nil
+}
+
+// writeRetryFile writes aliases of failed hosts to the retry file, the file
+// is removed if no host failed.
+func (t *Task) writeRetryFile(failedHosts []string) (string, error) {
+	file := t.retryFile()
+	if file == "" {
+		return "", nil
+	}
+
+	if len(failedHosts) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+
+		return "", nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return "", err
+	}
+
+	content := strings.Join(failedHosts, "\n") + "\n"
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		return "", err
+	}
+
+	return file, nil
+}
@@ -23,17 +23,24 @@ THE SOFTWARE.
 package sshtask
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ScaleFT/sshkeys"
-	"github.com/go-project-pkg/expandhost"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/term"
@@ -44,13 +51,16 @@ import (
 	"github.com/serialt/gosible/pkg/batchssh"
 	"github.com/serialt/gosible/pkg/inventory"
 	"github.com/serialt/gosible/pkg/log"
+	"github.com/serialt/gosible/pkg/sshconfig"
 	"github.com/serialt/gosible/pkg/util"
 )
 
+const timeFormat = "2006-01-02 15:04:05.000000"
+
 var (
 	linuxUserRegex  = "[a-zA-Z0-9_.-]+[$]?"
 	sudoPromptRegex = fmt.Sprintf(
-		`(?s).*\[sudo\] password for %s:(\n|)|(?s).*\[sudo\] %s 的密码：(\n|)`,
+		`(?s).*\[sudo\] password for %s: ?(\n|)|(?s).*\[sudo\] %s 的密码：(\n|)`,
 		linuxUserRegex,
 		linuxUserRegex,
 	)
@@ -65,6 +75,7 @@ const (
 	ScriptTask
 	PushTask
 	FetchTask
+	SyncTask
 )
 
 // taskResult ...
@@ -72,7 +83,9 @@ type taskResult struct {
 	taskID            string
 	hostsSuccessCount int
 	hostsFailureCount int
+	hostsSkippedCount int
 	elapsed           float64
+	retryFile         string
 }
 
 // detailResult each ssh host result.
@@ -81,11 +94,14 @@ type detailResult struct {
 	hostname string
 	status   string
 	output   string
-}
 
-type pushFiles struct {
-	files    []string
-	zipFiles []string
+	exitCode  int
+	stdout    string
+	stderr    string
+	signal    string
+	startTime time.Time
+	endTime   time.Time
+	duration  time.Duration
 }
 
 // Task ...
@@ -101,6 +117,16 @@ type Task struct {
 	defaultPass           *string
 	defaultIdentityFiles  []string
 	defaultSSHAuthMethods []ssh.AuthMethod
+	defaultSigners        []ssh.Signer
+
+	proxyAuths []ssh.AuthMethod
+	proxyHops  map[string][]*batchssh.ProxyHop
+
+	// inventory loaded from hosts.inventory, it is nil if no inventory file.
+	inventory *inventory.Inventory
+
+	// sshConfig loaded from hosts.ssh-config if hosts.use-ssh-config is on.
+	sshConfig *sshconfig.Config
 
 	// Hostname or IP or host pattern or host group from command line arguments.
 	argHosts []string
@@ -108,16 +134,24 @@ type Task struct {
 	command    string
 	scriptFile string
 
-	pushFiles      *pushFiles
+	pushFiles      []string
+	transferMethod string
 	fetchFiles     []string
+	syncOptions    batchssh.SyncOptions
 	dstDir         string
-	tmpDir         string
 	remove         bool
 	allowOverwrite bool
 
 	taskOutput   chan taskResult
 	detailOutput chan detailResult
 
+	// outputCount is the number of detail results output, batches of
+	// run.serial wait for results of previous batches by outputCond.
+	outputCount int
+	outputCond  *sync.Cond
+	hostsCount  int
+	stdin       *bufio.Reader
+
 	err error
 }
 
@@ -136,6 +170,7 @@ func NewTask(taskType TaskType, configFlags *configflags.ConfigFlags) *Task {
 		defaultIdentityFiles: defaultIdentityFiles,
 		taskOutput:           make(chan taskResult, 1),
 		detailOutput:         make(chan detailResult),
+		outputCond:           sync.NewCond(&sync.Mutex{}),
 	}
 }
 
@@ -145,25 +180,44 @@ func (t *Task) Start() {
 		defer t.sshAgent.Close()
 	}
 
-	go func() {
-		defer close(t.taskOutput)
-		defer close(t.detailOutput)
-		t.BatchRun()
-	}()
+	// The first Ctrl-C cancels the task, and the second one exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	taskTimeout := t.configFlags.Timeout.Task
 	if taskTimeout > 0 {
-		go func() {
-			time.Sleep(time.Duration(taskTimeout) * time.Second)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(taskTimeout)*time.Second)
+		defer cancel()
+	}
+
+	finished := make(chan struct{})
+	defer close(finished)
+
+	go func() {
+		select {
+		case <-finished:
+			return
+		case <-ctx.Done():
+			stop()
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 			log.Warnf(
 				"task timeout, taskID: %s, timeout value: %d seconds",
 				t.id,
 				taskTimeout,
 			)
-			close(t.detailOutput)
-			close(t.taskOutput)
-		}()
-	}
+		} else {
+			log.Warnf("task cancelled, taskID: %s", t.id)
+		}
+	}()
+
+	go func() {
+		defer close(t.taskOutput)
+		defer close(t.detailOutput)
+		t.BatchRun(ctx)
+	}()
 
 	t.HandleOutput()
 }
@@ -184,11 +238,8 @@ func (t *Task) SetScriptFile(sciptFile string) {
 }
 
 // SetPushfiles ...
-func (t *Task) SetPushfiles(files, zipFiles []string) {
-	t.pushFiles = &pushFiles{
-		files:    files,
-		zipFiles: zipFiles,
-	}
+func (t *Task) SetPushfiles(files []string) {
+	t.pushFiles = files
 }
 
 // SetFetchFiles ...
@@ -204,43 +255,77 @@ func (t *Task) SetScriptOptions(destPath string, remove, allowOverwrite bool) {
 }
 
 // SetPushOptions ...
-func (t *Task) SetPushOptions(destPath string, allowOverwrite bool) {
+func (t *Task) SetPushOptions(destPath string, allowOverwrite bool, transferMethod string) {
 	t.dstDir = destPath
 	t.allowOverwrite = allowOverwrite
+	t.transferMethod = transferMethod
+}
+
+// SetSyncOptions ...
+func (t *Task) SetSyncOptions(destPath string, options batchssh.SyncOptions) {
+	t.dstDir = destPath
+	t.syncOptions = options
 }
 
 // SetFetchOptions ...
-func (t *Task) SetFetchOptions(destPath, tmpDir string) {
+func (t *Task) SetFetchOptions(destPath, transferMethod string) {
 	t.dstDir = destPath
-	t.tmpDir = tmpDir
+	t.transferMethod = transferMethod
 }
 
 // RunSSH implements batchssh.Task
-func (t *Task) RunSSH(host *batchssh.Host) (string, error) {
+func (t *Task) RunSSH(ctx context.Context, host *batchssh.Host) (*batchssh.Output, error) {
 	lang := t.configFlags.Run.Lang
 	runAs := t.configFlags.Run.AsUser
 	sudo := t.configFlags.Run.Sudo
 
+	var (
+		msg string
+		err error
+	)
+
 	switch t.taskType {
 	case CommandTask:
-		return t.sshClient.ExecuteCmd(host, t.command, lang, runAs, sudo)
+		return t.sshClient.ExecuteCmd(ctx, host, t.command, lang, runAs, sudo)
 	case ScriptTask:
-		return t.sshClient.ExecuteScript(host, t.scriptFile, t.dstDir, lang, runAs, sudo, t.remove, t.allowOverwrite)
+		return t.sshClient.ExecuteScript(ctx, host, t.scriptFile, t.dstDir, lang, runAs, sudo, t.remove, t.allowOverwrite)
 	case PushTask:
-		return t.sshClient.PushFiles(host, t.pushFiles.files, t.pushFiles.zipFiles, t.dstDir, t.allowOverwrite)
+		msg, err = t.sshClient.PushFiles(ctx, host, t.pushFiles, t.dstDir, t.allowOverwrite)
 	case FetchTask:
-		return t.sshClient.FetchFiles(host, t.fetchFiles, t.dstDir, t.tmpDir, sudo, runAs)
+		msg, err = t.sshClient.FetchFiles(ctx, host, t.fetchFiles, t.dstDir, sudo, runAs)
+	case SyncTask:
+		var stats *batchssh.SyncStats
+		if stats, err = t.sshClient.SyncFiles(ctx, host, t.pushFiles, t.dstDir, t.syncOptions); err == nil {
+			msg = stats.String()
+		}
 	default:
-		return "", fmt.Errorf("unknown task type: %v", t.taskType)
+		return nil, fmt.Errorf("unknown task type: %v", t.taskType)
+	}
+
+	if err != nil {
+		return nil, err
 	}
+
+	return &batchssh.Output{Stdout: msg}, nil
 }
 
 // BatchRun ...
 //
 //nolint:gocyclo
-func (t *Task) BatchRun() {
+func (t *Task) BatchRun(ctx context.Context) {
 	timeNow := time.Now()
 
+	var err error
+	if t.argHosts, err = t.expandHostFiles(t.argHosts); err != nil {
+		t.err = err
+		return
+	}
+
+	if t.configFlags.Hosts.Limit, err = t.expandHostFiles(t.configFlags.Hosts.Limit); err != nil {
+		t.err = err
+		return
+	}
+
 	if t.configFlags.Hosts.List {
 		allHosts, err := t.ListHosts()
 		if err != nil {
@@ -278,9 +363,15 @@ func (t *Task) BatchRun() {
 			t.err = errors.New("need flag '-e/--execute' or '-l/--hosts.list'")
 		}
 	case PushTask:
-		if t.pushFiles == nil || len(t.pushFiles.files) == 0 {
+		if len(t.pushFiles) == 0 {
 			t.err = errors.New("need flag '-f/--files' or '-l/--hosts.list'")
 		}
+	case SyncTask:
+		if len(t.pushFiles) == 0 {
+			t.err = errors.New("need flag '-f/--files' or '-l/--hosts.list'")
+		} else if len(t.dstDir) == 0 {
+			t.err = errors.New("need flag '-d/--dest-path' or '-l/--hosts.list'")
+		}
 	case FetchTask:
 		if len(t.fetchFiles) == 0 {
 			t.err = errors.New("need flag '-f/--files' or '-l/--hosts.list'")
@@ -300,85 +391,177 @@ func (t *Task) BatchRun() {
 
 	t.setDefaultSSHAuthMethods()
 
-	t.buildSSHClient()
-
 	allHosts, err := t.getAllHosts()
 	if err != nil {
 		t.err = err
 		return
 	}
 
+	if err := t.buildSSHClient(); err != nil {
+		t.err = err
+		return
+	}
+
+	// Passwords of hosts and jump hosts are all decrypted.
+	aes.ClearKeyCache()
+
+	t.hostsCount = len(allHosts)
+
 	log.Debugf("got target hosts, count: %d", len(allHosts))
 
-	result := t.sshClient.BatchRun(allHosts, t)
-	successCount, failedCount := 0, 0
+	defer t.sshClient.Close()
+
+	result, err := t.sshClient.BatchRunContext(ctx, allHosts, t)
+	if err != nil {
+		t.err = err
+		return
+	}
+
+	successCount, failedCount, skippedCount := 0, 0, 0
+	failed := make(map[string]bool)
 	for v := range result {
-		if v.Status == batchssh.SuccessIdentifier {
+		switch v.Status {
+		case batchssh.SuccessIdentifier:
 			successCount++
-		} else {
+		case batchssh.SkippedIdentifier:
+			skippedCount++
+			failed[v.Host] = true
+		default:
 			failedCount++
+			failed[v.Host] = true
 		}
 
 		t.detailOutput <- detailResult{
-			taskID:   t.id,
-			hostname: v.Host,
-			status:   v.Status,
-			output:   v.Message,
+			taskID:    t.id,
+			hostname:  v.Host,
+			status:    v.Status,
+			output:    v.Message,
+			exitCode:  v.ExitCode,
+			stdout:    v.Stdout,
+			stderr:    v.Stderr,
+			signal:    v.Signal,
+			startTime: v.StartTime,
+			endTime:   v.EndTime,
+			duration:  v.Duration,
 		}
 	}
 
 	elapsed := time.Since(timeNow).Seconds()
 
+	// Failed hosts are kept in the order of target hosts.
+	var failedHosts []string
+	for _, v := range allHosts {
+		if failed[v.Alias] {
+			failedHosts = append(failedHosts, v.Alias)
+		}
+	}
+
+	retryFile, err := t.writeRetryFile(failedHosts)
+	if err != nil {
+		log.Warnf("write retry file failed: %s", err)
+	}
+
 	t.taskOutput <- taskResult{
 		t.id,
 		successCount,
 		failedCount,
+		skippedCount,
 		elapsed,
+		retryFile,
 	}
 }
 
 // HandleOutput ...
 func (t *Task) HandleOutput() {
 	for res := range t.detailOutput {
-		// Fix the problem of special characters ^M appearing at the end of
-		// the line break when writing files in text format.
-		outputNoR := strings.ReplaceAll(res.output, "\r\n", "\n")
+		// Trim leading and trailing blank characters.
+		output := strings.TrimSpace(cleanOutput(res.output))
 
-		// Trim sudo password prompt messages.
-		outputNoSudoPrompt := ""
-		re, err := regexp.Compile(sudoPromptRegex)
-		if err != nil {
-			log.Debugf("re compile '%s' failed: %s", sudoPromptRegex, err)
-		} else {
-			outputNoSudoPrompt = re.ReplaceAllString(outputNoR, "")
+		// The output has been streamed already.
+		if t.configFlags.Output.Stream && !t.configFlags.Output.JSON && (res.stdout != "" || res.stderr != "") {
+			output = ""
 		}
 
-		// Trim leading and trailing blank characters.
-		output := strings.TrimSpace(outputNoSudoPrompt)
-
 		contextLogger := log.WithFields(log.Fields{
-			"hostname": res.hostname,
-			"status":   res.status,
-			"output":   output,
+			"hostname":   res.hostname,
+			"status":     res.status,
+			"output":     output,
+			"exit_code":  res.exitCode,
+			"stdout":     cleanOutput(res.stdout),
+			"stderr":     cleanOutput(res.stderr),
+			"signal":     res.signal,
+			"start_time": res.startTime.Format(timeFormat),
+			"end_time":   res.endTime.Format(timeFormat),
+			"duration":   res.duration.Seconds(),
 		})
 
-		if res.status == batchssh.SuccessIdentifier {
+		switch res.status {
+		case batchssh.SuccessIdentifier:
 			contextLogger.Infof("success")
-		} else {
+		case batchssh.SkippedIdentifier:
+			contextLogger.Warnf("skipped")
+		default:
 			contextLogger.Errorf("failed")
 		}
+
+		t.outputCond.L.Lock()
+		t.outputCount++
+		t.outputCond.Broadcast()
+		t.outputCond.L.Unlock()
 	}
 
 	for res := range t.taskOutput {
-		log.Infof(
-			"success count: %d, failed count: %d, elapsed: %.2fs",
-			res.hostsSuccessCount,
-			res.hostsFailureCount,
-			res.elapsed,
-		)
+		if res.hostsSkippedCount != 0 {
+			log.Infof(
+				"success count: %d, failed count: %d, skipped count: %d, elapsed: %.2fs",
+				res.hostsSuccessCount,
+				res.hostsFailureCount,
+				res.hostsSkippedCount,
+				res.elapsed,
+			)
+		} else {
+			log.Infof(
+				"success count: %d, failed count: %d, elapsed: %.2fs",
+				res.hostsSuccessCount,
+				res.hostsFailureCount,
+				res.elapsed,
+			)
+		}
+
+		if res.retryFile != "" {
+			log.Infof("failed hosts are written to '%s', rerun them by '@retry'", res.retryFile)
+		}
 	}
 }
 
+// streamOutput implements batchssh.StreamHandler.
+func (t *Task) streamOutput(host *batchssh.Host, stream, line string) {
+	if strings.Contains(line, "[sudo]") {
+		line = cleanOutput(line)
+		if strings.TrimSpace(line) == "" {
+			return
+		}
+	}
+
+	log.Stream(host.Alias, stream, line)
+}
+
+// cleanOutput removes sudo password prompt messages and carriage returns.
+func cleanOutput(output string) string {
+	// Fix the problem of special characters ^M appearing at the end of
+	// the line break when writing files in text format.
+	outputNoR := strings.ReplaceAll(output, "\r\n", "\n")
+
+	// Trim sudo password prompt messages.
+	re, err := regexp.Compile(sudoPromptRegex)
+	if err != nil {
+		log.Debugf("re compile '%s' failed: %s", sudoPromptRegex, err)
+		return ""
+	}
+
+	return re.ReplaceAllString(outputNoR, "")
+}
+
 // CheckErr ...
 func (t *Task) CheckErr() error {
 	return t.err
@@ -390,23 +573,17 @@ func (t *Task) ListHosts() ([]string, error) {
 
 	if t.configFlags.Hosts.Inventory == "" {
 		if len(t.argHosts) != 0 {
-			for _, hostOrPattern := range t.argHosts {
-				hostOrPattern = strings.TrimSpace(hostOrPattern)
-
-				if hostOrPattern == "" {
-					continue
-				}
-
-				hostList, err := expandhost.PatternToHosts(hostOrPattern)
-				if err != nil {
-					return nil, fmt.Errorf("invalid host pattern: %s", err)
-				}
+			argHosts, err := inventory.New().Select(t.argHosts...)
+			if err != nil {
+				return nil, err
+			}
 
-				hosts = append(hosts, hostList...)
+			for _, v := range argHosts {
+				hosts = append(hosts, v.Alias)
 			}
 		}
 
-		return deDuplicate(hosts), nil
+		return t.limitAliases(hosts)
 	}
 
 	targetHosts, err := t.getInventoryHosts()
@@ -418,49 +595,73 @@ func (t *Task) ListHosts() ([]string, error) {
 		hosts = append(hosts, v.Alias)
 	}
 
+	return t.limitAliases(hosts)
+}
+
+// limitAliases filters aliases by hosts.limit.
+func (t *Task) limitAliases(aliases []string) ([]string, error) {
+	limit, err := t.limitSet(aliases)
+	if err != nil || limit == nil {
+		return aliases, err
+	}
+
+	var hosts []string
+	for _, v := range aliases {
+		if limit[v] {
+			hosts = append(hosts, v)
+		}
+	}
+
 	return hosts, nil
 }
 
+// limitHosts filters hosts by hosts.limit.
+func (t *Task) limitHosts(hosts []*inventory.Host) ([]*inventory.Host, error) {
+	aliases := make([]string, 0, len(hosts))
+	for _, v := range hosts {
+		aliases = append(aliases, v.Alias)
+	}
+
+	limit, err := t.limitSet(aliases)
+	if err != nil || limit == nil {
+		return hosts, err
+	}
+
+	var limited []*inventory.Host
+	for _, v := range hosts {
+		if limit[v.Alias] {
+			limited = append(limited, v)
+		}
+	}
+
+	if len(limited) == 0 {
+		return nil, errors.New("no target hosts match '--hosts.limit'")
+	}
+
+	return limited, nil
+}
+
 func (t *Task) getAllHosts() ([]*batchssh.Host, error) {
 	var hosts []*batchssh.Host
 
 	helpErr := errors.New(
 		"need target hosts, you can specify hosts file by flag '-i', provide host/pattern/group as positional arguments")
 
-	if t.configFlags.Hosts.Inventory == "" {
-		if len(t.argHosts) != 0 {
-			for _, hostOrPattern := range t.argHosts {
-				hostOrPattern = strings.TrimSpace(hostOrPattern)
-
-				if hostOrPattern == "" {
-					continue
-				}
-
-				hostList, err := expandhost.PatternToHosts(hostOrPattern)
-				if err != nil {
-					return nil, fmt.Errorf("invalid host pattern: %s", err)
-				}
-
-				for _, v := range hostList {
-					hosts = append(hosts, &batchssh.Host{
-						Alias:    v,
-						Host:     v,
-						Port:     t.configFlags.Hosts.Port,
-						User:     t.defaultUser,
-						Password: *t.defaultPass,
-						Keys:     t.defaultIdentityFiles,
-						SSHAuths: t.defaultSSHAuthMethods,
-					})
-				}
-			}
+	var (
+		targetHosts []*inventory.Host
+		err         error
+	)
 
-			return deDuplSSHHosts(hosts), nil
+	if t.configFlags.Hosts.Inventory == "" {
+		if len(t.argHosts) == 0 {
+			return nil, helpErr
 		}
 
-		return nil, helpErr
+		targetHosts, err = inventory.New().Select(t.argHosts...)
+	} else {
+		targetHosts, err = t.getInventoryHosts()
 	}
 
-	targetHosts, err := t.getInventoryHosts()
 	if err != nil {
 		return nil, err
 	}
@@ -469,8 +670,27 @@ func (t *Task) getAllHosts() ([]*batchssh.Host, error) {
 		return nil, helpErr
 	}
 
-	for _, v := range inventory.DeDuplHosts(targetHosts) {
-		var hostSSHAuths []ssh.AuthMethod
+	// Hosts are limited before they are decrypted, so that only vault
+	// passwords of the target hosts are needed.
+	targetHosts, err = t.limitHosts(inventory.DeDuplHosts(targetHosts))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range targetHosts {
+		var (
+			hostSSHAuths []ssh.AuthMethod
+			hostSigners  []ssh.Signer
+		)
+
+		// v is a copy, hosts of the inventory are shared and not modified.
+		if v, err = t.decryptHost(v); err != nil {
+			return nil, err
+		}
+
+		if err := t.applySSHConfig(v); err != nil {
+			return nil, err
+		}
 
 		if v.Port == 0 {
 			v.Port = t.configFlags.Hosts.Port
@@ -484,28 +704,29 @@ func (t *Task) getAllHosts() ([]*batchssh.Host, error) {
 			log.Debugf("Host Info: individual user '%s' for '%s'", v.User, v.Alias)
 		}
 
-		if len(v.Keys) != 0 {
-			keys := parseItentityFiles(v.Keys)
-			assignRealPass(&v.Passphrase, v.Alias, "passphrase")
-			sshSigners := getSigners(keys, v.Passphrase, "Individual")
-			if len(sshSigners) == 0 {
-				log.Debugf("Individual Auth: no valid individual identity files for '%s'", v.Alias)
-			} else {
-				hostSSHAuths = append(hostSSHAuths, ssh.PublicKeys(sshSigners...))
-				log.Debugf("Individual Auth: add individual pubkey auth for '%s'", v.Alias)
-			}
-		}
+		hostSSHAuths, hostSigners = getIndividualSSHAuthMethods(v)
 
 		if v.Password == "" {
 			v.Password = *t.defaultPass
 			assignRealPass(&v.Password, v.Alias, "password")
-		} else {
-			assignRealPass(&v.Password, v.Alias, "password")
-			hostSSHAuths = append(hostSSHAuths, ssh.Password(v.Password))
-			log.Debugf("Individual Auth: add individual password for '%s'", v.Alias)
 		}
 
 		hostSSHAuths = append(hostSSHAuths, t.defaultSSHAuthMethods...)
+		hostSigners = append(hostSigners, t.defaultSigners...)
+
+		var proxies []*batchssh.ProxyHop
+		switch v.Proxy {
+		case "":
+		case "none":
+			proxies = []*batchssh.ProxyHop{}
+			log.Debugf("Host Info: connect '%s' without proxy", v.Alias)
+		default:
+			proxies, err = t.getProxyHops(v.Proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy of '%s': %w", v.Alias, err)
+			}
+			log.Debugf("Host Info: individual proxy '%s' for '%s'", v.Proxy, v.Alias)
+		}
 
 		hosts = append(hosts, &batchssh.Host{
 			Alias:    v.Alias,
@@ -515,78 +736,413 @@ func (t *Task) getAllHosts() ([]*batchssh.Host, error) {
 			Password: v.Password,
 			Keys:     v.Keys,
 			SSHAuths: hostSSHAuths,
+			Signers:  hostSigners,
+			Proxies:  proxies,
+			Vars:     v.Vars,
 		})
 	}
 
 	return hosts, nil
 }
 
-func (t *Task) getInventoryHosts() ([]*inventory.Host, error) {
-	var fileHosts []*inventory.Host
+// decryptHost returns a copy of v whose built-in variables are decrypted.
+func (t *Task) decryptHost(v *inventory.Host) (*inventory.Host, error) {
+	inv, err := t.getInventory()
+	if err != nil {
+		return nil, err
+	}
+
+	if inv == nil {
+		inv = inventory.New()
+	}
+
+	return inv.Decrypt(v)
+}
+
+// getSSHConfig loads ssh_config once if hosts.use-ssh-config is on, it
+// returns nil if the default ssh_config does not exist.
+func (t *Task) getSSHConfig() (*sshconfig.Config, error) {
+	if t.sshConfig != nil || !t.configFlags.Hosts.UseSSHConfig {
+		return t.sshConfig, nil
+	}
+
+	file := t.configFlags.Hosts.SSHConfig
+	if file == "" {
+		file = filepath.Join(os.Getenv("HOME"), ".ssh", "config")
+		if !util.FileExists(file) {
+			log.Debugf("SSH Config: '%s' not found", file)
+			return nil, nil
+		}
+	}
+
+	c, err := sshconfig.Load(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh config: %w", err)
+	}
+	log.Debugf("SSH Config: loaded '%s'", file)
+	t.sshConfig = c
+
+	return c, nil
+}
+
+// applySSHConfig fills host, port, user, identity files and proxy of v from
+// ssh_config, if they are set by neither inventory nor flags.
+func (t *Task) applySSHConfig(v *inventory.Host) error {
+	c, err := t.getSSHConfig()
+	if err != nil || c == nil {
+		return err
+	}
+
+	h := c.Host(v.Alias)
+
+	if h.HostName != "" && v.Host == v.Alias {
+		v.Host = h.HostName
+		log.Debugf("SSH Config: host '%s' for '%s'", v.Host, v.Alias)
+	}
+
+	if h.Port != 0 && v.Port == 0 && !configflags.IsSet("hosts.port") {
+		v.Port = h.Port
+	}
+
+	if h.User != "" && v.User == "" && !configflags.IsSet("auth.user") {
+		v.User = h.User
+	}
+
+	if len(h.IdentityFiles) != 0 && len(v.Keys) == 0 && !configflags.IsSet("auth.identity-files") {
+		v.Keys = h.IdentityFiles
+		log.Debugf("SSH Config: identity files '%s' for '%s'", strings.Join(v.Keys, ","), v.Alias)
+	}
+
+	if h.ProxyJump != "" && v.Proxy == "" && t.configFlags.Proxy.Server == "" {
+		v.Proxy = h.ProxyJump
+	}
+
+	return nil
+}
+
+// sshConfigProxyHost returns the jump host from ssh_config, settings set by
+// proxy flags are not used. It returns nil if hosts.use-ssh-config is off.
+func (t *Task) sshConfigProxyHost(alias string) (*inventory.Host, error) {
+	c, err := t.getSSHConfig()
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	h := c.Host(alias)
+	v := &inventory.Host{Alias: alias, Host: alias}
+
+	if h.HostName != "" {
+		v.Host = h.HostName
+	}
+
+	if !configflags.IsSet("proxy.port") {
+		v.Port = h.Port
+	}
+
+	if !configflags.IsSet("proxy.user") && !configflags.IsSet("auth.user") {
+		v.User = h.User
+	}
+
+	if !configflags.IsSet("proxy.identity-files") && !configflags.IsSet("auth.identity-files") {
+		v.Keys = h.IdentityFiles
+	}
+
+	return v, nil
+}
+
+// getInventory loads the inventory file once, it returns nil if there is no
+// inventory file.
+func (t *Task) getInventory() (*inventory.Inventory, error) {
+	if t.inventory != nil || t.configFlags.Hosts.Inventory == "" {
+		return t.inventory, nil
+	}
+
+	inv, err := LoadInventory(t.configFlags)
+	if err != nil {
+		return nil, err
+	}
+	t.inventory = inv
+
+	return inv, nil
+}
+
+// LoadInventory loads the inventory of hosts.inventory, vault ciphertexts
+// in built-in variables are decrypted by Inventory.Decrypt unless options
+// override it.
+func LoadInventory(
+	configFlags *configflags.ConfigFlags,
+	options ...func(*inventory.Inventory),
+) (*inventory.Inventory, error) {
+	// built-in variables may be vault ciphertexts, e.g. in group_vars files.
+	options = append([]func(*inventory.Inventory){
+		inventory.WithDecrypt(func(alias, name, value string) (string, error) {
+			assignRealPass(&value, alias, name)
+			return value, nil
+		}),
+	}, options...)
+
+	file := configFlags.Hosts.Inventory
+	if ok, _ := util.IsExecutable(file); ok {
+		return inventory.LoadExecutable(
+			file,
+			inventoryCacheFile(file),
+			time.Duration(configFlags.Hosts.CacheTTL)*time.Second,
+			options...,
+		)
+	}
+
+	return inventory.Load(file, options...)
+}
+
+// inventoryCacheFile returns the cache file of executable inventory file, it
+// is named by the hash of the absolute path of the file.
+func inventoryCacheFile(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
 
-	if err := inventory.Parse(t.configFlags.Hosts.Inventory); err != nil {
+	sum := sha256.Sum256([]byte(file))
+
+	return filepath.Join(os.Getenv("HOME"), ".gossh", "inventory-cache", hex.EncodeToString(sum[:8])+".yaml")
+}
+
+// getInventoryHosts selects hosts from the inventory by positional arguments,
+// all hosts are selected if there are no arguments.
+func (t *Task) getInventoryHosts() ([]*inventory.Host, error) {
+	inv, err := t.getInventory()
+	if err != nil {
 		return nil, err
 	}
 
 	if len(t.argHosts) == 0 {
-		fileHosts = inventory.GetAllHosts()
-	} else {
-		for _, v := range t.argHosts {
-			hostList, err := expandhost.PatternToHosts(v)
-			if err != nil {
-				return nil, fmt.Errorf("invalid host pattern: %s", err)
-			}
+		return inv.Hosts(), nil
+	}
+
+	hosts, err := inv.Select(t.argHosts...)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Host Info: %d hosts are selected by '%s'", len(hosts), strings.Join(t.argHosts, " "))
+
+	return hosts, nil
+}
+
+func (t *Task) buildSSHClient() error {
+	hostKeyChecker := batchssh.NewHostKeyChecker(
+		t.configFlags.Hosts.HostKeyChecking,
+		append([]string{batchssh.DefaultKnownHostsFile()}, t.configFlags.Hosts.KnownHostsFiles...)...,
+	)
+
+	options := []func(*batchssh.Client){
+		batchssh.WithConnTimeout(time.Duration(t.configFlags.Timeout.Conn) * time.Second),
+		batchssh.WithCommandTimeout(time.Duration(t.configFlags.Timeout.Command) * time.Second),
+		batchssh.WithConcurrency(t.configFlags.Run.Concurrency),
+		batchssh.WithNoPTY(t.configFlags.Run.NoPTY),
+		batchssh.WithTransferMethod(t.transferMethod),
+		batchssh.WithHostKeyChecker(hostKeyChecker),
+		batchssh.WithSerial(t.configFlags.Run.Serial),
+		batchssh.WithMaxFailPercentage(t.configFlags.Run.MaxFailPercentage),
+		batchssh.WithAnyErrorsFatal(t.configFlags.Run.AnyErrorsFatal),
+		batchssh.WithRetries(t.configFlags.Run.Retries, time.Second),
+		batchssh.WithMaxOpenConns(t.configFlags.Run.MaxOpenConns),
+		batchssh.WithIdleTimeout(time.Duration(t.configFlags.Run.IdleTimeout) * time.Second),
+	}
 
-			for _, host := range hostList {
-				_fileHosts := inventory.GetHostsByGroup(host)
-				if _fileHosts == nil {
-					_host := inventory.GetHostByAlias(host)
-					if _host != nil {
-						_fileHosts = []*inventory.Host{_host}
-					} else {
-						_fileHosts = []*inventory.Host{
-							{
-								Alias: host,
-								Host:  host,
-							},
-						}
-					}
+	if t.configFlags.Run.BatchPause > 0 || t.configFlags.Run.BatchConfirm {
+		options = append(options, batchssh.WithBeforeBatch(t.beforeBatch))
+	}
+
+	if t.configFlags.Run.ControlPersist > 0 {
+		executable, err := os.Executable()
+		if err != nil {
+			log.Debugf("Control Master: get executable failed: %s, control persist disabled", err)
+		} else {
+			options = append(options, batchssh.WithControlPersist(
+				t.configFlags.Run.ControlDir,
+				time.Duration(t.configFlags.Run.ControlPersist)*time.Second,
+				// Keep stdout of the master process clean, it is used for
+				// forwarding authentication requests.
+				executable, "control-master", "--output.quiet", "--output.file=", "--output.verbose=false",
+			))
+		}
+	}
+
+	if t.configFlags.Output.Stream {
+		options = append(options, batchssh.WithStreamHandler(t.streamOutput))
+	}
+
+	if t.configFlags.Proxy.Server != "" {
+		proxies, err := t.getProxyHops(t.configFlags.Proxy.Server)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", "proxy.server", err)
+		}
+
+		options = append(options, batchssh.WithProxies(proxies...))
+	}
+
+	t.sshClient = batchssh.NewClient(options...)
+
+	return nil
+}
+
+// beforeBatch pauses or asks for confirmation before a batch of run.serial.
+// If ctx is done, the batch is run and hosts are cancelled by batchssh.
+func (t *Task) beforeBatch(ctx context.Context, batch, batches int, hosts []*batchssh.Host) bool {
+	// Results of previous batches are output before pausing.
+	sizes, _ := batchssh.BatchSizes(t.configFlags.Run.Serial, t.hostsCount)
+	finished := 0
+	for i := 0; i < batch-1 && i < len(sizes); i++ {
+		finished += sizes[i]
+	}
+
+	t.outputCond.L.Lock()
+	for t.outputCount < finished {
+		t.outputCond.Wait()
+	}
+	t.outputCond.L.Unlock()
+
+	if pause := t.configFlags.Run.BatchPause; pause > 0 {
+		log.Infof("pause %d seconds before batch %d/%d", pause, batch, batches)
+
+		select {
+		case <-ctx.Done():
+			return true
+		case <-time.After(time.Duration(pause) * time.Second):
+		}
+	}
+
+	if !t.configFlags.Run.BatchConfirm {
+		return true
+	}
+
+	var aliases []string
+	for i, host := range hosts {
+		if i == 5 {
+			aliases = append(aliases, "...")
+			break
+		}
+		aliases = append(aliases, host.Alias)
+	}
+
+	fmt.Fprintf(os.Stderr, "Run batch %d/%d of %d hosts (%s)? [y/N]: ",
+		batch, batches, len(hosts), strings.Join(aliases, ", "))
+
+	if t.stdin == nil {
+		t.stdin = bufio.NewReader(os.Stdin)
+	}
+
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := t.stdin.ReadString('\n')
+		answer <- strings.ToLower(strings.TrimSpace(line))
+	}()
+
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr)
+		return true
+	case line := <-answer:
+		return line == "y" || line == "yes"
+	}
+}
+
+// getProxyHops parses jump hosts like '[user@]host[:port],[user@]host[:port]'.
+// A jump host that is an alias in inventory uses the host, port, user and
+// individual auth of the inventory host, or of the host in ssh_config if
+// hosts.use-ssh-config is on, otherwise it uses the proxy flags.
+func (t *Task) getProxyHops(spec string) ([]*batchssh.ProxyHop, error) {
+	if hops, ok := t.proxyHops[spec]; ok {
+		return hops, nil
+	}
+
+	hops, err := batchssh.ParseProxyJump(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.proxyAuths == nil {
+		t.proxyAuths = t.getProxySSHAuthMethods()
+	}
+
+	inv, err := t.getInventory()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hop := range hops {
+		hop.SSHAuths = t.proxyAuths
+
+		var v *inventory.Host
+		if inv != nil {
+			if v = inv.HostByAlias(hop.Host); v != nil {
+				if v, err = inv.Decrypt(v); err != nil {
+					return nil, err
 				}
+			}
+		}
 
-				fileHosts = append(fileHosts, _fileHosts...)
+		if v == nil {
+			if v, err = t.sshConfigProxyHost(hop.Host); err != nil {
+				return nil, err
 			}
 		}
+
+		if v != nil {
+			auths, _ := getIndividualSSHAuthMethods(v)
+			hop.SSHAuths = append(auths, t.proxyAuths...)
+			hop.Host = v.Host
+
+			if hop.User == "" {
+				hop.User = v.User
+			}
+			if hop.Port == 0 {
+				hop.Port = v.Port
+			}
+		}
+
+		if hop.User == "" {
+			hop.User = t.configFlags.Proxy.User
+		}
+		if hop.User == "" {
+			hop.User = t.defaultUser
+		}
+		if hop.Port == 0 {
+			hop.Port = t.configFlags.Proxy.Port
+		}
+	}
+
+	if t.proxyHops == nil {
+		t.proxyHops = make(map[string][]*batchssh.ProxyHop)
 	}
+	t.proxyHops[spec] = hops
 
-	return fileHosts, nil
+	return hops, nil
 }
 
-func (t *Task) buildSSHClient() {
-	var sshClient *batchssh.Client
+// getIndividualSSHAuthMethods returns auth methods of the identity files and
+// password of inventory host.
+func getIndividualSSHAuthMethods(v *inventory.Host) (auths []ssh.AuthMethod, signers []ssh.Signer) {
+	if len(v.Keys) != 0 {
+		keys := parseItentityFiles(v.Keys)
+		assignRealPass(&v.Passphrase, v.Alias, "passphrase")
+		sshSigners := getSigners(keys, v.Passphrase, "Individual")
+		if len(sshSigners) == 0 {
+			log.Debugf("Individual Auth: no valid individual identity files for '%s'", v.Alias)
+		} else {
+			auths = append(auths, ssh.PublicKeys(sshSigners...))
+			signers = append(signers, sshSigners...)
+			log.Debugf("Individual Auth: add individual pubkey auth for '%s'", v.Alias)
+		}
+	}
 
-	if t.configFlags.Proxy.Server != "" {
-		proxyAuths := t.getProxySSHAuthMethods()
-
-		sshClient = batchssh.NewClient(
-			batchssh.WithConnTimeout(time.Duration(t.configFlags.Timeout.Conn)*time.Second),
-			batchssh.WithCommandTimeout(time.Duration(t.configFlags.Timeout.Command)*time.Second),
-			batchssh.WithConcurrency(t.configFlags.Run.Concurrency),
-			batchssh.WithProxyServer(
-				t.configFlags.Proxy.Server,
-				t.configFlags.Proxy.User,
-				t.configFlags.Proxy.Port,
-				proxyAuths,
-			),
-		)
-	} else {
-		sshClient = batchssh.NewClient(
-			batchssh.WithConnTimeout(time.Duration(t.configFlags.Timeout.Conn)*time.Second),
-			batchssh.WithCommandTimeout(time.Duration(t.configFlags.Timeout.Command)*time.Second),
-			batchssh.WithConcurrency(t.configFlags.Run.Concurrency),
-		)
+	if v.Password != "" {
+		assignRealPass(&v.Password, v.Alias, "password")
+		auths = append(auths, ssh.Password(v.Password))
+		log.Debugf("Individual Auth: add individual password for '%s'", v.Alias)
 	}
 
-	t.sshClient = sshClient
+	return
 }
 
 func (t *Task) setDefaultSSHAuthMethods() {
@@ -629,6 +1185,7 @@ func (t *Task) setDefaultSSHAuthMethods() {
 	if len(signers) != 0 {
 		auths = append(auths, ssh.PublicKeys(signers...))
 	}
+	t.defaultSigners = signers
 
 	if *t.defaultPass != "" {
 		auths = append(auths, ssh.Password(*t.defaultPass))
@@ -669,9 +1226,15 @@ func (t *Task) getProxySSHAuthMethods() []ssh.AuthMethod {
 		}
 	}
 
+	// Proxy flags are not completed if proxies are only from inventory.
 	proxyKeyfiles := parseItentityFiles(t.configFlags.Proxy.IdentityFiles)
+	proxyPassphrase := t.configFlags.Proxy.Passphrase
+	if len(proxyKeyfiles) == 0 {
+		proxyKeyfiles = t.defaultIdentityFiles
+		proxyPassphrase = t.configFlags.Auth.Passphrase
+	}
 	if len(proxyKeyfiles) != 0 {
-		sshSigners := getSigners(proxyKeyfiles, t.configFlags.Proxy.Passphrase, "Proxy")
+		sshSigners := getSigners(proxyKeyfiles, proxyPassphrase, "Proxy")
 
 		if len(sshSigners) != 0 {
 			signers = append(signers, sshSigners...)
@@ -810,44 +1373,16 @@ func assignRealPass(pass *string, host, objectType string) {
 	var err error
 
 	if aes.IsAES256CipherText(*pass) {
-		vaultPass := vault.GetVaultPassword()
+		if aes.IsLegacyCipherText(*pass) {
+			log.Debugf("Vault: %s for '%s' is in legacy format, upgrade it by 'gossh vault upgrade'", objectType, host)
+		}
 
-		*pass, err = aes.AES256Decode(*pass, vaultPass)
+		*pass, err = vault.Decrypt(*pass)
 		if err != nil {
 			log.Debugf("Vault: decrypt %s for '%s' failed: %s", objectType, host, err)
-			util.CheckErr(err)
+			util.CheckErr(fmt.Errorf("decrypt %s for '%s' failed: %w", objectType, host, err))
 		}
 
 		log.Debugf("Vault: decrypt %s for '%s' success", objectType, host)
 	}
 }
-
-func deDuplicate(hosts []string) []string {
-	var set []string
-
-	keys := make(map[string]bool)
-
-	for _, v := range hosts {
-		if !keys[v] {
-			set = append(set, v)
-			keys[v] = true
-		}
-	}
-
-	return set
-}
-
-func deDuplSSHHosts(hosts []*batchssh.Host) []*batchssh.Host {
-	var set []*batchssh.Host
-
-	keys := make(map[string]bool)
-
-	for _, v := range hosts {
-		if !keys[v.Alias] {
-			set = append(set, v)
-			keys[v.Alias] = true
-		}
-	}
-
-	return set
-}
@@ -0,0 +1,128 @@
+package sshtask
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/serialt/gosible/internal/pkg/configflags"
+	"github.com/serialt/gosible/pkg/inventory"
+)
+
+func TestApplySSHConfigWithConfigFile(t *testing.T) {
+	sshConfigFile := filepath.Join(t.TempDir(), "config")
+	content := `
+Host web1 jump1
+    HostName 10.0.0.1
+    Port 2222
+    User deploy
+`
+	if err := os.WriteFile(sshConfigFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config file generated by 'gossh config' holds auth.user and
+	// proxy.user with empty values, hosts.port and proxy.port are commented
+	// out.
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.SetConfigFile(filepath.Join("..", "..", "..", "configs", "gossh.yaml"))
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	configflags.New().AddFlagsTo(fs)
+	if err := viper.BindPFlags(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	cf := configflags.New()
+	if err := viper.Unmarshal(cf); err != nil {
+		t.Fatal(err)
+	}
+	cf.Hosts.UseSSHConfig = true
+	cf.Hosts.SSHConfig = sshConfigFile
+
+	task := &Task{configFlags: cf}
+
+	host := &inventory.Host{Alias: "web1", Host: "web1"}
+	if err := task.applySSHConfig(host); err != nil {
+		t.Fatal(err)
+	}
+
+	if host.Host != "10.0.0.1" || host.Port != 2222 || host.User != "deploy" {
+		t.Errorf("applySSHConfig() = %s@%s:%d, want deploy@10.0.0.1:2222", host.User, host.Host, host.Port)
+	}
+
+	proxy, err := task.sshConfigProxyHost("jump1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if proxy.Port != 2222 || proxy.User != "deploy" {
+		t.Errorf("sshConfigProxyHost() = %s@%s:%d, want deploy@10.0.0.1:2222", proxy.User, proxy.Host, proxy.Port)
+	}
+
+	// Values set explicitly in config file override ssh_config, even if they
+	// are the same as the default values.
+	explicitConfig := filepath.Join(t.TempDir(), "gossh.yaml")
+	content = `
+auth:
+  user: root
+hosts:
+  port: 22
+proxy:
+  port: 22
+`
+	if err := os.WriteFile(explicitConfig, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	viper.SetConfigFile(explicitConfig)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	host = &inventory.Host{Alias: "web1", Host: "web1"}
+	if err := task.applySSHConfig(host); err != nil {
+		t.Fatal(err)
+	}
+
+	if host.Port != 0 || host.User != "" {
+		t.Errorf("applySSHConfig() = %s@%s:%d, want port and user of config file", host.User, host.Host, host.Port)
+	}
+
+	proxy, err = task.sshConfigProxyHost("jump1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if proxy.Port != 0 || proxy.User != "" {
+		t.Errorf("sshConfigProxyHost() = %s@%s:%d, want port and user of config file", proxy.User, proxy.Host, proxy.Port)
+	}
+
+	// Flags changed by command line override ssh_config, even if they are
+	// the same as the default values.
+	viper.SetConfigFile(filepath.Join("..", "..", "..", "configs", "gossh.yaml"))
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Parse([]string{"--hosts.port", "22", "--auth.user", "root"}); err != nil {
+		t.Fatal(err)
+	}
+
+	host = &inventory.Host{Alias: "web1", Host: "web1"}
+	if err := task.applySSHConfig(host); err != nil {
+		t.Fatal(err)
+	}
+
+	if host.Port != 0 || host.User != "" {
+		t.Errorf("applySSHConfig() = %s@%s:%d, want port and user of flags", host.User, host.Host, host.Port)
+	}
+}
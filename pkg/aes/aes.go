@@ -64,11 +64,17 @@ func Encode(plainText, key []byte, keyLen int) ([]byte, error) {
 }
 
 // Decode cipher text.
+// ErrDecrypt is returned if the padding is invalid, which means the key is
+// wrong or the data is corrupted.
 func Decode(cipherText, key []byte, keyLen int) (string, error) {
 	if keyLen != 16 && keyLen != 24 && keyLen != 32 {
 		return "", errors.New("invalid key length, available length: 16, 24, 32")
 	}
 
+	if len(cipherText) < 2*aes.BlockSize || len(cipherText)%aes.BlockSize != 0 {
+		return "", ErrDecrypt
+	}
+
 	key = buildKey(key, keyLen)
 
 	iv := cipherText[:aes.BlockSize]
@@ -83,7 +89,10 @@ func Decode(cipherText, key []byte, keyLen int) (string, error) {
 	plainTextBytes := make([]byte, len(cipherText))
 	mode.CryptBlocks(plainTextBytes, cipherText)
 
-	plainTextBytes = pkcs7UnPadding(plainTextBytes)
+	plainTextBytes, err = pkcs7UnPadding(plainTextBytes)
+	if err != nil {
+		return "", err
+	}
 
 	return string(plainTextBytes[aes.BlockSize:]), nil
 }
@@ -95,11 +104,23 @@ func pkcs7Padding(ciphertext []byte) []byte {
 	return append(ciphertext, padtext...)
 }
 
-func pkcs7UnPadding(plainText []byte) []byte {
+// pkcs7UnPadding removes the padding, a wrong key almost always results
+// in an invalid padding as there is no MAC in CBC mode.
+func pkcs7UnPadding(plainText []byte) ([]byte, error) {
 	length := len(plainText)
 	unpadding := int(plainText[length-1])
 
-	return plainText[:(length - unpadding)]
+	if unpadding == 0 || unpadding > aes.BlockSize {
+		return nil, ErrDecrypt
+	}
+
+	for _, b := range plainText[length-unpadding:] {
+		if int(b) != unpadding {
+			return nil, ErrDecrypt
+		}
+	}
+
+	return plainText[:(length - unpadding)], nil
 }
 
 func buildKey(originKey []byte, keyLen int) []byte {
@@ -0,0 +1,242 @@
+package aes
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+func TestGCM(t *testing.T) {
+	plainText := []byte("the sensitive content")
+	password := []byte("vault password")
+
+	cipherText, err := EncodeGCM(plainText, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeGCM(cipherText, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plainText) {
+		t.Errorf("DecodeGCM() = %q, want %q", got, plainText)
+	}
+
+	again, err := EncodeGCM(plainText, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again, cipherText) {
+		t.Error("EncodeGCM() returned the same cipher text twice")
+	}
+
+	if _, err := DecodeGCM(cipherText, []byte("wrong password")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("DecodeGCM() with wrong password error = %v, want %v", err, ErrDecrypt)
+	}
+
+	tampered := append([]byte{}, cipherText...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := DecodeGCM(tampered, password); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("DecodeGCM() with tampered data error = %v, want %v", err, ErrDecrypt)
+	}
+
+	if _, err := DecodeGCM(cipherText[:20], password); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("DecodeGCM() with truncated data error = %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	plainText := "the sensitive content"
+
+	cipherText, err := Encode([]byte(plainText), []byte("vault password"), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Decode(cipherText, []byte("vault password"), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != plainText {
+		t.Errorf("Decode() = %q, want %q", got, plainText)
+	}
+
+	if _, err := Decode(cipherText[:10], []byte("vault password"), 32); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Decode() with truncated data error = %v, want %v", err, ErrDecrypt)
+	}
+
+	// A wrong key must not panic, the padding check fails in most cases.
+	failed := 0
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if _, err := Decode(cipherText, []byte(key), 32); err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		t.Error("Decode() with wrong keys never failed")
+	}
+}
+
+func TestDeriveKeyCache(t *testing.T) {
+	ClearKeyCache()
+	defer ClearKeyCache()
+
+	plainText := []byte("hello")
+	cipherText, err := EncodeGCM(plainText, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same cipher text is decrypted concurrently, with right and wrong
+	// passwords.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			password, wantErr := []byte("password"), error(nil)
+			if i%2 == 1 {
+				password, wantErr = []byte("wrong password"), ErrDecrypt
+			}
+
+			got, err := DecodeGCM(cipherText, password)
+			if !errors.Is(err, wantErr) {
+				t.Errorf("DecodeGCM() error = %v, want %v", err, wantErr)
+				return
+			}
+			if wantErr == nil && string(got) != string(plainText) {
+				t.Errorf("DecodeGCM() = %q, want %q", got, plainText)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	keyCacheMu.Lock()
+	// the encryption and the right password share the key.
+	if len(keyCache) != 2 {
+		t.Errorf("cached keys = %d, want 2", len(keyCache))
+	}
+	for k := range keyCache {
+		if strings.Contains(k, "password") {
+			t.Errorf("password is in cache key %q", k)
+		}
+	}
+	keyCacheMu.Unlock()
+
+	ClearKeyCache()
+	if len(keyCache) != 0 {
+		t.Errorf("cached keys = %d after clearing, want 0", len(keyCache))
+	}
+}
+
+func TestClearKeyCacheKeepsKeysInUse(t *testing.T) {
+	ClearKeyCache()
+	defer ClearKeyCache()
+
+	salt := make([]byte, saltLen)
+
+	key, err := deriveKey([]byte("password"), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte(nil), key...)
+
+	// The cached key is zeroed, the key returned before is not.
+	ClearKeyCache()
+	if !bytes.Equal(key, want) {
+		t.Fatal("key in use is changed by ClearKeyCache()")
+	}
+
+	again, err := deriveKey([]byte("password"), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, want) {
+		t.Error("key derived after ClearKeyCache() differs")
+	}
+}
+
+func TestClearKeyCacheConcurrently(t *testing.T) {
+	ClearKeyCache()
+	defer ClearKeyCache()
+
+	password, salt := []byte("password"), make([]byte, saltLen)
+
+	want, err := scrypt.Key(password, salt, scryptN, scryptR, scryptP, gcmKeyLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := deriveKey(password, salt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiters of a key being derived.
+	keyCacheMu.Lock()
+	entry := &keyCacheEntry{done: make(chan struct{})}
+	keyCache[keyCacheKeyLocked(password, salt)] = entry
+	keyCacheMu.Unlock()
+
+	var wg sync.WaitGroup
+	keys := make([][]byte, 8)
+	errs := make([]error, len(keys))
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keys[i], errs[i] = deriveKey(password, salt)
+		}(i)
+	}
+
+	// Let waiters find the entry, and the key is derived and cleared while
+	// they copy it.
+	time.Sleep(100 * time.Millisecond)
+
+	keyCacheMu.Lock()
+	entry.key = append([]byte(nil), want...)
+	close(entry.done)
+	keyCacheMu.Unlock()
+
+	for i := 0; i < 100; i++ {
+		ClearKeyCache()
+	}
+	wg.Wait()
+
+	for i := range keys {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if !bytes.Equal(keys[i], want) {
+			t.Errorf("deriveKey() = %x, want %x", keys[i], want)
+		}
+	}
+}
+
+func TestDeriveKeyCacheLimit(t *testing.T) {
+	ClearKeyCache()
+	defer ClearKeyCache()
+
+	keyCacheMu.Lock()
+	for i := 0; i < maxKeyCacheEntries; i++ {
+		entry := &keyCacheEntry{done: make(chan struct{}), key: make([]byte, gcmKeyLen)}
+		close(entry.done)
+		keyCache[strconv.Itoa(i)] = entry
+	}
+	keyCacheMu.Unlock()
+
+	if _, err := deriveKey([]byte("password"), make([]byte, saltLen)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keyCache) != maxKeyCacheEntries {
+		t.Errorf("cached keys = %d, want %d", len(keyCache), maxKeyCacheEntries)
+	}
+}
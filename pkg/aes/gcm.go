@@ -0,0 +1,252 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package aes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// saltLen is the length of the random salt for deriving the key.
+	saltLen = 16
+
+	// scrypt parameters recommended for interactive logins.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+
+	gcmKeyLen = 32
+)
+
+// ErrDecrypt is returned when the authentication of the cipher text failed,
+// which means the password is wrong or the data is corrupted.
+var ErrDecrypt = errors.New("wrong password or corrupted data")
+
+// maxKeyCacheEntries limits the number of cached keys.
+const maxKeyCacheEntries = 256
+
+var (
+	keyCacheMu sync.Mutex
+	// keyCache caches derived keys, deriving a key by scrypt is slow and the
+	// same cipher text is often decrypted many times, e.g. a vault value in
+	// group vars is decrypted for each host of the group. Entries are keyed
+	// by the salt and the HMAC of the password by keyCacheSecret, so that no
+	// fast hash of the password is kept in memory.
+	keyCache = make(map[string]*keyCacheEntry)
+	// keyCacheSecret is random for each process.
+	keyCacheSecret []byte
+)
+
+// keyCacheEntry is a key being derived or derived, done is closed once key
+// or err is set. key and err are set and read with keyCacheMu held, key is
+// nil once it is cleared by ClearKeyCache.
+type keyCacheEntry struct {
+	done chan struct{}
+	key  []byte
+	err  error
+}
+
+// EncodeGCM encrypts plain text by AES-256-GCM with a key derived from the
+// password by scrypt with a random salt.
+// The result is salt + nonce + sealed text.
+func EncodeGCM(plainText, password []byte) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	cipherText := make([]byte, 0, saltLen+len(nonce)+len(plainText)+gcm.Overhead())
+	cipherText = append(cipherText, salt...)
+	cipherText = append(cipherText, nonce...)
+
+	return gcm.Seal(cipherText, nonce, plainText, nil), nil
+}
+
+// DecodeGCM decrypts cipher text encrypted by EncodeGCM,
+// ErrDecrypt is returned if the password is wrong or the data is corrupted.
+func DecodeGCM(cipherText, password []byte) ([]byte, error) {
+	if len(cipherText) < saltLen {
+		return nil, ErrDecrypt
+	}
+
+	gcm, err := newGCM(password, cipherText[:saltLen])
+	if err != nil {
+		return nil, err
+	}
+
+	cipherText = cipherText[saltLen:]
+	if len(cipherText) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrDecrypt
+	}
+
+	nonce := cipherText[:gcm.NonceSize()]
+
+	plainText, err := gcm.Open(nil, nonce, cipherText[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plainText, nil
+}
+
+func newGCM(password, salt []byte) (cipher.AEAD, error) {
+	key, err := deriveKey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	for i := range key {
+		key[i] = 0
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func deriveKey(password, salt []byte) ([]byte, error) {
+	keyCacheMu.Lock()
+
+	if keyCacheSecret == nil {
+		keyCacheSecret = make([]byte, sha256.Size)
+		if _, err := io.ReadFull(rand.Reader, keyCacheSecret); err != nil {
+			keyCacheSecret = nil
+			keyCacheMu.Unlock()
+			return nil, err
+		}
+	}
+
+	cacheKey := keyCacheKeyLocked(password, salt)
+
+	// Keys of the same password and salt are derived once, other keys are
+	// derived concurrently.
+	if entry, ok := keyCache[cacheKey]; ok {
+		keyCacheMu.Unlock()
+		<-entry.done
+
+		// The key is copied with the lock held, ClearKeyCache may zero it.
+		keyCacheMu.Lock()
+		key, err := copyKey(entry.key, entry.err)
+		keyCacheMu.Unlock()
+
+		// The key is cleared before it is copied, derive it again.
+		if err == nil && key == nil {
+			return deriveKey(password, salt)
+		}
+
+		return key, err
+	}
+
+	if len(keyCache) >= maxKeyCacheEntries {
+		evictKeyCacheLocked()
+	}
+
+	entry := &keyCacheEntry{done: make(chan struct{})}
+	keyCache[cacheKey] = entry
+	keyCacheMu.Unlock()
+
+	key, err := scrypt.Key(password, salt, scryptN, scryptR, scryptP, gcmKeyLen)
+
+	keyCacheMu.Lock()
+	defer keyCacheMu.Unlock()
+
+	entry.key, entry.err = key, err
+	close(entry.done)
+
+	if err != nil && keyCache[cacheKey] == entry {
+		delete(keyCache, cacheKey)
+	}
+
+	return copyKey(entry.key, entry.err)
+}
+
+// keyCacheKeyLocked returns the key of keyCache for the password and salt,
+// keyCacheMu must be held.
+func keyCacheKeyLocked(password, salt []byte) string {
+	mac := hmac.New(sha256.New, keyCacheSecret)
+	_, _ = mac.Write(password)
+
+	return string(salt) + string(mac.Sum(nil))
+}
+
+// copyKey returns a copy of the cached key, so that ClearKeyCache can zero
+// the cached key while it is still used by others. keyCacheMu must be held.
+func copyKey(key []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), key...), nil
+}
+
+// evictKeyCacheLocked removes a derived key from the cache.
+func evictKeyCacheLocked() {
+	for k, entry := range keyCache {
+		select {
+		case <-entry.done:
+			delete(keyCache, k)
+			return
+		default:
+		}
+	}
+}
+
+// ClearKeyCache zeroes and forgets all derived keys, it should be called
+// once cipher texts are no longer decrypted or encrypted by the process.
+func ClearKeyCache() {
+	keyCacheMu.Lock()
+	defer keyCacheMu.Unlock()
+
+	for k, entry := range keyCache {
+		select {
+		case <-entry.done:
+			for i := range entry.key {
+				entry.key[i] = 0
+			}
+			entry.key = nil
+		default:
+		}
+		delete(keyCache, k)
+	}
+}
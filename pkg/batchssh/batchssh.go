@@ -23,6 +23,8 @@ THE SOFTWARE.
 package batchssh
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -31,30 +33,75 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/serialt/gosible/pkg/log"
-	"github.com/serialt/gosible/pkg/util"
 )
 
 const (
 	exportLangPattern = "export LANG=%s;export LC_ALL=%s;export LANGUAGE=%s;"
 
+	// etx is the interrupt character (Ctrl-C) of terminals.
+	etx = 0x03
+
 	// SuccessIdentifier for result output.
 	SuccessIdentifier = "SUCCESS"
 	// FailedIdentifier for result output.
 	FailedIdentifier = "FAILED"
+	// CancelledIdentifier for result output of hosts not finished when
+	// the batch run is cancelled.
+	CancelledIdentifier = "CANCELLED"
+	// TimeoutIdentifier for result output of hosts not finished in time.
+	TimeoutIdentifier = "TIMEOUT"
+	// SkippedIdentifier for result output of hosts not run because too many
+	// hosts failed in previous batches.
+	SkippedIdentifier = "SKIPPED"
 )
 
+// sudoPromptRegex matches password prompt of 'sudo -S' at the beginning of stderr.
+var sudoPromptRegex = regexp.MustCompile(`^\[sudo\] [^\n]*?[:：] ?`)
+
 // Task execute command or copy file or execute script.
+// RunSSH should return as soon as possible when ctx is done.
+// If the command executed failed, both output and error are returned.
 type Task interface {
-	RunSSH(host *Host) (string, error)
+	RunSSH(ctx context.Context, host *Host) (*Output, error)
+}
+
+// Output of command executed on remote host.
+type Output struct {
+	// Stdout is the whole output if executed with pty.
+	Stdout string
+	Stderr string
+	// ExitCode is -1 if the command did not exit normally.
+	ExitCode int
+	// Signal killed the command, e.g. TERM, KILL.
+	Signal string
+}
+
+// CommandError is returned if command exited with non-zero status or was
+// killed by a signal.
+type CommandError struct {
+	Output *Output
+}
+
+func (e *CommandError) Error() string {
+	msg := e.Output.Stdout + e.Output.Stderr
+	if strings.TrimSpace(msg) != "" {
+		return msg
+	}
+
+	if e.Output.Signal != "" {
+		return fmt.Sprintf("command killed by signal %s", e.Output.Signal)
+	}
+
+	return fmt.Sprintf("command exited with status %d", e.Output.ExitCode)
 }
 
 // Result of ssh command.
@@ -62,6 +109,15 @@ type Result struct {
 	Host    string `json:"host"`
 	Status  string `json:"status"`
 	Message string `json:"message"`
+
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	Signal   string `json:"signal"`
+
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	Duration  time.Duration `json:"duration"`
 }
 
 // Client for ssh.
@@ -69,13 +125,48 @@ type Client struct {
 	ConnTimeout    time.Duration
 	CommandTimeout time.Duration
 	Concurrency    int
-	Proxy          *Proxy
-}
-
-// Proxy server.
-type Proxy struct {
-	SSHClient *ssh.Client
-	Err       error
+	Proxies        []*ProxyHop
+	HostKeyChecker *HostKeyChecker
+
+	// StreamHandler receives output lines of commands and scripts as soon as
+	// they arrive if it is not nil.
+	StreamHandler StreamHandler
+
+	// Serial sizes of batches that hosts are run in sequentially, like "1",
+	// "10%". All hosts are run in one batch if it is empty.
+	Serial []string
+	// MaxFailPercentage stops running subsequent batches if more than the
+	// percentage of hosts failed in a batch. It is 100 by NewClient, 0 stops
+	// running subsequent batches once any host failed.
+	MaxFailPercentage int
+	// AnyErrorsFatal stops running any other hosts once a host failed.
+	AnyErrorsFatal bool
+	// Retries of hosts failed to connect, the delay before each retry starts
+	// from RetryBackoff and doubles.
+	Retries      int
+	RetryBackoff time.Duration
+	// BeforeBatch is called before each batch except the first one,
+	// remaining hosts are skipped if it returns false.
+	BeforeBatch func(ctx context.Context, batch, batches int, hosts []*Host) bool
+
+	// TransferMethod of pushing files, TransferAuto by default.
+	TransferMethod string
+
+	// NoPTY executes commands without pty, so that stdout and stderr are
+	// separated, and sudo reads password from stdin.
+	NoPTY bool
+
+	// Connections are cached and shared by operations on the same host.
+	MaxOpenConns int
+	IdleTimeout  time.Duration
+
+	// Connections are shared between processes by control sockets in
+	// ControlDir if ControlPersist is greater than 0.
+	ControlDir       string
+	ControlPersist   time.Duration
+	ControlMasterCmd []string
+
+	pool *connPool
 }
 
 // Host target host.
@@ -88,6 +179,16 @@ type Host struct {
 	Keys       []string
 	Passphrase string
 	SSHAuths   []ssh.AuthMethod
+
+	// Signers for pubkey authentication of control master processes.
+	Signers []ssh.Signer
+
+	// Proxies are the jump hosts of the host, it overrides Client.Proxies
+	// if it is not nil, an empty slice means connecting directly.
+	Proxies []*ProxyHop
+
+	// Vars are user variables of the host from inventory.
+	Vars map[string]interface{}
 }
 
 // NewClient session.
@@ -96,93 +197,195 @@ func NewClient(options ...func(*Client)) *Client {
 		ConnTimeout:    10 * time.Second,
 		CommandTimeout: 0,
 		Concurrency:    100,
-		Proxy:          &Proxy{},
+		HostKeyChecker: NewHostKeyChecker(HostKeyCheckingAcceptNew),
+
+		MaxFailPercentage: 100,
+		RetryBackoff:      time.Second,
 	}
 
 	for _, option := range options {
 		option(&client)
 	}
 
+	client.pool = newConnPool(client.MaxOpenConns, client.IdleTimeout)
+
 	return &client
 }
 
-// BatchRun command on remote servers.
+// Close cached connections. Connections in use are closed once released.
+func (c *Client) Close() {
+	c.pool.close()
+}
+
+// BatchRun command on remote servers. If c.Serial is invalid, no host is
+// run and each host gets result of FailedIdentifier with the error, use
+// BatchRunContext to get the error instead.
 func (c *Client) BatchRun(
 	hosts []*Host,
 	sshTask Task,
 ) <-chan *Result {
-	hostCh := make(chan *Host)
+	resCh, err := c.BatchRunContext(context.Background(), hosts, sshTask)
+	if err == nil {
+		return resCh
+	}
+
+	failedCh := make(chan *Result)
 	go func() {
-		defer close(hostCh)
+		defer close(failedCh)
+
 		for _, host := range hosts {
-			hostCh <- host
+			now := time.Now()
+			failedCh <- &Result{
+				Host:      host.Alias,
+				Status:    FailedIdentifier,
+				Message:   err.Error(),
+				ExitCode:  -1,
+				StartTime: now,
+				EndTime:   now,
+			}
 		}
 	}()
 
+	return failedCh
+}
+
+// BatchRunContext runs command on remote servers until ctx is done.
+// Hosts not finished when ctx is done get result of CancelledIdentifier or
+// TimeoutIdentifier, their remote processes are signaled and sessions closed.
+// Hosts are run in sequential batches if c.Serial is set, hosts not run
+// because of failures get result of SkippedIdentifier. It returns error
+// without running any host if c.Serial is invalid.
+func (c *Client) BatchRunContext(
+	ctx context.Context,
+	hosts []*Host,
+	sshTask Task,
+) (<-chan *Result, error) {
+	sizes, err := BatchSizes(c.Serial, len(hosts))
+	if err != nil {
+		return nil, fmt.Errorf("invalid serial: %w", err)
+	}
+
 	resCh := make(chan *Result)
-	var wg sync.WaitGroup
-	wg.Add(c.Concurrency)
-	for i := 0; i < c.Concurrency; i++ {
-		go func(wg *sync.WaitGroup) {
-			for host := range hostCh {
-				var result *Result
-
-				done := make(chan struct{})
-				go func() {
-					defer close(done)
-
-					output, err := sshTask.RunSSH(host)
-					if err != nil {
-						result = &Result{host.Alias, FailedIdentifier, err.Error()}
-					} else {
-						result = &Result{host.Alias, SuccessIdentifier, output}
-					}
-				}()
-
-				if c.CommandTimeout > 0 {
-					select {
-					case <-done:
-					case <-time.After(c.CommandTimeout):
-						result = &Result{
-							host.Host,
-							FailedIdentifier,
-							fmt.Sprintf(
-								"command timeout, timeout value: %d seconds",
-								c.CommandTimeout/time.Second,
-							),
-						}
-					}
-				} else {
-					<-done
-				}
 
-				resCh <- result
-			}
+	go func() {
+		defer close(resCh)
+		c.runBatches(ctx, hosts, sizes, sshTask, resCh)
+	}()
+
+	return resCh, nil
+}
 
-			wg.Done()
-		}(&wg)
+func (c *Client) runTask(ctx context.Context, host *Host, sshTask Task) *Result {
+	result := &Result{
+		Host:      host.Alias,
+		ExitCode:  -1,
+		StartTime: time.Now(),
 	}
+	defer func() {
+		result.EndTime = time.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+	}()
+
+	if ctx.Err() != nil {
+		c.setInterrupted(ctx, result)
+		return result
+	}
+
+	var (
+		output *Output
+		err    error
+	)
+	for attempt := 1; ; attempt++ {
+		var interrupted bool
+		if output, interrupted, err = c.runAttempt(ctx, host, sshTask); interrupted {
+			c.setInterrupted(ctx, result)
+			return result
+		}
+
+		if attempt > c.Retries || !retryable(err) {
+			break
+		}
 
-	go func(wg *sync.WaitGroup) {
-		wg.Wait()
-		close(resCh)
-	}(&wg)
+		delay := c.retryDelay(attempt)
+		log.Debugf("Retry: run '%s' failed: %s, retry %d/%d in %s", host.Alias, err, attempt, c.Retries, delay)
 
-	return resCh
+		select {
+		case <-ctx.Done():
+			c.setInterrupted(ctx, result)
+			return result
+		case <-time.After(delay):
+		}
+	}
+
+	if output != nil {
+		result.Stdout = output.Stdout
+		result.Stderr = output.Stderr
+		result.ExitCode = output.ExitCode
+		result.Signal = output.Signal
+	}
+
+	if err != nil {
+		result.Status = FailedIdentifier
+		result.Message = err.Error()
+	} else {
+		result.Status = SuccessIdentifier
+		result.Message = result.Stdout + result.Stderr
+	}
+
+	return result
+}
+
+// runAttempt runs sshTask on host once, interrupted is true if ctx or the
+// command timeout is done.
+func (c *Client) runAttempt(ctx context.Context, host *Host, sshTask Task) (*Output, bool, error) {
+	hostCtx, cancel := ctx, context.CancelFunc(func() {})
+	if c.CommandTimeout > 0 {
+		hostCtx, cancel = context.WithTimeout(ctx, c.CommandTimeout)
+	}
+	defer cancel()
+
+	output, err := sshTask.RunSSH(hostCtx, host)
+
+	return output, hostCtx.Err() != nil, err
+}
+
+// setInterrupted result of host not finished because ctx or command timeout
+// is done.
+func (c *Client) setInterrupted(ctx context.Context, result *Result) {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.Status = TimeoutIdentifier
+		result.Message = "task timeout"
+	case ctx.Err() != nil:
+		result.Status = CancelledIdentifier
+		result.Message = "cancelled"
+	default:
+		result.Status = TimeoutIdentifier
+		result.Message = fmt.Sprintf(
+			"command timeout, timeout value: %d seconds",
+			c.CommandTimeout/time.Second,
+		)
+	}
 }
 
 // ExecuteCmd on remote host.
-func (c *Client) ExecuteCmd(host *Host, command, lang, runAs string, sudo bool) (string, error) {
-	client, err := c.getClient(host)
+func (c *Client) ExecuteCmd(
+	ctx context.Context,
+	host *Host,
+	command, lang, runAs string,
+	sudo bool,
+) (*Output, error) {
+	client, release, err := c.getClient(ctx, host)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer client.Close()
+	defer release()
 
 	session, err := client.NewSession()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer session.Close()
 
 	exportLang := ""
 	if lang != "" {
@@ -190,40 +393,42 @@ func (c *Client) ExecuteCmd(host *Host, command, lang, runAs string, sudo bool)
 	}
 
 	if sudo {
-		command = fmt.Sprintf("%ssudo -u %s -H bash -c '%s'", exportLang, runAs, command)
+		command = fmt.Sprintf("%s%s -u %s -H bash -c '%s'", exportLang, c.sudo(), runAs, command)
 	} else {
 		command = exportLang + command
 	}
 
-	return c.executeCmd(session, command, host.Password)
+	return c.executeCmd(ctx, session, command, host.Password, host)
 }
 
 // ExecuteScript on remote host.
 func (c *Client) ExecuteScript(
+	ctx context.Context,
 	host *Host,
 	srcFile, dstDir, lang, runAs string,
 	sudo, remove, allowOverwrite bool,
-) (string, error) {
-	client, err := c.getClient(host)
+) (*Output, error) {
+	client, release, err := c.getClient(ctx, host)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer client.Close()
+	defer release()
 
 	ftpC, err := sftp.NewClient(client)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer ftpC.Close()
+	defer closeOnDone(ctx, ftpC)()
 
 	file, err := c.pushFile(ftpC, srcFile, dstDir, allowOverwrite)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	//nolint:gomnd,govet
 	if err := file.Chmod(0755); err != nil {
-		return "", err
+		return nil, err
 	}
 
 	script := file.Name()
@@ -231,7 +436,7 @@ func (c *Client) ExecuteScript(
 
 	session, err := client.NewSession()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer session.Close()
 
@@ -243,84 +448,63 @@ func (c *Client) ExecuteScript(
 	command := ""
 	switch {
 	case sudo && remove:
-		command = fmt.Sprintf("%ssudo -u %s -H bash -c '%s;rm -f %s'", exportLang, runAs, script, script)
+		command = fmt.Sprintf("%s%s -u %s -H bash -c '%s;rm -f %s'", exportLang, c.sudo(), runAs, script, script)
 	case sudo && !remove:
-		command = fmt.Sprintf("%ssudo -u %s -H bash -c '%s'", exportLang, runAs, script)
+		command = fmt.Sprintf("%s%s -u %s -H bash -c '%s'", exportLang, c.sudo(), runAs, script)
 	case !sudo && remove:
 		command = fmt.Sprintf("%s%s;rm -f %s", exportLang, script, script)
 	case !sudo && !remove:
 		command = exportLang + script
 	}
 
-	return c.executeCmd(session, command, host.Password)
+	return c.executeCmd(ctx, session, command, host.Password, host)
 }
 
-// PushFiles to remote host.
+// PushFiles to remote host. Files and dirs are streamed by the method of
+// c.TransferMethod, modes, mtimes, symlinks and empty dirs are preserved.
 func (c *Client) PushFiles(
+	ctx context.Context,
 	host *Host,
-	srcFiles, srcZipFiles []string,
+	srcFiles []string,
 	dstDir string,
 	allowOverwrite bool,
 ) (string, error) {
-	client, err := c.getClient(host)
+	client, release, err := c.getClient(ctx, host)
 	if err != nil {
 		return "", err
 	}
-	defer client.Close()
+	defer release()
 
-	ftpC, err := sftp.NewClient(client)
-	if err != nil {
-		return "", err
+	method := c.TransferMethod
+	if method == "" || method == TransferAuto {
+		method = TransferSFTP
+		if c.hasCommand(client, "tar") {
+			method = TransferTar
+		}
 	}
-	defer ftpC.Close()
 
-	for i, f := range srcZipFiles {
-		srcFile := srcFiles[i]
-
-		dstZipFile := filepath.Base(f)
-
-		done := make(chan struct{})
-		var (
-			err  error
-			file *sftp.File
-		)
-		go func() {
-			defer close(done)
-
-			file, err = c.pushZipFile(ftpC, f, filepath.Base(srcFile), dstDir, allowOverwrite)
-			if err == nil {
-				file.Close()
-			}
-		}()
-
-		<-done
+	log.Debugf("Push: copy files to '%s' by %s", host.Alias, method)
 
+	if method == TransferTar {
+		err = c.pushFilesByTar(ctx, client, srcFiles, dstDir, allowOverwrite)
+	} else {
+		var ftpC *sftp.Client
+		ftpC, err = sftp.NewClient(client)
 		if err != nil {
 			return "", err
 		}
+		defer ftpC.Close()
+		defer closeOnDone(ctx, ftpC)()
 
-		session, err := client.NewSession()
-		if err != nil {
-			return "", err
-		}
-		defer session.Close()
-
-		_, err = c.executeCmd(
-			session,
-			fmt.Sprintf(
-				`which unzip &>/dev/null && { cd %s;unzip -o %s;rm %s;} || 
-				{ echo "need install 'unzip' command";cd %s;rm %s;exit 1;}`,
-				dstDir,
-				dstZipFile,
-				dstZipFile,
-				dstDir,
-				dstZipFile,
-			),
-			host.Password,
-		)
-		if err != nil {
-			return "", err
+		err = c.pushFilesBySFTP(ftpC, srcFiles, dstDir, allowOverwrite)
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
 		}
+
+		return "", err
 	}
 
 	hasOrHave := "has"
@@ -331,27 +515,31 @@ func (c *Client) PushFiles(
 	return fmt.Sprintf("'%s' %s been copied to '%s'", strings.Join(srcFiles, ","), hasOrHave, dstDir), nil
 }
 
-// FetchFiles from remote host.
+// FetchFiles from remote host to dstDir/host.Host. Files and dirs are
+// streamed by 'tar -c' with sudo, otherwise by the method of
+// c.TransferMethod, nothing is staged on the remote host.
 //
 //nolint:funlen,gocyclo
 func (c *Client) FetchFiles(
+	ctx context.Context,
 	host *Host,
 	srcFiles []string,
-	dstDir, tmpDir string,
+	dstDir string,
 	sudo bool,
 	runAs string,
 ) (string, error) {
-	client, err := c.getClient(host)
+	client, release, err := c.getClient(ctx, host)
 	if err != nil {
 		return "", err
 	}
-	defer client.Close()
+	defer release()
 
 	ftpC, err := sftp.NewClient(client)
 	if err != nil {
 		return "", err
 	}
 	defer ftpC.Close()
+	defer closeOnDone(ctx, ftpC)()
 
 	var (
 		validSrcFiles    []string
@@ -392,72 +580,34 @@ func (c *Client) FetchFiles(
 		return "", err2
 	}
 
-	session, err := client.NewSession()
-	if err != nil {
+	finalDstDir := filepath.Join(dstDir, host.Host)
+	if err := os.MkdirAll(finalDstDir, os.ModePerm); err != nil {
 		return "", err
 	}
-	defer session.Close()
 
-	zippedFileTmpDir := path.Join(tmpDir, ".gossh-tmp-"+host.Host)
-	tmpZipFile := fmt.Sprintf("%s.%d", host.Host, time.Now().UnixMicro())
-	zippedFileFullpath := path.Join(zippedFileTmpDir, tmpZipFile)
-	_, err = c.executeCmd(
-		session,
-		fmt.Sprintf(
-			`if which zip &>/dev/null;then 
-    sudo -u %s -H bash -c '[[ ! -d %s ]] && { mkdir -p %s;chmod 777 %s;};zip -r %s %s'
-else
-	echo "need install 'zip' command"
-	exit 1
-fi`,
-			runAs,
-			zippedFileTmpDir,
-			zippedFileTmpDir,
-			zippedFileTmpDir,
-			zippedFileFullpath,
-			strings.Join(validSrcFiles, " "),
-		),
-		host.Password,
-	)
-	if err != nil {
-		log.Debugf("zip %s of %s failed: %s", strings.Join(validSrcFiles, ","), host.Host, err)
-		return "", err
+	method := c.TransferMethod
+	if sudo || method == "" || method == TransferAuto {
+		method = TransferSFTP
+		if sudo {
+			method = TransferTar
+		}
 	}
 
-	file, err := c.fetchZipFile(ftpC, zippedFileFullpath, dstDir)
-	if err == nil {
-		file.Close()
-	}
-	if err != nil {
-		log.Debugf("fetch zip file '%s' from %s failed: %s", zippedFileFullpath, host.Host, err)
-		return "", err
-	}
+	log.Debugf("Fetch: copy files from '%s' by %s", host.Alias, method)
 
-	session2, err := client.NewSession()
-	if err != nil {
-		return "", err
+	if method == TransferTar {
+		err = c.fetchFilesByTar(ctx, client, validSrcFiles, finalDstDir, host.Password, sudo, runAs)
+	} else {
+		err = fetchFilesBySFTP(ftpC, validSrcFiles, finalDstDir)
 	}
-	defer session2.Close()
 
-	_, err = c.executeCmd(
-		session2,
-		fmt.Sprintf("sudo -u %s -H bash -c 'rm -f %s'", runAs, zippedFileFullpath),
-		host.Password,
-	)
 	if err != nil {
-		log.Debugf("remove '%s:%s' failed: %s", host.Host, zippedFileFullpath, err)
-		return "", err
-	}
-
-	finalDstDir := path.Join(dstDir, host.Host)
-	localZippedFileFullpath := path.Join(dstDir, tmpZipFile)
-	defer func() {
-		if err := os.Remove(localZippedFileFullpath); err != nil {
-			log.Debugf("remove '%s' failed: %s", localZippedFileFullpath, err)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
 		}
-	}()
-	if err := util.Unzip(localZippedFileFullpath, finalDstDir); err != nil {
-		log.Debugf("unzip '%s' to '%s' failed: %s", localZippedFileFullpath, finalDstDir, err)
+
+		log.Debugf("Fetch: copy '%s' from %s failed: %s", strings.Join(validSrcFiles, ","), host.Host, err)
+
 		return "", err
 	}
 
@@ -501,26 +651,73 @@ fi`,
 	return ret, nil
 }
 
-func (c *Client) executeCmd(session *ssh.Session, command, password string) (string, error) {
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          0,
-		ssh.TTY_OP_ISPEED: 28800,
-		ssh.TTY_OP_OSPEED: 28800,
-	}
+// executeCmd executes command in session, the output is streamed to
+// c.StreamHandler if streamHost is not nil.
+//
+//nolint:funlen,gocyclo
+func (c *Client) executeCmd(
+	ctx context.Context,
+	session *ssh.Session,
+	command, password string,
+	streamHost *Host,
+) (*Output, error) {
+	if !c.NoPTY {
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          0,
+			ssh.TTY_OP_ISPEED: 28800,
+			ssh.TTY_OP_OSPEED: 28800,
+		}
 
-	//nolint:gomnd
-	if err := session.RequestPty("xterm", 100, 100, modes); err != nil {
-		return "", err
+		//nolint:gomnd
+		if err := session.RequestPty("xterm", 100, 100, modes); err != nil {
+			return nil, err
+		}
 	}
 
 	w, err := session.StdinPipe()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	r, err := session.StdoutPipe()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	// Output read by handleOutput, and the stdout read separately without pty.
+	var (
+		outputW io.Writer = ioutil.Discard
+		stdoutW io.Writer = ioutil.Discard
+	)
+	if streamHost != nil && c.StreamHandler != nil {
+		stdoutLW := newLineWriter(streamHost, StreamStdout, c.StreamHandler)
+		defer stdoutLW.Flush()
+		outputW, stdoutW = stdoutLW, stdoutLW
+
+		if c.NoPTY {
+			stderrLW := newLineWriter(streamHost, StreamStderr, c.StreamHandler)
+			defer stderrLW.Flush()
+			outputW = stderrLW
+		}
+	}
+
+	// Sudo password prompt is on the pty, or on stderr by 'sudo -S'.
+	var stdout bytes.Buffer
+	stdoutDone := make(chan struct{})
+	if c.NoPTY {
+		stderr, err1 := session.StderrPipe()
+		if err1 != nil {
+			return nil, err1
+		}
+
+		go func(r io.Reader) {
+			defer close(stdoutDone)
+			_, _ = io.Copy(io.MultiWriter(&stdout, stdoutW), r)
+		}(r)
+
+		r = stderr
+	} else {
+		close(stdoutDone)
 	}
 
 	out, isWrongPass := c.handleOutput(w, r, password)
@@ -531,25 +728,63 @@ func (c *Client) executeCmd(session *ssh.Session, command, password string) (str
 		err = session.Run(command)
 	}()
 
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			// Interrupt the remote process through the pty, and also signal
+			// it for servers supporting signal requests. Closing the session
+			// hangs up the remaining processes.
+			_, _ = w.Write([]byte{etx})
+			_ = session.Signal(ssh.SIGTERM)
+			session.Close()
+		}
+	}()
+
 	var output []byte
 	for v := range out {
 		output = append(output, v...)
+		_, _ = outputW.Write(v)
 	}
 
-	outputStr := string(output)
-
 	if <-isWrongPass {
-		return "", errors.New("wrong sudo password")
+		session.Close()
+		return nil, errors.New("wrong sudo password")
 	}
 
 	<-done
+	<-stdoutDone
 
-	if err != nil {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	result := &Output{Stdout: string(output)}
+	if c.NoPTY {
+		result.Stdout = stdout.String()
+		result.Stderr = sudoPromptRegex.ReplaceAllString(string(output), "")
+	}
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return result, nil
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+		result.Signal = exitErr.Signal()
+		if result.Signal != "" {
+			result.ExitCode = -1
+		}
+	case errors.As(err, new(*ssh.ExitMissingError)):
+		result.ExitCode = -1
+	default:
 		log.Debugf("'%s' executed failed: %s", command, err)
-		return "", errors.New(outputStr)
+		return nil, err
 	}
 
-	return outputStr, nil
+	log.Debugf("'%s' executed failed: %s", command, err)
+
+	return result, &CommandError{Output: result}
 }
 
 func (c *Client) pushFile(
@@ -614,135 +849,147 @@ func (c *Client) pushFile(
 	return file, nil
 }
 
-func (c *Client) pushZipFile(
-	ftpC *sftp.Client,
-	srcZipFile, srcFileName, dstDir string,
-	allowOverwrite bool,
-) (*sftp.File, error) {
-	homeDir := os.Getenv("HOME")
-	if strings.HasPrefix(srcZipFile, "~/") {
-		srcZipFile = strings.Replace(srcZipFile, "~", homeDir, 1)
-	}
-
-	content, err := ioutil.ReadFile(srcZipFile)
-	if err != nil {
-		return nil, err
+// sudo command that reads password from pty or stdin.
+func (c *Client) sudo() string {
+	if c.NoPTY {
+		return "sudo -S"
 	}
 
-	srcZipFileName := filepath.Base(srcZipFile)
-	dstZipFile := path.Join(dstDir, srcZipFileName)
+	return "sudo"
+}
 
-	dstFile := path.Join(dstDir, srcFileName)
+// getClient returns the pooled connection to host, ctx only bounds the wait
+// of the caller, the connection is dialed with the ctx of the pool.
+func (c *Client) getClient(ctx context.Context, host *Host) (*ssh.Client, func(), error) {
+	key := connKey(host, c.hostProxies(host))
 
-	if !allowOverwrite {
-		dstFileInfo, _ := ftpC.Stat(dstFile)
-		if dstFileInfo != nil {
-			return nil, fmt.Errorf(
-				"%s alreay exists, you can add '-F' flag to overwrite it",
-				dstFile,
-			)
-		}
+	client, release, err := c.pool.get(ctx, key, func() (*ssh.Client, error) {
+		return c.dial(c.pool.ctx, host)
+	})
+	if err != nil && ctx.Err() == nil {
+		return nil, nil, &ConnError{Err: err}
 	}
 
-	file, err := ftpC.Create(dstZipFile)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, fmt.Errorf("dest dir '%s' not exist", dstDir)
-		}
-
-		if err, ok := err.(*sftp.StatusError); ok && err.Code == uint32(sftp.ErrSshFxPermissionDenied) {
-			return nil, fmt.Errorf("no permission to write to dest dir '%s'", dstDir)
-		}
+	return client, release, err
+}
 
-		return nil, err
+// connKey identifies the connection to host through proxies, hosts of the
+// same address behind different jump hosts may be different machines.
+func connKey(host *Host, proxies []*ProxyHop) string {
+	key := fmt.Sprintf("%s@%s", host.User, net.JoinHostPort(host.Host, strconv.Itoa(host.Port)))
+	if len(proxies) != 0 {
+		key += " via " + proxyChain(proxies)
 	}
 
-	_, err = file.Write(content)
-	if err != nil {
-		return nil, err
+	return key
+}
+
+// hostProxies returns the jump hosts of host.
+func (c *Client) hostProxies(host *Host) []*ProxyHop {
+	if host.Proxies != nil {
+		return host.Proxies
 	}
 
-	return file, nil
+	return c.Proxies
 }
 
-func (c *Client) fetchZipFile(
-	ftpC *sftp.Client,
-	srcZipFile, dstDir string,
-) (*sftp.File, error) {
-	homeDir := os.Getenv("HOME")
-	if strings.HasPrefix(dstDir, "~/") {
-		srcZipFile = strings.Replace(dstDir, "~", homeDir, 1)
-	}
+func (c *Client) dial(ctx context.Context, host *Host) (*ssh.Client, error) {
+	remoteHost := net.JoinHostPort(host.Host, strconv.Itoa(host.Port))
 
-	srcZipFileName := filepath.Base(srcZipFile)
-	dstZipFile := path.Join(dstDir, srcZipFileName)
+	proxies := c.hostProxies(host)
 
-	file, err := ftpC.Open(srcZipFile)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, fmt.Errorf("'%s' not exist", srcZipFile)
+	if c.ControlPersist > 0 && len(proxies) == 0 {
+		client, err := c.dialControlMaster(host)
+		if err == nil {
+			return client, nil
 		}
 
-		if err, ok := err.(*sftp.StatusError); ok && err.Code == uint32(sftp.ErrSshFxPermissionDenied) {
-			return nil, fmt.Errorf("no permission to open '%s'", srcZipFile)
-		}
+		log.Debugf("Control Master: %s, connect '%s' directly", err, host.Alias)
+	}
 
-		return nil, err
+	sshConfig := c.sshConfig(host.User, host.SSHAuths, remoteHost)
+
+	if len(proxies) == 0 {
+		return c.dialVia(ctx, nil, remoteHost, sshConfig)
 	}
 
-	zipFile, err := os.Create(dstZipFile)
+	proxyClient, release, err := c.getProxyClient(ctx, proxies)
 	if err != nil {
-		return nil, fmt.Errorf("open local '%s' failed: %w", dstZipFile, err)
+		return nil, err
 	}
 
-	_, err = file.WriteTo(zipFile)
+	client, err := c.dialVia(ctx, proxyClient, remoteHost, sshConfig)
 	if err != nil {
+		release()
 		return nil, err
 	}
 
-	return file, nil
+	go func() {
+		_ = client.Wait()
+		release()
+	}()
+
+	return client, nil
 }
 
-func (c *Client) getClient(host *Host) (*ssh.Client, error) {
-	var (
-		client *ssh.Client
-		err    error
-	)
+// newClientConn makes ssh handshake on conn, conn is closed if ctx is done
+// before the handshake finished.
+func newClientConn(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	done := make(chan struct{})
+	defer close(done)
 
-	sshConfig := &ssh.ClientConfig{
-		User:    host.User,
-		Auth:    host.SSHAuths,
-		Timeout: c.ConnTimeout,
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			conn.Close()
+		}
+	}()
+
+	if config.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(config.Timeout))
 	}
-	//nolint:gosec
-	sshConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
 
-	remoteHost := net.JoinHostPort(host.Host, strconv.Itoa(host.Port))
+	ncc, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
 
-	if c.Proxy.SSHClient != nil || c.Proxy.Err != nil {
-		if c.Proxy.Err != nil {
-			return nil, c.Proxy.Err
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
 
-		conn, err2 := c.Proxy.SSHClient.Dial("tcp", remoteHost)
-		if err2 != nil {
-			return nil, err2
-		}
+		return nil, err
+	}
 
-		ncc, chans, reqs, err3 := ssh.NewClientConn(conn, remoteHost, sshConfig)
-		if err3 != nil {
-			return nil, err3
-		}
+	_ = conn.SetDeadline(time.Time{})
 
-		client = ssh.NewClient(ncc, chans, reqs)
-	} else {
-		client, err = ssh.Dial("tcp", remoteHost, sshConfig)
-		if err != nil {
-			return nil, err
+	return ssh.NewClient(ncc, chans, reqs), nil
+}
+
+// closeOnDone closes closer if ctx is done before the returned stop function
+// is called.
+func closeOnDone(ctx context.Context, closer io.Closer) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			closer.Close()
 		}
-	}
+	}()
 
-	return client, nil
+	return func() { close(done) }
+}
+
+func (c *Client) sshConfig(user string, auths []ssh.AuthMethod, addr string) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:              user,
+		Auth:              auths,
+		Timeout:           c.ConnTimeout,
+		HostKeyCallback:   c.HostKeyChecker.Check,
+		HostKeyAlgorithms: c.HostKeyChecker.HostKeyAlgorithms(addr),
+	}
 }
 
 // handle output stream, and give sudo password if necessary.
@@ -800,6 +1047,65 @@ func WithCommandTimeout(timeout time.Duration) func(*Client) {
 	}
 }
 
+// WithNoPTY executes commands without pty if noPTY is true.
+func WithNoPTY(noPTY bool) func(*Client) {
+	return func(c *Client) {
+		c.NoPTY = noPTY
+	}
+}
+
+// WithStreamHandler streams output lines of commands and scripts to handler.
+func WithStreamHandler(handler StreamHandler) func(*Client) {
+	return func(c *Client) {
+		c.StreamHandler = handler
+	}
+}
+
+// WithSerial runs hosts in sequential batches of the sizes, like "1", "10%".
+func WithSerial(sizes []string) func(*Client) {
+	return func(c *Client) {
+		c.Serial = sizes
+	}
+}
+
+// WithMaxFailPercentage stops running subsequent batches if more than the
+// percentage of hosts failed in a batch, 0 stops them once any host failed.
+func WithMaxFailPercentage(percent int) func(*Client) {
+	return func(c *Client) {
+		c.MaxFailPercentage = percent
+	}
+}
+
+// WithAnyErrorsFatal stops running any other hosts once a host failed.
+func WithAnyErrorsFatal(fatal bool) func(*Client) {
+	return func(c *Client) {
+		c.AnyErrorsFatal = fatal
+	}
+}
+
+// WithRetries retries hosts failed to connect, the delay before each retry
+// starts from backoff and doubles.
+func WithRetries(retries int, backoff time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.Retries = retries
+		c.RetryBackoff = backoff
+	}
+}
+
+// WithBeforeBatch calls fn before each batch except the first one.
+func WithBeforeBatch(fn func(ctx context.Context, batch, batches int, hosts []*Host) bool) func(*Client) {
+	return func(c *Client) {
+		c.BeforeBatch = fn
+	}
+}
+
+// WithTransferMethod pushes files by method, one of TransferMethods.
+func WithTransferMethod(method string) func(*Client) {
+	return func(c *Client) {
+		c.TransferMethod = method
+	}
+}
+
 // WithConcurrency concurrency tasks number option.
 func WithConcurrency(count int) func(*Client) {
 	return func(c *Client) {
@@ -807,28 +1113,61 @@ func WithConcurrency(count int) func(*Client) {
 	}
 }
 
-// WithProxyServer connect remote hosts by proxy server.
-func WithProxyServer(proxyServer, user string, port int, auths []ssh.AuthMethod) func(*Client) {
+// WithMaxOpenConns limits the number of open connections to target hosts,
+// idle connections are closed if the limit is reached, 0 means no limit.
+// Connections to jump hosts are shared and not counted.
+func WithMaxOpenConns(count int) func(*Client) {
 	return func(c *Client) {
-		proxySSHConfig := &ssh.ClientConfig{
-			User:    user,
-			Auth:    auths,
-			Timeout: c.ConnTimeout,
-		}
-		//nolint:gosec
-		proxySSHConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		c.MaxOpenConns = count
+	}
+}
 
-		proxyClient, err1 := ssh.Dial(
-			"tcp",
-			net.JoinHostPort(proxyServer, strconv.Itoa(port)),
-			proxySSHConfig,
-		)
-		if err1 != nil {
-			c.Proxy.Err = fmt.Errorf("connet to proxy %s:%d failed: %s", proxyServer, port, err1)
+// WithIdleTimeout keeps unused connections for reusing, 0 means closing
+// connections as soon as they are not used.
+func WithIdleTimeout(timeout time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.IdleTimeout = timeout
+	}
+}
 
+// WithControlPersist shares connections between processes by control sockets
+// in dir, prefix '~/' of dir is expanded to home directory. A connection is
+// kept by a background master process started by masterCmd, until it has not
+// been used for persist time.
+// The master process must call ServeControlMaster with its stdio.
+func WithControlPersist(dir string, persist time.Duration, masterCmd ...string) func(*Client) {
+	return func(c *Client) {
+		if dir == "" || len(masterCmd) == 0 {
 			return
 		}
 
-		c.Proxy.SSHClient = proxyClient
+		c.ControlDir = expandHome(dir)
+		c.ControlPersist = persist
+		c.ControlMasterCmd = masterCmd
+	}
+}
+
+// WithHostKeyChecker verify host keys of target hosts and proxy server by checker.
+func WithHostKeyChecker(checker *HostKeyChecker) func(*Client) {
+	return func(c *Client) {
+		c.HostKeyChecker = checker
+	}
+}
+
+// WithProxyServer connect remote hosts by proxy server.
+func WithProxyServer(proxyServer, user string, port int, auths []ssh.AuthMethod) func(*Client) {
+	return WithProxies(&ProxyHop{
+		Host:     proxyServer,
+		Port:     port,
+		User:     user,
+		SSHAuths: auths,
+	})
+}
+
+// WithProxies connect remote hosts by the chain of jump hosts, unless
+// Host.Proxies is set. The jump hosts are connected when needed.
+func WithProxies(proxies ...*ProxyHop) func(*Client) {
+	return func(c *Client) {
+		c.Proxies = proxies
 	}
 }
@@ -0,0 +1,365 @@
+package batchssh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeTask runs hosts by their aliases: hosts named 'block*' block until ctx
+// is done, hosts named 'fail*' fail, and other hosts succeed.
+type fakeTask struct {
+	mu sync.Mutex
+	// ran are the aliases of hosts RunSSH is called for.
+	ran []string
+}
+
+func (t *fakeTask) RunSSH(ctx context.Context, host *Host) (*Output, error) {
+	t.mu.Lock()
+	t.ran = append(t.ran, host.Alias)
+	t.mu.Unlock()
+
+	switch {
+	case len(host.Alias) >= 5 && host.Alias[:5] == "block":
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case len(host.Alias) >= 4 && host.Alias[:4] == "fail":
+		output := &Output{Stderr: "failed", ExitCode: 1}
+		return output, &CommandError{Output: output}
+	default:
+		return &Output{Stdout: "ok"}, nil
+	}
+}
+
+func (t *fakeTask) ranHosts() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]string(nil), t.ran...)
+}
+
+func newFakeHosts(aliases ...string) []*Host {
+	hosts := make([]*Host, 0, len(aliases))
+	for _, v := range aliases {
+		hosts = append(hosts, &Host{Alias: v, Host: v, Port: 22, User: "root"})
+	}
+
+	return hosts
+}
+
+// collectResults reads results until the channel is closed, it fails the
+// test if the channel is not closed in time.
+func collectResults(t *testing.T, resCh <-chan *Result) map[string]*Result {
+	t.Helper()
+
+	results := make(map[string]*Result)
+	timeout := time.After(10 * time.Second)
+
+	for {
+		select {
+		case v, ok := <-resCh:
+			if !ok {
+				return results
+			}
+
+			if _, ok := results[v.Host]; ok {
+				t.Errorf("duplicated result of '%s'", v.Host)
+			}
+			results[v.Host] = v
+		case <-timeout:
+			t.Fatal("result channel is not closed in time")
+		}
+	}
+}
+
+func TestBatchRunCommandTimeout(t *testing.T) {
+	c := NewClient(WithCommandTimeout(50*time.Millisecond), WithConcurrency(2))
+	defer c.Close()
+
+	task := &fakeTask{}
+	hosts := newFakeHosts("block1", "block2", "ok1")
+
+	resCh, err := c.BatchRunContext(context.Background(), hosts, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocked hosts return only if their ctx is done.
+	results := collectResults(t, resCh)
+
+	if len(results) != len(hosts) {
+		t.Fatalf("got %d results, want %d", len(results), len(hosts))
+	}
+
+	for _, alias := range []string{"block1", "block2"} {
+		v := results[alias]
+		if v.Status != TimeoutIdentifier || !strings.HasPrefix(v.Message, "command timeout") {
+			t.Errorf("result of '%s' = %s: %s, want %s", alias, v.Status, v.Message, TimeoutIdentifier)
+		}
+	}
+
+	if v := results["ok1"]; v.Status != SuccessIdentifier || v.Stdout != "ok" {
+		t.Errorf("result of 'ok1' = %s: %s, want %s", v.Status, v.Message, SuccessIdentifier)
+	}
+}
+
+func TestBatchRunContextCancel(t *testing.T) {
+	c := NewClient(WithConcurrency(1))
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	task := &fakeTask{}
+	hosts := newFakeHosts("ok1", "block1", "block2", "ok2")
+
+	resCh, err := c.BatchRunContext(ctx, hosts, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	results := collectResults(t, resCh)
+
+	if len(results) != len(hosts) {
+		t.Fatalf("got %d results, want %d", len(results), len(hosts))
+	}
+
+	if v := results["ok1"]; v.Status != SuccessIdentifier {
+		t.Errorf("result of 'ok1' = %s, want %s", v.Status, SuccessIdentifier)
+	}
+
+	// block1 is running when cancelled, the other hosts are not started.
+	for _, alias := range []string{"block1", "block2", "ok2"} {
+		v := results[alias]
+		if v.Status != CancelledIdentifier || v.ExitCode != -1 {
+			t.Errorf("result of '%s' = %s (exit code %d), want %s", alias, v.Status, v.ExitCode, CancelledIdentifier)
+		}
+		if v.EndTime.Before(v.StartTime) {
+			t.Errorf("result of '%s' ends before it starts", alias)
+		}
+	}
+
+	if ran := task.ranHosts(); fmt.Sprint(ran) != "[ok1 block1]" {
+		t.Errorf("ran hosts = %v, want [ok1 block1]", ran)
+	}
+}
+
+func TestBatchRunContextTaskTimeout(t *testing.T) {
+	c := NewClient(WithConcurrency(10))
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	resCh, err := c.BatchRunContext(ctx, newFakeHosts("block1", "block2"), &fakeTask{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for alias, v := range collectResults(t, resCh) {
+		if v.Status != TimeoutIdentifier || v.Message != "task timeout" {
+			t.Errorf("result of '%s' = %s: %s, want %s", alias, v.Status, v.Message, TimeoutIdentifier)
+		}
+	}
+
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("ctx error = %v", ctx.Err())
+	}
+}
+
+// newTestExecClient returns a client of a local ssh server, commands
+// executed by sessions are passed to exec with the server side channel.
+// Signals sent by the client are sent to signals.
+func newTestExecClient(t *testing.T, exec func(command string, ch ssh.Channel), signals chan<- string) *ssh.Client {
+	t.Helper()
+
+	return newTestSSHClient(t, func(newCh ssh.NewChannel) {
+		ch, reqs, err := newCh.Accept()
+		if err != nil {
+			return
+		}
+
+		for req := range reqs {
+			switch req.Type {
+			case "exec":
+				var payload struct{ Command string }
+				_ = ssh.Unmarshal(req.Payload, &payload)
+				_ = req.Reply(true, nil)
+
+				go func() {
+					defer ch.Close()
+					exec(payload.Command, ch)
+				}()
+			case "signal":
+				var payload struct{ Signal string }
+				_ = ssh.Unmarshal(req.Payload, &payload)
+				if signals != nil {
+					signals <- payload.Signal
+				}
+			default:
+				_ = req.Reply(true, nil)
+			}
+		}
+	})
+}
+
+// newTestExecSession returns a session of a client by newTestExecClient.
+func newTestExecSession(t *testing.T, exec func(command string, ch ssh.Channel), signals chan<- string) *ssh.Session {
+	t.Helper()
+
+	session, err := newTestExecClient(t, exec, signals).NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+
+	return session
+}
+
+func TestExecuteCmdCancel(t *testing.T) {
+	signals := make(chan string, 1)
+	hang := make(chan struct{})
+	defer close(hang)
+
+	session := newTestExecSession(t, func(string, ssh.Channel) { <-hang }, signals)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	c := NewClient(WithNoPTY(true))
+	defer c.Close()
+
+	output, err := c.executeCmd(ctx, session, "sleep 100", "", nil)
+	if !errors.Is(err, context.Canceled) || output != nil {
+		t.Fatalf("executeCmd() = %v, %v, want %v", output, err, context.Canceled)
+	}
+
+	select {
+	case sig := <-signals:
+		if sig != string(ssh.SIGTERM) {
+			t.Errorf("remote process got signal %s, want %s", sig, ssh.SIGTERM)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("remote process is not signaled")
+	}
+}
+
+func TestExecuteCmdOutput(t *testing.T) {
+	exitStatus := func(ch ssh.Channel, status uint32) {
+		_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	}
+
+	tests := []struct {
+		name     string
+		noPTY    bool
+		password string
+		exec     func(ch ssh.Channel)
+		want     *Output
+		wantErr  bool
+	}{
+		{
+			name: "success with pty",
+			exec: func(ch ssh.Channel) {
+				_, _ = ch.Write([]byte("hello\r\n"))
+				_, _ = ch.Stderr().Write([]byte("warn\r\n"))
+				exitStatus(ch, 0)
+			},
+			want: &Output{Stdout: "hello\r\n"},
+		},
+		{
+			name:  "exit status without pty",
+			noPTY: true,
+			exec: func(ch ssh.Channel) {
+				_, _ = ch.Write([]byte("out\n"))
+				_, _ = ch.Stderr().Write([]byte("no such file\n"))
+				exitStatus(ch, 2)
+			},
+			want:    &Output{Stdout: "out\n", Stderr: "no such file\n", ExitCode: 2},
+			wantErr: true,
+		},
+		{
+			name:  "killed by signal",
+			noPTY: true,
+			exec: func(ch ssh.Channel) {
+				_, _ = ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+					Signal     string
+					CoreDumped bool
+					Error      string
+					Lang       string
+				}{Signal: "KILL"}))
+			},
+			want:    &Output{ExitCode: -1, Signal: "KILL"},
+			wantErr: true,
+		},
+		{
+			name:    "exit status missing",
+			noPTY:   true,
+			exec:    func(ch ssh.Channel) {},
+			want:    &Output{ExitCode: -1},
+			wantErr: true,
+		},
+		{
+			name:     "sudo prompt removed from stderr",
+			noPTY:    true,
+			password: "secret",
+			exec: func(ch ssh.Channel) {
+				_, _ = ch.Stderr().Write([]byte("[sudo] password for alice: "))
+
+				line, _ := bufio.NewReader(ch).ReadString('\n')
+				if line != "secret\n" {
+					_, _ = ch.Stderr().Write([]byte("Sorry, try again.\n"))
+					exitStatus(ch, 1)
+					return
+				}
+
+				_, _ = ch.Write([]byte("root\n"))
+				_, _ = ch.Stderr().Write([]byte("warn\n"))
+				exitStatus(ch, 0)
+			},
+			want: &Output{Stdout: "root\n", Stderr: "warn\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := newTestExecSession(t, func(_ string, ch ssh.Channel) { tt.exec(ch) }, nil)
+
+			c := NewClient(WithNoPTY(tt.noPTY))
+			defer c.Close()
+
+			got, err := c.executeCmd(context.Background(), session, "cmd", tt.password, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("executeCmd() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var cmdErr *CommandError
+			if err != nil && (!errors.As(err, &cmdErr) || cmdErr.Output != got) {
+				t.Errorf("executeCmd() error = %#v, want CommandError with the output", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("executeCmd() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSudo(t *testing.T) {
+	if got := NewClient().sudo(); got != "sudo" {
+		t.Errorf("sudo() with pty = %q, want %q", got, "sudo")
+	}
+
+	// sudo reads password from stdin without pty.
+	if got := NewClient(WithNoPTY(true)).sudo(); got != "sudo -S" {
+		t.Errorf("sudo() without pty = %q, want %q", got, "sudo -S")
+	}
+}
@@ -0,0 +1,487 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package batchssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/serialt/gosible/pkg/log"
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// controlMasterSpec is sent to the master process to tell it which host to
+// connect and where to listen. It is written to the stdin pipe of the master
+// process, never to its arguments or environment, so the password in it can
+// not be read by other users.
+type controlMasterSpec struct {
+	Host            string        `json:"host"`
+	Port            int           `json:"port"`
+	User            string        `json:"user"`
+	Password        string        `json:"password"`
+	Socket          string        `json:"socket"`
+	Persist         time.Duration `json:"persist"`
+	ConnTimeout     time.Duration `json:"conn_timeout"`
+	HostKeyChecking string        `json:"host_key_checking"`
+	KnownHostsFiles []string      `json:"known_hosts_files"`
+}
+
+// controlSocket returns the control socket path of host, hosts connected
+// through different jump hosts have different control sockets.
+func (c *Client) controlSocket(host *Host) string {
+	//nolint:gosec
+	sum := sha1.Sum([]byte(connKey(host, c.hostProxies(host))))
+
+	return filepath.Join(c.ControlDir, hex.EncodeToString(sum[:10])+".sock")
+}
+
+// dialControlMaster connects host through its control socket, a new master
+// process is started if there is no live control socket for the host.
+func (c *Client) dialControlMaster(host *Host) (*ssh.Client, error) {
+	socket := c.controlSocket(host)
+
+	// control sockets accept connections without authentication, they must
+	// be in a directory only current user can access.
+	if err := util.CheckPrivateDir(c.ControlDir); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unsafe control dir: %w", err)
+	}
+
+	client, err := dialControlSocket(socket, host.User, c.ConnTimeout)
+	if err == nil {
+		log.Debugf("Control Master: reuse control socket '%s' for '%s'", socket, host.Alias)
+		return client, nil
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		log.Debugf("Control Master: remove stale control socket '%s'", socket)
+		_ = os.Remove(socket)
+	}
+
+	if err := c.startControlMaster(host, socket); err != nil {
+		return nil, fmt.Errorf("start control master failed: %w", err)
+	}
+
+	log.Debugf("Control Master: started for '%s', socket '%s'", host.Alias, socket)
+
+	return dialControlSocket(socket, host.User, c.ConnTimeout)
+}
+
+func (c *Client) startControlMaster(host *Host, socket string) error {
+	//nolint:gomnd
+	if err := os.MkdirAll(c.ControlDir, 0700); err != nil {
+		return err
+	}
+
+	if err := util.CheckPrivateDir(c.ControlDir); err != nil {
+		return fmt.Errorf("unsafe control dir: %w", err)
+	}
+
+	//nolint:gosec
+	cmd := exec.Command(c.ControlMasterCmd[0], c.ControlMasterCmd[1:]...)
+	util.DetachProcess(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	defer stdin.Close()
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer func() {
+		_ = cmd.Process.Release()
+	}()
+
+	spec := controlMasterSpec{
+		Host:            host.Host,
+		Port:            host.Port,
+		User:            host.User,
+		Password:        host.Password,
+		Socket:          socket,
+		Persist:         c.ControlPersist,
+		ConnTimeout:     c.ConnTimeout,
+		HostKeyChecking: c.HostKeyChecker.mode,
+		KnownHostsFiles: c.HostKeyChecker.files,
+	}
+	if err := json.NewEncoder(stdin).Encode(spec); err != nil {
+		return err
+	}
+
+	// The master process authenticates with the signers of this process, and
+	// closes its stdio when it is ready or failed.
+	go func() {
+		_ = agent.ServeAgent(&signerAgent{signers: host.Signers}, struct {
+			io.Reader
+			io.Writer
+		}{stdout, stdin})
+	}()
+
+	msg, err := io.ReadAll(stderr)
+	if err != nil {
+		return err
+	}
+
+	if msg := strings.TrimSpace(string(msg)); msg != "" {
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+func dialControlSocket(socket, user string, timeout time.Duration) (*ssh.Client, error) {
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	config := &ssh.ClientConfig{
+		User: user,
+		// The control socket is in a directory that only accessible by
+		// current user, and the master process verified the target host.
+		//nolint:gosec
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+
+	ncc, chans, reqs, err := ssh.NewClientConn(conn, socket, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(ncc, chans, reqs), nil
+}
+
+// ServeControlMaster is the entry of a master process started by a client with
+// control persist enabled. It reads which host to connect from r, authenticates
+// with the signers served by the parent process over r and w, reports error to
+// status, and closes r, w and status when the control socket is ready. Then it
+// serves the control socket until the connection is unused for the persist time.
+func ServeControlMaster(r io.ReadCloser, w, status io.WriteCloser) error {
+	upstream, ln, persist, err := prepareControlMaster(r, w)
+	if err != nil {
+		fmt.Fprintln(status, err)
+	}
+
+	r.Close()
+	w.Close()
+	status.Close()
+
+	if err != nil {
+		return err
+	}
+
+	serveControlSocket(ln, upstream, persist)
+
+	return nil
+}
+
+func prepareControlMaster(r io.Reader, w io.Writer) (*ssh.Client, net.Listener, time.Duration, error) {
+	br := bufio.NewReader(r)
+
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("read control master spec failed: %w", err)
+	}
+
+	var spec controlMasterSpec
+	if err := json.Unmarshal(line, &spec); err != nil {
+		return nil, nil, 0, fmt.Errorf("parse control master spec failed: %w", err)
+	}
+
+	parentAgent := agent.NewClient(struct {
+		io.Reader
+		io.Writer
+	}{br, w})
+
+	auths := []ssh.AuthMethod{ssh.PublicKeysCallback(parentAgent.Signers)}
+	if spec.Password != "" {
+		auths = append(auths, ssh.Password(spec.Password))
+	}
+
+	client := NewClient(
+		WithConnTimeout(spec.ConnTimeout),
+		WithHostKeyChecker(NewHostKeyChecker(spec.HostKeyChecking, spec.KnownHostsFiles...)),
+	)
+
+	addr := net.JoinHostPort(spec.Host, strconv.Itoa(spec.Port))
+
+	upstream, err := ssh.Dial("tcp", addr, client.sshConfig(spec.User, auths, addr))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	if err := util.CheckPrivateDir(filepath.Dir(spec.Socket)); err != nil {
+		upstream.Close()
+		return nil, nil, 0, fmt.Errorf("unsafe control dir: %w", err)
+	}
+
+	// the socket is created accessible by current user only.
+	//nolint:gomnd
+	mask := util.Umask(0077)
+	ln, err := net.Listen("unix", spec.Socket)
+	util.Umask(mask)
+	if err != nil {
+		upstream.Close()
+		return nil, nil, 0, err
+	}
+
+	return upstream, ln, spec.Persist, nil
+}
+
+func serveControlSocket(ln net.Listener, upstream *ssh.Client, persist time.Duration) {
+	defer upstream.Close()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		ln.Close()
+		return
+	}
+
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		ln.Close()
+		return
+	}
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	var (
+		mu     sync.Mutex
+		active int
+	)
+
+	idle := time.AfterFunc(persist, func() { ln.Close() })
+
+	go func() {
+		_ = upstream.Wait()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		active++
+		idle.Stop()
+		mu.Unlock()
+
+		go func() {
+			defer func() {
+				mu.Lock()
+				active--
+				if active == 0 {
+					idle.Reset(persist)
+				}
+				mu.Unlock()
+			}()
+
+			serveControlConn(conn, upstream, config)
+		}()
+	}
+}
+
+// serveControlConn forwards all channels of a control socket connection to
+// the upstream connection.
+func serveControlConn(conn net.Conn, upstream *ssh.Client, config *ssh.ServerConfig) {
+	sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sc.Close()
+
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		go forwardChannel(newCh, upstream)
+	}
+}
+
+func forwardChannel(newCh ssh.NewChannel, upstream *ssh.Client) {
+	upCh, upReqs, err := upstream.OpenChannel(newCh.ChannelType(), newCh.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			_ = newCh.Reject(openErr.Reason, openErr.Message)
+		} else {
+			_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+		}
+
+		return
+	}
+
+	downCh, downReqs, err := newCh.Accept()
+	if err != nil {
+		upCh.Close()
+		return
+	}
+
+	go pipeChannel(upCh, upReqs, downCh)
+	go pipeChannel(downCh, downReqs, upCh)
+}
+
+// pipeChannel copies data and requests from src to dst, and closes dst
+// after src is closed.
+func pipeChannel(src ssh.Channel, srcReqs <-chan *ssh.Request, dst ssh.Channel) {
+	var wg sync.WaitGroup
+
+	//nolint:gomnd
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		_ = dst.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(dst.Stderr(), src.Stderr())
+	}()
+
+	for req := range srcReqs {
+		ok, err := dst.SendRequest(req.Type, req.WantReply, req.Payload)
+		if err != nil {
+			ok = false
+		}
+
+		if req.WantReply {
+			_ = req.Reply(ok, nil)
+		}
+	}
+
+	wg.Wait()
+	dst.Close()
+}
+
+// signerAgent serves signers of current process to the master process.
+type signerAgent struct {
+	signers []ssh.Signer
+}
+
+var errAgentReadOnly = errors.New("agent: read only")
+
+func (a *signerAgent) List() ([]*agent.Key, error) {
+	var keys []*agent.Key
+	for _, s := range a.signers {
+		pub := s.PublicKey()
+		keys = append(keys, &agent.Key{Format: pub.Type(), Blob: pub.Marshal()})
+	}
+
+	return keys, nil
+}
+
+func (a *signerAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *signerAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	for _, s := range a.signers {
+		if !bytes.Equal(s.PublicKey().Marshal(), key.Marshal()) {
+			continue
+		}
+
+		algoSigner, ok := s.(ssh.AlgorithmSigner)
+		if !ok || flags == 0 {
+			return s.Sign(rand.Reader, data)
+		}
+
+		switch {
+		case flags&agent.SignatureFlagRsaSha512 != 0:
+			return algoSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+		case flags&agent.SignatureFlagRsaSha256 != 0:
+			return algoSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA256)
+		default:
+			return s.Sign(rand.Reader, data)
+		}
+	}
+
+	return nil, errors.New("agent: key not found")
+}
+
+func (a *signerAgent) Add(agent.AddedKey) error {
+	return errAgentReadOnly
+}
+
+func (a *signerAgent) Remove(ssh.PublicKey) error {
+	return errAgentReadOnly
+}
+
+func (a *signerAgent) RemoveAll() error {
+	return errAgentReadOnly
+}
+
+func (a *signerAgent) Lock([]byte) error {
+	return errAgentReadOnly
+}
+
+func (a *signerAgent) Unlock([]byte) error {
+	return errAgentReadOnly
+}
+
+func (a *signerAgent) Signers() ([]ssh.Signer, error) {
+	return a.signers, nil
+}
+
+func (a *signerAgent) Extension(string, []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
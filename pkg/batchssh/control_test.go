@@ -0,0 +1,55 @@
+package batchssh
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlSocket(t *testing.T) {
+	host := &Host{Host: "10.0.0.1", Port: 22, User: "root"}
+	viaBastion := &Host{Host: "10.0.0.1", Port: 22, User: "root", Proxies: []*ProxyHop{{Host: "bastion", Port: 22, User: "ops"}}}
+	otherUser := &Host{Host: "10.0.0.1", Port: 22, User: "admin"}
+
+	c := NewClient(WithControlPersist("/tmp/cm", time.Minute, "gossh"))
+
+	socket := c.controlSocket(host)
+	if filepath.Dir(socket) != filepath.Clean("/tmp/cm") || !strings.HasSuffix(socket, ".sock") {
+		t.Errorf("controlSocket() = %s, want a socket in /tmp/cm", socket)
+	}
+
+	if socket != c.controlSocket(&Host{Host: "10.0.0.1", Port: 22, User: "root"}) {
+		t.Errorf("control sockets of the same host differ")
+	}
+
+	for _, h := range []*Host{viaBastion, otherUser} {
+		if c.controlSocket(h) == socket {
+			t.Errorf("control socket of %s is the same as %s", connKey(h, h.Proxies), connKey(host, nil))
+		}
+	}
+}
+
+func TestDialControlMasterUnsafeDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions of directories are not checked on windows")
+	}
+
+	dir := filepath.Join(t.TempDir(), "cm")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Mkdir is affected by umask.
+	if err := os.Chmod(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient(WithControlPersist(dir, time.Minute, "false"))
+
+	_, err := c.dialControlMaster(&Host{Host: "10.0.0.1", Port: 22, User: "root"})
+	if err == nil || !strings.Contains(err.Error(), "too open") {
+		t.Fatalf("dialControlMaster() error = %v, want permissions too open", err)
+	}
+}
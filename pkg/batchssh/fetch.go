@@ -0,0 +1,438 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package batchssh
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/serialt/gosible/pkg/log"
+)
+
+// fetchFilesByTar streams srcFiles from 'tar -c' on the remote host, which
+// runs as runAs by sudo if sudo is true, and extracts them into dstDir.
+func (c *Client) fetchFilesByTar(
+	ctx context.Context,
+	client *ssh.Client,
+	srcFiles []string,
+	dstDir, password string,
+	sudo bool,
+	runAs string,
+) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	defer closeOnDone(ctx, session)()
+
+	// Relative paths are relative to the login dir, so they are archived
+	// before changing to '/' for absolute paths.
+	var relFiles, absFiles []string
+	for _, f := range srcFiles {
+		f = path.Clean(f)
+		if path.IsAbs(f) {
+			absFiles = append(absFiles, shellQuote(strings.TrimPrefix(f, "/")))
+		} else {
+			relFiles = append(relFiles, shellQuote(f))
+		}
+	}
+
+	command := "tar -c -f - " + strings.Join(relFiles, " ")
+	if len(absFiles) != 0 {
+		command += " -C / " + strings.Join(absFiles, " ")
+	}
+
+	if sudo {
+		command = fmt.Sprintf("sudo -S -u %s -H bash -c %s", runAs, shellQuote(command))
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	if err := session.Start(command); err != nil {
+		return err
+	}
+
+	// Without a pty, 'sudo -S' reads the password from stdin, tar does not
+	// read stdin at all.
+	if sudo {
+		_, _ = io.WriteString(stdin, password+"\n")
+	}
+	stdin.Close()
+
+	readErr := extractTar(tar.NewReader(stdout), dstDir)
+	if readErr != nil {
+		// Let tar exit instead of blocking on a full channel window.
+		_, _ = io.Copy(io.Discard, stdout)
+	}
+
+	if err := session.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		msg := strings.TrimSpace(sudoPromptRegex.ReplaceAllString(stderr.String(), ""))
+		if msg != "" {
+			return errors.New(msg)
+		}
+
+		return err
+	}
+
+	return readErr
+}
+
+// extractTar extracts the archive of tr into dstDir.
+func extractTar(tr *tar.Reader, dstDir string) error {
+	dst := newFetchDest(dstDir)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		file, err := dst.localPath(header.Name)
+		if err != nil {
+			return err
+		}
+
+		mode := header.FileInfo().Mode()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = dst.mkdir(file, mode, header.ModTime)
+		case tar.TypeReg, tar.TypeRegA:
+			err = dst.writeFile(file, mode, header.ModTime, tr)
+		case tar.TypeSymlink:
+			err = dst.symlink(file, header.Linkname)
+		case tar.TypeLink:
+			var target string
+			if target, err = dst.localPath(header.Linkname); err == nil {
+				err = dst.link(file, target)
+			}
+		default:
+			log.Debugf("Fetch: skip '%s' of type %c", header.Name, header.Typeflag)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		dst.chown(file, header.Uid, header.Gid)
+	}
+
+	return dst.finish()
+}
+
+// fetchFilesBySFTP walks srcFiles on the remote host and copies them into
+// dstDir over sftp.
+func fetchFilesBySFTP(ftpC *sftp.Client, srcFiles []string, dstDir string) error {
+	dst := newFetchDest(dstDir)
+
+	for _, f := range srcFiles {
+		if err := fetchBySFTP(ftpC, dst, path.Clean(f)); err != nil {
+			return err
+		}
+	}
+
+	return dst.finish()
+}
+
+func fetchBySFTP(ftpC *sftp.Client, dst *fetchDest, src string) error {
+	info, err := ftpC.Lstat(src)
+	if err != nil {
+		return sftpReadError(err, src)
+	}
+
+	file, err := dst.localPath(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.IsDir():
+		if err := dst.mkdir(file, info.Mode(), info.ModTime()); err != nil {
+			return err
+		}
+
+		entries, err := ftpC.ReadDir(src)
+		if err != nil {
+			return sftpReadError(err, src)
+		}
+
+		for _, entry := range entries {
+			if err := fetchBySFTP(ftpC, dst, path.Join(src, entry.Name())); err != nil {
+				return err
+			}
+		}
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := ftpC.ReadLink(src)
+		if err != nil {
+			return sftpReadError(err, src)
+		}
+
+		if err := dst.symlink(file, link); err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		srcFile, err := ftpC.Open(src)
+		if err != nil {
+			return sftpReadError(err, src)
+		}
+		defer srcFile.Close()
+
+		if err := dst.writeFile(file, info.Mode(), info.ModTime(), srcFile); err != nil {
+			return err
+		}
+	default:
+		log.Debugf("Fetch: skip '%s' of mode %s", src, info.Mode())
+		return nil
+	}
+
+	if stat, ok := info.Sys().(*sftp.FileStat); ok {
+		dst.chown(file, int(stat.UID), int(stat.GID))
+	}
+
+	return nil
+}
+
+// sftpReadError makes sftp errors of reading file readable.
+func sftpReadError(err error, file string) error {
+	if err, ok := err.(*sftp.StatusError); ok && err.Code == uint32(sftp.ErrSshFxPermissionDenied) {
+		return fmt.Errorf("no permission to read '%s'", file)
+	}
+
+	return sftpError(err, file)
+}
+
+// fetchDest writes fetched files into a local dir.
+type fetchDest struct {
+	dir string
+
+	// Modes and mtimes of dirs are set after their contents are written.
+	dirInfos map[string]dirInfo
+	dirs     []string
+
+	// Owners are kept only if the local user is root.
+	chownOK bool
+}
+
+type dirInfo struct {
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newFetchDest(dir string) *fetchDest {
+	return &fetchDest{
+		dir:      dir,
+		dirInfos: make(map[string]dirInfo),
+		chownOK:  os.Geteuid() == 0,
+	}
+}
+
+// localPath of the remote file name, names out of dst.dir are refused.
+func (dst *fetchDest) localPath(name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if rel := path.Clean(name); clean == "/" || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("invalid file name '%s'", name)
+	}
+
+	return filepath.Join(dst.dir, filepath.FromSlash(clean)), nil
+}
+
+// prepare creates the parent dirs of file, and removes file if it exists
+// and is not a dir, so that links in dst.dir are never followed.
+func (dst *fetchDest) prepare(file string) error {
+	if err := dst.checkParents(file, true); err != nil {
+		return err
+	}
+
+	if info, err := os.Lstat(file); err == nil && !info.IsDir() {
+		return os.Remove(file)
+	}
+
+	return nil
+}
+
+// checkParents checks the parent dirs of file from dst.dir down one by one,
+// and creates them if create is true. File is refused if any of its parent
+// dirs is a symlink, which could be sent by the remote host before the file
+// to write files out of dst.dir.
+func (dst *fetchDest) checkParents(file string, create bool) error {
+	rel, err := filepath.Rel(dst.dir, filepath.Dir(file))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid file name '%s'", file)
+	}
+
+	if create {
+		if err := os.MkdirAll(dst.dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	if rel == "." {
+		return nil
+	}
+
+	dir := dst.dir
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+
+		if create {
+			if err := os.Mkdir(dir, os.ModePerm); err != nil && !os.IsExist(err) {
+				return err
+			}
+		}
+
+		info, err := os.Lstat(dir)
+		switch {
+		case err != nil:
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			return fmt.Errorf("refuse to write '%s' through symlink '%s'", file, dir)
+		case !info.IsDir():
+			return fmt.Errorf("'%s' of '%s' is not a dir", dir, file)
+		}
+	}
+
+	return nil
+}
+
+func (dst *fetchDest) mkdir(file string, mode os.FileMode, modTime time.Time) error {
+	if err := dst.prepare(file); err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(file, os.ModePerm); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	if _, ok := dst.dirInfos[file]; !ok {
+		dst.dirs = append(dst.dirs, file)
+	}
+	dst.dirInfos[file] = dirInfo{mode.Perm(), modTime}
+
+	return nil
+}
+
+func (dst *fetchDest) writeFile(file string, mode os.FileMode, modTime time.Time, r io.Reader) error {
+	if err := dst.prepare(file); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(file, mode.Perm()); err != nil {
+		return err
+	}
+
+	return os.Chtimes(file, time.Now(), modTime)
+}
+
+func (dst *fetchDest) symlink(file, link string) error {
+	if err := dst.prepare(file); err != nil {
+		return err
+	}
+
+	return os.Symlink(link, file)
+}
+
+func (dst *fetchDest) link(file, target string) error {
+	if err := dst.checkParents(target, false); err != nil {
+		return err
+	}
+
+	if err := dst.prepare(file); err != nil {
+		return err
+	}
+
+	return os.Link(target, file)
+}
+
+func (dst *fetchDest) chown(file string, uid, gid int) {
+	if !dst.chownOK {
+		return
+	}
+
+	if err := os.Lchown(file, uid, gid); err != nil {
+		log.Debugf("Fetch: chown '%s' failed: %s", file, err)
+	}
+}
+
+// finish sets modes and mtimes of dirs, children before parents.
+func (dst *fetchDest) finish() error {
+	for i := len(dst.dirs) - 1; i >= 0; i-- {
+		dir := dst.dirs[i]
+		info := dst.dirInfos[dir]
+
+		if err := os.Chmod(dir, info.mode); err != nil {
+			return err
+		}
+
+		if err := os.Chtimes(dir, time.Now(), info.modTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package batchssh
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func newTarReader(t *testing.T, entries []tarEntry) *tar.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, e := range entries {
+		header := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0o644,
+			Size:     int64(len(e.content)),
+		}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0o755
+		}
+		if e.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return tar.NewReader(&buf)
+}
+
+//nolint:funlen
+func TestExtractTar(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		// files with their contents expected in the dest dir.
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "files and dirs",
+			entries: []tarEntry{
+				{name: "etc/", typeflag: tar.TypeDir},
+				{name: "etc/hosts", typeflag: tar.TypeReg, content: "127.0.0.1 localhost"},
+				{name: "var/log/messages", typeflag: tar.TypeReg, content: "log"},
+			},
+			want: map[string]string{
+				"etc/hosts":        "127.0.0.1 localhost",
+				"var/log/messages": "log",
+			},
+		},
+		{
+			name: "absolute names are extracted into dest dir",
+			entries: []tarEntry{
+				{name: "/etc/passwd", typeflag: tar.TypeReg, content: "root"},
+			},
+			want: map[string]string{
+				"etc/passwd": "root",
+			},
+		},
+		{
+			name: "dot dot",
+			entries: []tarEntry{
+				{name: "../passwd", typeflag: tar.TypeReg, content: "root"},
+			},
+			wantErr: "invalid file name",
+		},
+		{
+			name: "dot dot in the middle",
+			entries: []tarEntry{
+				{name: "a/../../passwd", typeflag: tar.TypeReg, content: "root"},
+			},
+			wantErr: "invalid file name",
+		},
+		{
+			name: "symlinked parent dir",
+			entries: []tarEntry{
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+				{name: "a/passwd", typeflag: tar.TypeReg, content: "root"},
+			},
+			wantErr: "through symlink",
+		},
+		{
+			name: "symlinked grandparent dir",
+			entries: []tarEntry{
+				{name: "a/", typeflag: tar.TypeDir},
+				{name: "a/b", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+				{name: "a/b/c/passwd", typeflag: tar.TypeReg, content: "root"},
+			},
+			wantErr: "through symlink",
+		},
+		{
+			name: "hard link through symlinked parent dir",
+			entries: []tarEntry{
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+				{name: "passwd", typeflag: tar.TypeLink, linkname: "a/passwd"},
+			},
+			wantErr: "through symlink",
+		},
+		{
+			name: "symlink replaced by dir",
+			entries: []tarEntry{
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+				{name: "a/", typeflag: tar.TypeDir},
+				{name: "a/passwd", typeflag: tar.TypeReg, content: "root"},
+			},
+			want: map[string]string{
+				"a/passwd": "root",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			outside := filepath.Join(root, "outside")
+			dest := filepath.Join(root, "dest", "host1")
+
+			if err := os.Mkdir(outside, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(outside, "passwd"), []byte("original"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			entries := make([]tarEntry, len(tt.entries))
+			for i, e := range tt.entries {
+				e.linkname = strings.Replace(e.linkname, "OUTSIDE", outside, 1)
+				entries[i] = e
+			}
+
+			err := extractTar(newTarReader(t, entries), dest)
+
+			if p, _ := ioutil.ReadFile(filepath.Join(outside, "passwd")); string(p) != "original" {
+				t.Errorf("file out of dest dir is changed: %q", p)
+			}
+			if _, err := os.Stat(filepath.Join(root, "passwd")); err == nil {
+				t.Errorf("file out of dest dir is created")
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("extractTar() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, content := range tt.want {
+				p, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(p) != content {
+					t.Errorf("content of %s = %q, want %q", name, p, content)
+				}
+			}
+		})
+	}
+}
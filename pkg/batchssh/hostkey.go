@@ -0,0 +1,337 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package batchssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/serialt/gosible/pkg/log"
+)
+
+// Host key checking modes.
+const (
+	// HostKeyCheckingStrict only accepts host keys that already exist in known_hosts files.
+	HostKeyCheckingStrict = "strict"
+	// HostKeyCheckingAcceptNew accepts and records keys of unknown hosts (trust on first use),
+	// but still refuses keys that changed.
+	HostKeyCheckingAcceptNew = "accept-new"
+	// HostKeyCheckingOff does not verify host keys at all.
+	HostKeyCheckingOff = "off"
+)
+
+// HostKeyCheckingModes available.
+var HostKeyCheckingModes = []string{
+	HostKeyCheckingStrict,
+	HostKeyCheckingAcceptNew,
+	HostKeyCheckingOff,
+}
+
+// HostKeyChangedError is returned if the key presented by a host does not
+// match the key recorded in known_hosts files.
+type HostKeyChangedError struct {
+	Host string
+	Key  ssh.PublicKey
+	Want []knownhosts.KnownKey
+}
+
+func (e *HostKeyChangedError) Error() string {
+	var known []string
+	for _, v := range e.Want {
+		known = append(known, fmt.Sprintf("%s:%d", v.Filename, v.Line))
+	}
+
+	return fmt.Sprintf(
+		"host key verification failed: REMOTE HOST IDENTIFICATION HAS CHANGED for '%s', "+
+			"got %s key %s, offending entries: %s",
+		e.Host,
+		e.Key.Type(),
+		ssh.FingerprintSHA256(e.Key),
+		strings.Join(known, ","),
+	)
+}
+
+// HostKeyUnknownError is returned in strict mode if no key of the host is
+// recorded in known_hosts files.
+type HostKeyUnknownError struct {
+	Host string
+	Key  ssh.PublicKey
+}
+
+func (e *HostKeyUnknownError) Error() string {
+	return fmt.Sprintf(
+		"host key verification failed: no known_hosts entry for '%s' (%s key %s)",
+		e.Host,
+		e.Key.Type(),
+		ssh.FingerprintSHA256(e.Key),
+	)
+}
+
+// HostKeyChecker verifies host keys of target hosts and proxy servers
+// against known_hosts files.
+type HostKeyChecker struct {
+	mode  string
+	files []string
+
+	once     sync.Once
+	db       ssh.HostKeyCallback
+	loadErr  error
+	mu       sync.Mutex
+	accepted map[string]ssh.PublicKey
+}
+
+// NewHostKeyChecker with mode and known_hosts files. The first file is
+// where accept-new mode records new host keys, it defaults to
+// $HOME/.ssh/known_hosts if no files provided and the home dir is known.
+func NewHostKeyChecker(mode string, knownHostsFiles ...string) *HostKeyChecker {
+	var files []string
+	for _, f := range knownHostsFiles {
+		if f = expandHome(f); f != "" {
+			files = append(files, f)
+		}
+	}
+
+	if len(files) == 0 {
+		if f := DefaultKnownHostsFile(); f != "" {
+			files = append(files, f)
+		}
+	}
+
+	return &HostKeyChecker{
+		mode:     mode,
+		files:    files,
+		accepted: make(map[string]ssh.PublicKey),
+	}
+}
+
+// DefaultKnownHostsFile of current user. It returns "" if the home dir
+// of current user is unknown.
+func DefaultKnownHostsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.Debugf("Host Key: get home dir failed, skip default known_hosts file: %s", err)
+		return ""
+	}
+
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// Check host key, it implements ssh.HostKeyCallback.
+func (h *HostKeyChecker) Check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	switch h.mode {
+	case HostKeyCheckingOff:
+		return nil
+	case HostKeyCheckingStrict, HostKeyCheckingAcceptNew:
+	default:
+		return fmt.Errorf("invalid host key checking mode '%s', available modes: %s",
+			h.mode, strings.Join(HostKeyCheckingModes, ", "))
+	}
+
+	if err := h.load(); err != nil {
+		return err
+	}
+
+	err := h.db(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return fmt.Errorf("host key verification failed for '%s': %w", hostname, err)
+	}
+
+	if len(keyErr.Want) != 0 {
+		return &HostKeyChangedError{Host: hostname, Key: key, Want: keyErr.Want}
+	}
+
+	if h.mode == HostKeyCheckingStrict {
+		return &HostKeyUnknownError{Host: hostname, Key: key}
+	}
+
+	return h.acceptNew(hostname, key)
+}
+
+// HostKeyAlgorithms returns the key algorithms known for hostname, so that
+// the server presents a key that can be verified. It returns nil if the host
+// is unknown, which means any algorithms are acceptable.
+func (h *HostKeyChecker) HostKeyAlgorithms(hostname string) []string {
+	if h.mode == HostKeyCheckingOff || h.load() != nil {
+		return nil
+	}
+
+	var knownKeys []ssh.PublicKey
+
+	h.mu.Lock()
+	if key, ok := h.accepted[knownhosts.Normalize(hostname)]; ok {
+		knownKeys = append(knownKeys, key)
+	}
+	h.mu.Unlock()
+
+	// Checking with a placeholder key makes the known_hosts db list all
+	// keys it has for the host.
+	var keyErr *knownhosts.KeyError
+	if err := h.db(hostname, placeholderAddr, placeholderKey{}); errors.As(err, &keyErr) {
+		for _, v := range keyErr.Want {
+			knownKeys = append(knownKeys, v.Key)
+		}
+	}
+
+	var algos []string
+	for _, key := range knownKeys {
+		switch key.Type() {
+		case ssh.KeyAlgoRSA:
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algos = append(algos, key.Type())
+		}
+	}
+
+	return algos
+}
+
+func (h *HostKeyChecker) load() error {
+	h.once.Do(func() {
+		var files []string
+		for _, f := range h.files {
+			if _, err := os.Stat(f); err != nil {
+				if os.IsNotExist(err) {
+					log.Debugf("Host Key: known_hosts file '%s' not exist, skip", f)
+					continue
+				}
+
+				h.loadErr = fmt.Errorf("read known_hosts file '%s' failed: %w", f, err)
+				return
+			}
+
+			files = append(files, f)
+		}
+
+		if len(files) == 0 {
+			h.db = func(string, net.Addr, ssh.PublicKey) error {
+				return &knownhosts.KeyError{}
+			}
+			return
+		}
+
+		h.db, h.loadErr = knownhosts.New(files...)
+		if h.loadErr != nil {
+			h.loadErr = fmt.Errorf("parse known_hosts files failed: %w", h.loadErr)
+		}
+	})
+
+	return h.loadErr
+}
+
+func (h *HostKeyChecker) acceptNew(hostname string, key ssh.PublicKey) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	address := knownhosts.Normalize(hostname)
+
+	if accepted, ok := h.accepted[address]; ok {
+		if bytes.Equal(accepted.Marshal(), key.Marshal()) {
+			return nil
+		}
+
+		known := knownhosts.KnownKey{Key: accepted}
+		if len(h.files) != 0 {
+			known.Filename = h.files[0]
+		}
+
+		return &HostKeyChangedError{Host: hostname, Key: key, Want: []knownhosts.KnownKey{known}}
+	}
+
+	if len(h.files) == 0 {
+		h.accepted[address] = key
+
+		log.Warnf("Host Key: no known_hosts file to record key of '%s' (%s), accepted for this run only",
+			address, key.Type())
+
+		return nil
+	}
+
+	file := h.files[0]
+
+	//nolint:gomnd
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("record host key of '%s' failed: %w", hostname, err)
+	}
+
+	//nolint:gomnd
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("record host key of '%s' failed: %w", hostname, err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{address}, key)); err != nil {
+		return fmt.Errorf("record host key of '%s' failed: %w", hostname, err)
+	}
+
+	h.accepted[address] = key
+
+	log.Debugf("Host Key: permanently added '%s' (%s) to '%s'", address, key.Type(), file)
+
+	return nil
+}
+
+var placeholderAddr = &net.TCPAddr{IP: net.IPv4zero}
+
+// placeholderKey never matches any key in known_hosts files.
+type placeholderKey struct{}
+
+func (placeholderKey) Type() string {
+	return "placeholder"
+}
+
+func (placeholderKey) Marshal() []byte {
+	return []byte("placeholder")
+}
+
+func (placeholderKey) Verify([]byte, *ssh.Signature) error {
+	return errors.New("placeholder key can not verify")
+}
+
+func expandHome(file string) string {
+	file = strings.TrimSpace(file)
+	if strings.HasPrefix(file, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return file
+		}
+
+		file = filepath.Join(home, file[2:])
+	}
+
+	return file
+}
@@ -0,0 +1,173 @@
+package batchssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+//nolint:funlen
+func TestHostKeyCheckerCheck(t *testing.T) {
+	knownKey := newTestPublicKey(t)
+	otherKey := newTestPublicKey(t)
+
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	tests := []struct {
+		name    string
+		mode    string
+		key     ssh.PublicKey
+		host    string
+		wantErr interface{}
+		// whether the key is appended to known_hosts file after checking.
+		wantRecorded bool
+	}{
+		{name: "strict known", mode: HostKeyCheckingStrict, host: "10.0.0.1:22", key: knownKey},
+		{name: "strict changed", mode: HostKeyCheckingStrict, host: "10.0.0.1:22", key: otherKey,
+			wantErr: &HostKeyChangedError{}},
+		{name: "strict unknown", mode: HostKeyCheckingStrict, host: "10.0.0.2:22", key: otherKey,
+			wantErr: &HostKeyUnknownError{}},
+		{name: "accept-new known", mode: HostKeyCheckingAcceptNew, host: "10.0.0.1:22", key: knownKey},
+		{name: "accept-new changed", mode: HostKeyCheckingAcceptNew, host: "10.0.0.1:22", key: otherKey,
+			wantErr: &HostKeyChangedError{}},
+		{name: "accept-new unknown", mode: HostKeyCheckingAcceptNew, host: "10.0.0.2:2222", key: otherKey,
+			wantRecorded: true},
+		{name: "off changed", mode: HostKeyCheckingOff, host: "10.0.0.1:22", key: otherKey},
+		{name: "invalid mode", mode: "yes", host: "10.0.0.1:22", key: knownKey, wantErr: errors.New("")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "known_hosts")
+			line := knownhosts.Line([]string{"10.0.0.1"}, knownKey) + "\n"
+			if err := ioutil.WriteFile(file, []byte(line), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := NewHostKeyChecker(tt.mode, file).Check(tt.host, addr, tt.key)
+
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("Check() error = %v", err)
+				}
+			case *HostKeyChangedError:
+				if !errors.As(err, &want) {
+					t.Fatalf("Check() error = %v, want HostKeyChangedError", err)
+				}
+			case *HostKeyUnknownError:
+				if !errors.As(err, &want) {
+					t.Fatalf("Check() error = %v, want HostKeyUnknownError", err)
+				}
+			default:
+				if err == nil {
+					t.Fatal("Check() error = nil, want error")
+				}
+			}
+
+			p, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := len(p) > len(line); got != tt.wantRecorded {
+				t.Errorf("key recorded = %v, want %v, known_hosts:\n%s", got, tt.wantRecorded, p)
+			}
+
+			if tt.wantRecorded {
+				// The recorded key is accepted by a new checker in strict mode.
+				if err := NewHostKeyChecker(HostKeyCheckingStrict, file).Check(tt.host, addr, tt.key); err != nil {
+					t.Errorf("Check() of recorded key error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestHostKeyCheckerAcceptNewChanged(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	h := NewHostKeyChecker(HostKeyCheckingAcceptNew, file)
+
+	if err := h.Check("10.0.0.1:22", addr, newTestPublicKey(t)); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	// Another key of the same host in the same run is refused.
+	var changed *HostKeyChangedError
+	if err := h.Check("10.0.0.1:22", addr, newTestPublicKey(t)); !errors.As(err, &changed) {
+		t.Errorf("Check() error = %v, want HostKeyChangedError", err)
+	}
+}
+
+func TestHostKeyCheckerWithoutHome(t *testing.T) {
+	t.Setenv("HOME", "")
+
+	if got := DefaultKnownHostsFile(); got != "" {
+		t.Errorf("DefaultKnownHostsFile() = %q, want empty", got)
+	}
+
+	h := NewHostKeyChecker(HostKeyCheckingAcceptNew)
+	if len(h.files) != 0 {
+		t.Fatalf("files = %v, want none", h.files)
+	}
+
+	// The new key is accepted for this run only, nothing is written.
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	if err := h.Check("10.0.0.1:22", addr, newTestPublicKey(t)); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	key := newTestPublicKey(t)
+
+	if err := ioutil.WriteFile(file, []byte(knownhosts.Line([]string{"10.0.0.1"}, key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		mode string
+		host string
+		want []string
+	}{
+		{name: "known host", mode: HostKeyCheckingStrict, host: "10.0.0.1:22", want: []string{ssh.KeyAlgoED25519}},
+		{name: "unknown host", mode: HostKeyCheckingStrict, host: "10.0.0.2:22", want: nil},
+		{name: "checking off", mode: HostKeyCheckingOff, host: "10.0.0.1:22", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewHostKeyChecker(tt.mode, file).HostKeyAlgorithms(tt.host); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HostKeyAlgorithms() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
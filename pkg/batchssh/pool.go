@@ -0,0 +1,333 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package batchssh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/serialt/gosible/pkg/log"
+)
+
+// connPool caches ssh connections keyed by user@host:port, so that all
+// operations on the same host share one connection.
+type connPool struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	conns map[string]*pooledConn
+
+	// maxOpen is the max number of open connections to target hosts,
+	// 0 means no limit. Kept connections to jump hosts are not counted,
+	// otherwise a target host being dialed would wait for a free connection
+	// to its own jump host.
+	maxOpen int
+	// idleTimeout is how long an unused connection is kept,
+	// 0 means closing it as soon as it is not used.
+	idleTimeout time.Duration
+
+	// ctx is done when the pool is closed. Connections are dialed with it
+	// instead of the ctx of the caller, because they are shared by other
+	// callers that should not fail when the first caller is cancelled.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	janitorOnce sync.Once
+	done        chan struct{}
+	closed      bool
+}
+
+type pooledConn struct {
+	key      string
+	client   *ssh.Client
+	err      error
+	ready    chan struct{}
+	refs     int
+	lastUsed time.Time
+
+	// keep the connection after it is unused, until it is idle for
+	// idleTimeout or the pool is closed.
+	keep bool
+}
+
+func newConnPool(maxOpen int, idleTimeout time.Duration) *connPool {
+	p := &connPool{
+		conns:       make(map[string]*pooledConn),
+		maxOpen:     maxOpen,
+		idleTimeout: idleTimeout,
+		done:        make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	return p
+}
+
+// get a connection by key, dial a new one if there is no cached connection.
+// The returned release function must be called when the connection is no
+// longer used by the caller. ctx only stops the caller waiting, dial should
+// use p.ctx so that the connection outlives the caller.
+func (p *connPool) get(ctx context.Context, key string, dial func() (*ssh.Client, error)) (*ssh.Client, func(), error) {
+	return p.getConn(ctx, key, false, dial)
+}
+
+// getKeep is like get, but the connection is kept after it is released even
+// if idleTimeout is 0.
+func (p *connPool) getKeep(ctx context.Context, key string, dial func() (*ssh.Client, error)) (*ssh.Client, func(), error) {
+	return p.getConn(ctx, key, true, dial)
+}
+
+func (p *connPool) getConn(
+	ctx context.Context,
+	key string,
+	keep bool,
+	dial func() (*ssh.Client, error),
+) (*ssh.Client, func(), error) {
+	p.mu.Lock()
+
+	// stop is closed when getConn returns, it stops the goroutine that wakes
+	// waiters up when ctx is done.
+	var stop chan struct{}
+	defer func() {
+		if stop != nil {
+			close(stop)
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			p.mu.Unlock()
+			return nil, nil, err
+		}
+
+		if pc, ok := p.conns[key]; ok {
+			pc.refs++
+			p.mu.Unlock()
+
+			select {
+			case <-pc.ready:
+			case <-ctx.Done():
+				p.release(pc)
+				return nil, nil, ctx.Err()
+			}
+
+			if pc.err != nil {
+				p.release(pc)
+				return nil, nil, pc.err
+			}
+
+			log.Debugf("Conn Pool: reuse connection of '%s'", key)
+
+			return pc.client, func() { p.release(pc) }, nil
+		}
+
+		if keep || p.maxOpen <= 0 || p.openLocked() < p.maxOpen || p.evictIdleLocked() {
+			break
+		}
+
+		if stop == nil {
+			stop = make(chan struct{})
+			go p.wakeOnDone(ctx, stop)
+		}
+
+		p.cond.Wait()
+	}
+
+	// The dialing goroutine holds a ref until the dial finished, so that the
+	// connection is closed or kept by release as usual if all callers have
+	// given up waiting.
+	pc := &pooledConn{
+		key:   key,
+		ready: make(chan struct{}),
+		refs:  2,
+		keep:  keep,
+	}
+	p.conns[key] = pc
+	p.mu.Unlock()
+
+	go p.dial(pc, dial)
+
+	select {
+	case <-pc.ready:
+	case <-ctx.Done():
+		p.release(pc)
+		return nil, nil, ctx.Err()
+	}
+
+	if pc.err != nil {
+		p.release(pc)
+		return nil, nil, pc.err
+	}
+
+	return pc.client, func() { p.release(pc) }, nil
+}
+
+func (p *connPool) dial(pc *pooledConn, dial func() (*ssh.Client, error)) {
+	client, err := dial()
+
+	p.mu.Lock()
+	pc.client, pc.err = client, err
+	close(pc.ready)
+
+	if err != nil {
+		delete(p.conns, pc.key)
+		p.cond.Broadcast()
+	}
+	p.mu.Unlock()
+
+	if err == nil {
+		go func() {
+			_ = client.Wait()
+
+			p.mu.Lock()
+			if p.conns[pc.key] == pc {
+				delete(p.conns, pc.key)
+				p.cond.Broadcast()
+			}
+			p.mu.Unlock()
+		}()
+
+		if p.idleTimeout > 0 {
+			p.janitorOnce.Do(func() { go p.janitor() })
+		}
+	}
+
+	p.release(pc)
+}
+
+// wakeOnDone wakes all waiters up when ctx is done, so that the waiters of
+// a cancelled run return instead of waiting for a free connection forever.
+func (p *connPool) wakeOnDone(ctx context.Context, stop <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+		p.mu.Lock()
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	case <-stop:
+	}
+}
+
+func (p *connPool) release(pc *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pc.refs--
+	pc.lastUsed = time.Now()
+
+	if pc.refs == 0 && pc.client != nil && ((p.idleTimeout <= 0 && !pc.keep) || p.closed) {
+		p.closeLocked(pc)
+	}
+
+	p.cond.Broadcast()
+}
+
+// openLocked returns the number of connections counted by maxOpen,
+// including the ones being dialed.
+func (p *connPool) openLocked() int {
+	var open int
+	for _, pc := range p.conns {
+		if !pc.keep {
+			open++
+		}
+	}
+
+	return open
+}
+
+// evictIdleLocked closes the least recently used idle connection counted by
+// maxOpen, it returns false if all of them are in use.
+func (p *connPool) evictIdleLocked() bool {
+	var oldest *pooledConn
+	for _, pc := range p.conns {
+		if pc.keep || pc.refs != 0 || pc.client == nil {
+			continue
+		}
+
+		if oldest == nil || pc.lastUsed.Before(oldest.lastUsed) {
+			oldest = pc
+		}
+	}
+
+	if oldest == nil {
+		return false
+	}
+
+	log.Debugf("Conn Pool: max open connections %d reached, close idle connection of '%s'", p.maxOpen, oldest.key)
+	p.closeLocked(oldest)
+
+	return true
+}
+
+func (p *connPool) closeLocked(pc *pooledConn) {
+	if p.conns[pc.key] == pc {
+		delete(p.conns, pc.key)
+	}
+
+	if err := pc.client.Close(); err != nil {
+		log.Debugf("Conn Pool: close connection of '%s' failed: %s", pc.key, err)
+	}
+}
+
+func (p *connPool) janitor() {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			for _, pc := range p.conns {
+				if pc.refs == 0 && pc.client != nil && now.Sub(pc.lastUsed) >= p.idleTimeout {
+					log.Debugf("Conn Pool: close idle connection of '%s'", pc.key)
+					p.closeLocked(pc)
+				}
+			}
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// close all idle connections, connections in use are closed when released.
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.done)
+	p.cancel()
+
+	for _, pc := range p.conns {
+		if pc.refs == 0 && pc.client != nil {
+			p.closeLocked(pc)
+		}
+	}
+}
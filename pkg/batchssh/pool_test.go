@@ -0,0 +1,214 @@
+package batchssh
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConnPoolReuse(t *testing.T) {
+	p := newConnPool(0, time.Hour)
+	defer p.close()
+
+	dials := 0
+	dial := func() (*ssh.Client, error) {
+		dials++
+		return newTestSSHClient(t, func(ch ssh.NewChannel) {}), nil
+	}
+
+	client1, release1, err := p.get(context.Background(), "host1", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release1()
+
+	client2, release2, err := p.get(context.Background(), "host1", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release2()
+
+	if client1 != client2 || dials != 1 {
+		t.Errorf("connection is not reused, dials = %d", dials)
+	}
+
+	wantErr := errors.New("dial failed")
+	if _, _, err := p.get(context.Background(), "host2", func() (*ssh.Client, error) {
+		return nil, wantErr
+	}); !errors.Is(err, wantErr) {
+		t.Errorf("get() error = %v, want %v", err, wantErr)
+	}
+
+	if _, ok := p.conns["host2"]; ok {
+		t.Errorf("failed connection is cached")
+	}
+}
+
+func TestConnPoolCancelDialing(t *testing.T) {
+	p := newConnPool(0, 0)
+	defer p.close()
+
+	dialing := make(chan struct{})
+	proceed := make(chan struct{})
+	dial := func() (*ssh.Client, error) {
+		close(dialing)
+		<-proceed
+		return newTestSSHClient(t, func(ch ssh.NewChannel) {}), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := p.get(ctx, "host1", dial)
+		errc <- err
+	}()
+	<-dialing
+
+	type getResult struct {
+		client  *ssh.Client
+		release func()
+		err     error
+	}
+	results := make(chan getResult, 1)
+	go func() {
+		client, release, err := p.get(context.Background(), "host1", dial)
+		results <- getResult{client, release, err}
+	}()
+
+	// The first caller gives up, the sharer still gets the connection.
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("get() error = %v, want %v", err, context.Canceled)
+	}
+	close(proceed)
+
+	r := <-results
+	if r.err != nil || r.client == nil {
+		t.Fatalf("get() of sharer error = %v", r.err)
+	}
+	r.release()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.conns["host1"]; ok {
+		t.Errorf("unused connection is not closed")
+	}
+}
+
+func TestConnPoolMaxOpen(t *testing.T) {
+	dial := func() (*ssh.Client, error) {
+		return newTestSSHClient(t, func(ch ssh.NewChannel) {}), nil
+	}
+
+	t.Run("evict idle connection", func(t *testing.T) {
+		p := newConnPool(1, time.Hour)
+		defer p.close()
+
+		_, release, err := p.get(context.Background(), "host1", dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+
+		_, release, err = p.get(context.Background(), "host2", dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+
+		if _, ok := p.conns["host1"]; ok || len(p.conns) != 1 {
+			t.Errorf("idle connection is not evicted, open connections: %d", len(p.conns))
+		}
+	})
+
+	t.Run("wait for released connection", func(t *testing.T) {
+		p := newConnPool(1, 0)
+		defer p.close()
+
+		_, release, err := p.get(context.Background(), "host1", dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.AfterFunc(50*time.Millisecond, release)
+
+		_, release, err = p.get(context.Background(), "host2", dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	})
+
+	t.Run("dial through jump host", func(t *testing.T) {
+		p := newConnPool(1, 0)
+		defer p.close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// targets are dialed through the kept connection of the jump host,
+		// as getProxyClient does.
+		dialViaProxy := func() (*ssh.Client, error) {
+			_, release, err := p.getKeep(ctx, "proxy", dial)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+
+			return dial()
+		}
+
+		errc := make(chan error, 2)
+		for _, key := range []string{"host1 via proxy", "host2 via proxy"} {
+			go func(key string) {
+				_, release, err := p.get(ctx, key, dialViaProxy)
+				if err == nil {
+					time.Sleep(10 * time.Millisecond)
+					release()
+				}
+				errc <- err
+			}(key)
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := <-errc; err != nil {
+				t.Errorf("get() error = %v", err)
+			}
+		}
+
+		if pc, ok := p.conns["proxy"]; !ok || pc.client == nil {
+			t.Errorf("connection of jump host is not kept")
+		}
+	})
+
+	t.Run("cancel waiting", func(t *testing.T) {
+		p := newConnPool(1, 0)
+		defer p.close()
+
+		_, release, err := p.get(context.Background(), "host1", dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		errc := make(chan error, 1)
+		go func() {
+			_, _, err := p.get(ctx, "host2", dial)
+			errc <- err
+		}()
+
+		select {
+		case err := <-errc:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("get() error = %v, want %v", err, context.Canceled)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("get() is not woken up after ctx is cancelled")
+		}
+	})
+}
@@ -0,0 +1,203 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package batchssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/serialt/gosible/pkg/log"
+)
+
+// ProxyHop is a jump host, target hosts are connected through a chain of
+// jump hosts like ssh option ProxyJump.
+type ProxyHop struct {
+	Host     string
+	Port     int
+	User     string
+	SSHAuths []ssh.AuthMethod
+}
+
+func (h *ProxyHop) String() string {
+	return fmt.Sprintf("%s@%s", h.User, net.JoinHostPort(h.Host, strconv.Itoa(h.Port)))
+}
+
+// ParseProxyJump parses jump hosts like '[user@]host[:port],[user@]host[:port]'.
+// User and port of a hop are left empty if not given.
+func ParseProxyJump(spec string) ([]*ProxyHop, error) {
+	var hops []*ProxyHop
+
+	for _, v := range strings.Split(spec, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil, fmt.Errorf("invalid proxy '%s': empty jump host", spec)
+		}
+
+		hop := &ProxyHop{}
+
+		if i := strings.LastIndex(v, "@"); i >= 0 {
+			hop.User = v[:i]
+			v = v[i+1:]
+		}
+
+		hop.Host = v
+		if host, port, err := net.SplitHostPort(v); err == nil {
+			hop.Host = host
+
+			hop.Port, err = strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy '%s': invalid port '%s'", spec, port)
+			}
+		}
+
+		hop.Host = strings.Trim(hop.Host, "[]")
+		if hop.Host == "" {
+			return nil, fmt.Errorf("invalid proxy '%s': empty jump host", spec)
+		}
+
+		hops = append(hops, hop)
+	}
+
+	return hops, nil
+}
+
+// proxyChain is the string of jump hosts like 'user@host1:22,user@host2:22'.
+func proxyChain(proxies []*ProxyHop) string {
+	hops := make([]string, 0, len(proxies))
+	for _, v := range proxies {
+		hops = append(hops, v.String())
+	}
+
+	return strings.Join(hops, ",")
+}
+
+// getProxyClient returns the connection to the last hop of proxies. Each hop
+// is dialed through its previous hop lazily, and the connections are shared
+// by all target hosts behind the same hops until the client is closed.
+// ctx only bounds the wait of the caller, hops are dialed with the ctx of the
+// pool and bounded by the connect timeout.
+func (c *Client) getProxyClient(ctx context.Context, proxies []*ProxyHop) (*ssh.Client, func(), error) {
+	key := "proxy:" + proxyChain(proxies)
+
+	return c.pool.getKeep(ctx, key, func() (*ssh.Client, error) {
+		dialCtx := c.pool.ctx
+
+		hop := proxies[len(proxies)-1]
+		addr := net.JoinHostPort(hop.Host, strconv.Itoa(hop.Port))
+
+		var (
+			via     *ssh.Client
+			release = func() {}
+		)
+		if len(proxies) > 1 {
+			var err error
+			via, release, err = c.getProxyClient(dialCtx, proxies[:len(proxies)-1])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		log.Debugf("Proxy: connect to jump host '%s'", hop)
+
+		client, err := c.dialVia(dialCtx, via, addr, c.sshConfig(hop.User, hop.SSHAuths, addr))
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("connect to proxy %s failed: %w", hop, err)
+		}
+
+		go func() {
+			_ = client.Wait()
+			release()
+		}()
+
+		return client, nil
+	})
+}
+
+// dialVia connects addr through via, or directly if via is nil.
+func (c *Client) dialVia(ctx context.Context, via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	if via != nil {
+		conn, err = c.dialThrough(ctx, via, addr)
+	} else {
+		dialer := net.Dialer{Timeout: c.ConnTimeout}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newClientConn(ctx, conn, addr, config)
+}
+
+// dialThrough dials addr through the jump host via. ssh.Client.Dial takes no
+// context or timeout, so it is given up when ctx is done or the connect
+// timeout is reached, and the connection is closed if it arrives later.
+func (c *Client) dialThrough(ctx context.Context, via *ssh.Client, addr string) (net.Conn, error) {
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	results := make(chan dialResult, 1)
+	go func() {
+		conn, err := via.Dial("tcp", addr)
+		results <- dialResult{conn, err}
+	}()
+
+	var timeout <-chan time.Time
+	if c.ConnTimeout > 0 {
+		timer := time.NewTimer(c.ConnTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	giveUp := func() {
+		go func() {
+			if r := <-results; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+	}
+
+	select {
+	case r := <-results:
+		return r.conn, r.err
+	case <-ctx.Done():
+		giveUp()
+		return nil, ctx.Err()
+	case <-timeout:
+		giveUp()
+		return nil, fmt.Errorf("dial tcp %s: i/o timeout", addr)
+	}
+}
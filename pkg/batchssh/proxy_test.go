@@ -0,0 +1,261 @@
+package batchssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseProxyJump(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []*ProxyHop
+		wantErr bool
+	}{
+		{
+			spec: "bastion",
+			want: []*ProxyHop{{Host: "bastion"}},
+		},
+		{
+			spec: "ops@bastion1:2222, jump2",
+			want: []*ProxyHop{{Host: "bastion1", Port: 2222, User: "ops"}, {Host: "jump2"}},
+		},
+		{
+			spec: "user@domain@[::1]:22",
+			want: []*ProxyHop{{Host: "::1", Port: 22, User: "user@domain"}},
+		},
+		{spec: "bastion,", wantErr: true},
+		{spec: "ops@", wantErr: true},
+		{spec: "bastion:port", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseProxyJump(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseProxyJump() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseProxyJump() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnKey(t *testing.T) {
+	host := &Host{Host: "10.0.0.1", Port: 22, User: "root"}
+
+	bastion1 := []*ProxyHop{{Host: "bastion1", Port: 22, User: "ops"}}
+	bastion2 := []*ProxyHop{{Host: "bastion2", Port: 22, User: "ops"}}
+	chain := []*ProxyHop{{Host: "bastion1", Port: 22, User: "ops"}, {Host: "jump", Port: 2222, User: "ops"}}
+
+	tests := []struct {
+		name    string
+		proxies []*ProxyHop
+		want    string
+	}{
+		{name: "direct", want: "root@10.0.0.1:22"},
+		{name: "one hop", proxies: bastion1, want: "root@10.0.0.1:22 via ops@bastion1:22"},
+		{name: "another hop", proxies: bastion2, want: "root@10.0.0.1:22 via ops@bastion2:22"},
+		{name: "chain", proxies: chain, want: "root@10.0.0.1:22 via ops@bastion1:22,ops@jump:2222"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := connKey(host, tt.proxies); got != tt.want {
+				t.Errorf("connKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestSSHClient returns a client connected to a local ssh server,
+// channels opened by the client are passed to handle.
+func newTestSSHClient(t *testing.T, handle func(ssh.NewChannel)) *ssh.Client {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		serverConn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		_, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+
+		for ch := range chans {
+			go handle(ch)
+		}
+	}()
+
+	config := &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()} //nolint:gosec
+	client, err := ssh.Dial("tcp", ln.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestDialThrough(t *testing.T) {
+	// the jump host never answers requests of connecting target hosts.
+	hang := make(chan struct{})
+	t.Cleanup(func() { close(hang) })
+
+	via := newTestSSHClient(t, func(ch ssh.NewChannel) { <-hang })
+
+	t.Run("timeout", func(t *testing.T) {
+		c := NewClient(WithConnTimeout(50 * time.Millisecond))
+
+		start := time.Now()
+		_, err := c.dialThrough(context.Background(), via, "10.0.0.1:22")
+		if err == nil || !strings.Contains(err.Error(), "timeout") {
+			t.Fatalf("dialThrough() error = %v, want timeout", err)
+		}
+
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("dialThrough() returned after %s", elapsed)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		c := NewClient()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		_, err := c.dialThrough(ctx, via, "10.0.0.1:22")
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("dialThrough() error = %v, want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		rejecting := newTestSSHClient(t, func(ch ssh.NewChannel) {
+			_ = ch.Reject(ssh.ConnectionFailed, "connection refused")
+		})
+
+		_, err := NewClient().dialThrough(context.Background(), rejecting, "10.0.0.1:22")
+		if err == nil || !strings.Contains(err.Error(), "connection refused") {
+			t.Fatalf("dialThrough() error = %v, want connection refused", err)
+		}
+	})
+}
+
+func TestGetProxyClientCancelFirstCaller(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	// the jump host is slow to handshake, so the first caller gives up while
+	// the connection is being dialed.
+	go func() {
+		for {
+			serverConn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+
+				_, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+
+				for ch := range chans {
+					_ = ch.Reject(ssh.Prohibited, "")
+				}
+			}()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	hop := &ProxyHop{Host: "127.0.0.1", Port: addr.Port, User: "test"}
+
+	c := NewClient(
+		WithConnTimeout(5*time.Second),
+		WithHostKeyChecker(NewHostKeyChecker(HostKeyCheckingOff)),
+	)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := c.getProxyClient(ctx, []*ProxyHop{hop})
+		errc <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	type getResult struct {
+		release func()
+		err     error
+	}
+	results := make(chan getResult, 1)
+	go func() {
+		_, release, err := c.getProxyClient(context.Background(), []*ProxyHop{hop})
+		results <- getResult{release, err}
+	}()
+
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("getProxyClient() error = %v, want %v", err, context.Canceled)
+	}
+
+	r := <-results
+	if r.err != nil {
+		t.Fatalf("getProxyClient() of sharer error = %v", r.err)
+	}
+	r.release()
+}
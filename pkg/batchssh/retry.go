@@ -0,0 +1,89 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package batchssh
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// maxRetryDelay limits the backoff of retries.
+const maxRetryDelay = 30 * time.Second
+
+// ConnError is returned if connecting to the host failed.
+type ConnError struct {
+	Err error
+}
+
+func (e *ConnError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConnError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether connecting again may succeed, failures of
+// authentication and host key verification are not temporary.
+func (e *ConnError) Temporary() bool {
+	var (
+		changed *HostKeyChangedError
+		unknown *HostKeyUnknownError
+	)
+	if errors.As(e.Err, &changed) || errors.As(e.Err, &unknown) {
+		return false
+	}
+
+	// The ssh package formats handshake errors with '%v', so they can only
+	// be told apart by messages.
+	msg := e.Err.Error()
+	for _, s := range []string{"unable to authenticate", "host key", "no supported methods"} {
+		if strings.Contains(msg, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// retryable reports whether the host failed with err should be run again.
+func retryable(err error) bool {
+	var connErr *ConnError
+
+	return errors.As(err, &connErr) && connErr.Temporary()
+}
+
+// retryDelay before the attempt, it doubles on each retry.
+func (c *Client) retryDelay(attempt int) time.Duration {
+	delay := c.RetryBackoff
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	return delay
+}
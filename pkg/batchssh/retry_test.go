@@ -0,0 +1,72 @@
+package batchssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	key := placeholderKey{}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "connection refused", err: &ConnError{Err: syscall.ECONNREFUSED}, want: true},
+		{name: "timeout", err: &ConnError{Err: errors.New("dial tcp 10.0.0.1:22: i/o timeout")}, want: true},
+		{name: "handshake eof", err: &ConnError{Err: fmt.Errorf("ssh: handshake failed: %w", io.EOF)}, want: true},
+		{name: "wrapped conn error", err: fmt.Errorf("host1: %w", &ConnError{Err: io.EOF}), want: true},
+		{
+			name: "authentication failed",
+			err: &ConnError{Err: errors.New(
+				"ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password]")},
+			want: false,
+		},
+		{name: "host key changed", err: &ConnError{Err: &HostKeyChangedError{Host: "host1", Key: key}}, want: false},
+		{name: "host key unknown", err: &ConnError{Err: &HostKeyUnknownError{Host: "host1", Key: key}}, want: false},
+		{
+			name: "host key message",
+			err:  &ConnError{Err: errors.New("ssh: handshake failed: ssh: host key mismatch")},
+			want: false,
+		},
+		{name: "not a conn error", err: errors.New("Process exited with status 1"), want: false},
+		{name: "nil", err: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	c := NewClient(WithRetries(5, time.Second))
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: 2 * time.Second},
+		{attempt: 3, want: 4 * time.Second},
+		{attempt: 5, want: 16 * time.Second},
+		{attempt: 6, want: maxRetryDelay},
+		{attempt: 100, want: maxRetryDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			if got := c.retryDelay(tt.attempt); got != tt.want {
+				t.Errorf("retryDelay(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
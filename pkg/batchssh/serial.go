@@ -0,0 +1,209 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package batchssh
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/serialt/gosible/pkg/log"
+)
+
+// BatchSizes splits total hosts into batches by serial sizes like "1",
+// "10%", the last size is used for all remaining batches. Sizes in
+// percentage are at least 1. All hosts are in one batch if serial is empty.
+func BatchSizes(serial []string, total int) ([]int, error) {
+	var sizes []int
+	if total <= 0 {
+		return sizes, nil
+	}
+
+	if len(serial) == 0 {
+		return []int{total}, nil
+	}
+
+	parsed := make([]int, 0, len(serial))
+	for _, s := range serial {
+		s = strings.TrimSpace(s)
+
+		if strings.HasSuffix(s, "%") {
+			percent, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+			if err != nil || percent <= 0 || percent > 100 {
+				return nil, fmt.Errorf("invalid batch size '%s' - must be a percentage in (0%%, 100%%]", s)
+			}
+
+			size := total * percent / 100
+			if size < 1 {
+				size = 1
+			}
+			parsed = append(parsed, size)
+
+			continue
+		}
+
+		size, err := strconv.Atoi(s)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid batch size '%s' - must be a number gather than 0 or a percentage", s)
+		}
+		parsed = append(parsed, size)
+	}
+
+	for i, left := 0, total; left > 0; i++ {
+		size := parsed[len(parsed)-1]
+		if i < len(parsed) {
+			size = parsed[i]
+		}
+
+		if size > left {
+			size = left
+		}
+
+		sizes = append(sizes, size)
+		left -= size
+	}
+
+	return sizes, nil
+}
+
+// runBatches runs hosts in sequential batches of the sizes returned by
+// BatchSizes, and stops running subsequent batches if too many hosts failed.
+func (c *Client) runBatches(ctx context.Context, hosts []*Host, sizes []int, sshTask Task, resCh chan<- *Result) {
+	// It is set once a host failed with c.AnyErrorsFatal.
+	var fatal int32
+
+	start := 0
+	for i, size := range sizes {
+		batch := hosts[start : start+size]
+
+		if i > 0 && c.BeforeBatch != nil && !c.BeforeBatch(ctx, i+1, len(sizes), batch) {
+			skipHosts(hosts[start:], fmt.Sprintf("skipped, stopped before batch %d/%d", i+1, len(sizes)), resCh)
+			return
+		}
+
+		if len(sizes) > 1 {
+			log.Debugf("Serial: run batch %d/%d of %d hosts", i+1, len(sizes), size)
+		}
+
+		failed := c.runBatch(ctx, batch, sshTask, resCh, &fatal)
+		start += size
+
+		// Hosts are cancelled by runTask.
+		if ctx.Err() != nil || start == len(hosts) {
+			continue
+		}
+
+		reason := ""
+		switch {
+		case c.AnyErrorsFatal && failed > 0:
+			reason = fmt.Sprintf("skipped, %d hosts failed in batch %d/%d and any errors are fatal",
+				failed, i+1, len(sizes))
+		case failed*100 > c.MaxFailPercentage*size:
+			reason = fmt.Sprintf("skipped, %d of %d hosts failed in batch %d/%d, more than %d%%",
+				failed, size, i+1, len(sizes), c.MaxFailPercentage)
+		}
+
+		if reason != "" {
+			skipHosts(hosts[start:], reason, resCh)
+			return
+		}
+	}
+}
+
+// runBatch runs hosts concurrently and returns the number of failed hosts.
+// With c.AnyErrorsFatal, hosts not started yet are skipped once a host failed.
+func (c *Client) runBatch(
+	ctx context.Context,
+	hosts []*Host,
+	sshTask Task,
+	resCh chan<- *Result,
+	fatal *int32,
+) int {
+	hostCh := make(chan *Host)
+	go func() {
+		defer close(hostCh)
+		for _, host := range hosts {
+			hostCh <- host
+		}
+	}()
+
+	var (
+		mu     sync.Mutex
+		failed int
+		wg     sync.WaitGroup
+	)
+
+	workers := c.Concurrency
+	if workers > len(hosts) {
+		workers = len(hosts)
+	}
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for host := range hostCh {
+				if atomic.LoadInt32(fatal) == 1 && ctx.Err() == nil {
+					skipHosts([]*Host{host}, "skipped, a host failed and any errors are fatal", resCh)
+					continue
+				}
+
+				result := c.runTask(ctx, host, sshTask)
+				if result.Status != SuccessIdentifier {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+
+					if c.AnyErrorsFatal {
+						atomic.StoreInt32(fatal, 1)
+					}
+				}
+
+				resCh <- result
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return failed
+}
+
+func skipHosts(hosts []*Host, message string, resCh chan<- *Result) {
+	for _, host := range hosts {
+		now := time.Now()
+		resCh <- &Result{
+			Host:      host.Alias,
+			Status:    SkippedIdentifier,
+			Message:   message,
+			ExitCode:  -1,
+			StartTime: now,
+			EndTime:   now,
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package batchssh
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBatchSizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		serial  []string
+		total   int
+		want    []int
+		wantErr bool
+	}{
+		{name: "no serial", total: 5, want: []int{5}},
+		{name: "no hosts", serial: []string{"2"}, total: 0, want: nil},
+		{name: "number", serial: []string{"2"}, total: 5, want: []int{2, 2, 1}},
+		{name: "number more than hosts", serial: []string{"10"}, total: 3, want: []int{3}},
+		{name: "percentage", serial: []string{"30%"}, total: 10, want: []int{3, 3, 3, 1}},
+		{name: "percentage at least 1", serial: []string{"10%"}, total: 3, want: []int{1, 1, 1}},
+		{name: "100 percent", serial: []string{"100%"}, total: 4, want: []int{4}},
+		{name: "list", serial: []string{"1", "2", "3"}, total: 6, want: []int{1, 2, 3}},
+		{name: "trailing step", serial: []string{"1", "2"}, total: 7, want: []int{1, 2, 2, 2}},
+		{name: "mixed with spaces", serial: []string{" 1", "50% "}, total: 9, want: []int{1, 4, 4}},
+		{name: "percentage over 100", serial: []string{"101%"}, total: 5, wantErr: true},
+		{name: "zero percent", serial: []string{"0%"}, total: 5, wantErr: true},
+		{name: "zero", serial: []string{"0"}, total: 5, wantErr: true},
+		{name: "negative", serial: []string{"-1"}, total: 5, wantErr: true},
+		{name: "not a number", serial: []string{"1", "a"}, total: 5, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BatchSizes(tt.serial, tt.total)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BatchSizes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BatchSizes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchRunInvalidSerial(t *testing.T) {
+	c := NewClient(WithSerial([]string{"150%"}))
+	defer c.Close()
+
+	task := &fakeTask{}
+	hosts := newFakeHosts("ok1", "ok2")
+
+	resCh, err := c.BatchRunContext(context.Background(), hosts, task)
+	if err == nil || !strings.Contains(err.Error(), "invalid serial") {
+		t.Fatalf("BatchRunContext() error = %v, want invalid serial", err)
+	}
+
+	if resCh != nil {
+		t.Errorf("BatchRunContext() returned results for invalid serial")
+	}
+
+	// BatchRun reports the error by results.
+	results := collectResults(t, c.BatchRun(hosts, task))
+	for _, v := range hosts {
+		if r := results[v.Alias]; r == nil || r.Status != FailedIdentifier || !strings.Contains(r.Message, "invalid serial") {
+			t.Errorf("result of '%s' = %+v, want invalid serial", v.Alias, r)
+		}
+	}
+
+	if ran := task.ranHosts(); len(ran) != 0 {
+		t.Errorf("hosts %v are run with invalid serial", ran)
+	}
+}
+
+func TestRunBatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []func(*Client)
+		hosts   []string
+		// want statuses of hosts, hosts not listed succeed.
+		want map[string]string
+		// wantSkipped is in the messages of skipped hosts.
+		wantSkipped string
+	}{
+		{
+			name:    "failures within max fail percentage",
+			options: []func(*Client){WithSerial([]string{"2"}), WithMaxFailPercentage(50)},
+			hosts:   []string{"fail1", "ok1", "ok2", "ok3"},
+			want:    map[string]string{"fail1": FailedIdentifier},
+		},
+		{
+			name:        "failures more than max fail percentage",
+			options:     []func(*Client){WithSerial([]string{"2"}), WithMaxFailPercentage(49)},
+			hosts:       []string{"fail1", "ok1", "ok2", "ok3"},
+			want:        map[string]string{"fail1": FailedIdentifier, "ok2": SkippedIdentifier, "ok3": SkippedIdentifier},
+			wantSkipped: "1 of 2 hosts failed in batch 1/2, more than 49%",
+		},
+		{
+			name:        "zero max fail percentage",
+			options:     []func(*Client){WithSerial([]string{"1"}), WithMaxFailPercentage(0)},
+			hosts:       []string{"ok1", "fail1", "ok2", "ok3"},
+			want:        map[string]string{"fail1": FailedIdentifier, "ok2": SkippedIdentifier, "ok3": SkippedIdentifier},
+			wantSkipped: "1 of 1 hosts failed in batch 2/4",
+		},
+		{
+			name:    "default max fail percentage",
+			options: []func(*Client){WithSerial([]string{"1"})},
+			hosts:   []string{"fail1", "fail2", "ok1"},
+			want:    map[string]string{"fail1": FailedIdentifier, "fail2": FailedIdentifier},
+		},
+		{
+			name:        "any errors fatal in batch",
+			options:     []func(*Client){WithAnyErrorsFatal(true), WithConcurrency(1)},
+			hosts:       []string{"ok1", "fail1", "ok2", "ok3"},
+			want:        map[string]string{"fail1": FailedIdentifier, "ok2": SkippedIdentifier, "ok3": SkippedIdentifier},
+			wantSkipped: "a host failed and any errors are fatal",
+		},
+		{
+			name:        "any errors fatal across batches",
+			options:     []func(*Client){WithAnyErrorsFatal(true), WithSerial([]string{"1"})},
+			hosts:       []string{"ok1", "fail1", "ok2", "ok3"},
+			want:        map[string]string{"fail1": FailedIdentifier, "ok2": SkippedIdentifier, "ok3": SkippedIdentifier},
+			wantSkipped: "1 hosts failed in batch 2/4 and any errors are fatal",
+		},
+		{
+			name: "stopped before batch",
+			options: []func(*Client){
+				WithSerial([]string{"1"}),
+				WithBeforeBatch(func(ctx context.Context, batch, batches int, hosts []*Host) bool {
+					return batch < 3
+				}),
+			},
+			hosts:       []string{"ok1", "ok2", "ok3", "ok4"},
+			want:        map[string]string{"ok3": SkippedIdentifier, "ok4": SkippedIdentifier},
+			wantSkipped: "stopped before batch 3/4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient(tt.options...)
+			defer c.Close()
+
+			task := &fakeTask{}
+
+			resCh, err := c.BatchRunContext(context.Background(), newFakeHosts(tt.hosts...), task)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results := collectResults(t, resCh)
+			if len(results) != len(tt.hosts) {
+				t.Fatalf("got %d results, want %d", len(results), len(tt.hosts))
+			}
+
+			ran := make(map[string]bool)
+			for _, v := range task.ranHosts() {
+				ran[v] = true
+			}
+
+			for _, alias := range tt.hosts {
+				want := tt.want[alias]
+				if want == "" {
+					want = SuccessIdentifier
+				}
+
+				got := results[alias]
+				if got.Status != want {
+					t.Errorf("status of '%s' = %s, want %s", alias, got.Status, want)
+				}
+
+				if want == SkippedIdentifier {
+					if ran[alias] {
+						t.Errorf("skipped host '%s' is run", alias)
+					}
+					if !strings.Contains(got.Message, tt.wantSkipped) {
+						t.Errorf("message of '%s' = %q, want %q", alias, got.Message, tt.wantSkipped)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package batchssh
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// Names of output streams.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// maxLineSize is the max size of a line buffered by lineWriter, longer lines
+// such as binary output or progress bars are emitted in pieces of this size.
+const maxLineSize = 64 * 1024
+
+// StreamHandler is called for each line of the output of host as soon as the
+// line arrives, line has no trailing line break. It may be called
+// concurrently for different hosts.
+type StreamHandler func(host *Host, stream, line string)
+
+// lineWriter buffers written data and emits complete lines, so that the
+// lines of different hosts never interleave.
+type lineWriter struct {
+	mu     sync.Mutex
+	buf    []byte
+	host   *Host
+	stream string
+	emit   StreamHandler
+}
+
+func newLineWriter(host *Host, stream string, emit StreamHandler) *lineWriter {
+	return &lineWriter{
+		host:   host,
+		stream: stream,
+		emit:   emit,
+	}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.emitLine(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+
+	for len(w.buf) >= maxLineSize {
+		w.emitLine(w.buf[:maxLineSize])
+		w.buf = w.buf[maxLineSize:]
+	}
+
+	return len(p), nil
+}
+
+// Flush emits the last line that has no trailing line break.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) != 0 {
+		w.emitLine(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) emitLine(line []byte) {
+	// Lines end with '\r\n' on pty.
+	w.emit(w.host, w.stream, strings.TrimSuffix(string(line), "\r"))
+}
@@ -0,0 +1,78 @@
+package batchssh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []string
+	}{
+		{name: "one line", writes: []string{"hello\n"}, want: []string{"hello"}},
+		{name: "lines in one write", writes: []string{"a\nb\nc\n"}, want: []string{"a", "b", "c"}},
+		{name: "line split across writes", writes: []string{"hel", "lo\nwor", "ld\n"}, want: []string{"hello", "world"}},
+		{name: "pty line breaks", writes: []string{"a\r\nb\r", "\n"}, want: []string{"a", "b"}},
+		{name: "empty lines", writes: []string{"\n\na\n"}, want: []string{"", "", "a"}},
+		{name: "last line flushed", writes: []string{"a\nno line break"}, want: []string{"a", "no line break"}},
+		{name: "nothing written", writes: nil, want: nil},
+	}
+
+	host := &Host{Alias: "host1"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			w := newLineWriter(host, StreamStderr, func(h *Host, stream, line string) {
+				if h != host || stream != StreamStderr {
+					t.Errorf("line of %s %s, want %s %s", h.Alias, stream, host.Alias, StreamStderr)
+				}
+				got = append(got, line)
+			})
+
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+			}
+			w.Flush()
+			w.Flush()
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineWriterLongLine(t *testing.T) {
+	var got []int
+	w := newLineWriter(&Host{Alias: "host1"}, StreamStdout, func(_ *Host, _, line string) {
+		got = append(got, len(line))
+	})
+
+	// A long line without line break is emitted once the buffer is full.
+	chunk := strings.Repeat("x", 1000)
+	for i := 0; i < maxLineSize/len(chunk)+1; i++ {
+		_, _ = w.Write([]byte(chunk))
+	}
+
+	if !reflect.DeepEqual(got, []int{maxLineSize}) {
+		t.Errorf("emitted line sizes = %v, want [%d]", got, maxLineSize)
+	}
+
+	if len(w.buf) >= maxLineSize {
+		t.Errorf("buffered %d bytes, want less than %d", len(w.buf), maxLineSize)
+	}
+
+	_, _ = w.Write([]byte("end\n"))
+	w.Flush()
+
+	want := []int{maxLineSize, (maxLineSize/len(chunk)+1)*len(chunk) - maxLineSize + len("end")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("emitted line sizes = %v, want %v", got, want)
+	}
+}
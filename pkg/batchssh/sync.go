@@ -0,0 +1,542 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package batchssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/serialt/gosible/pkg/log"
+)
+
+// SyncOptions of Client.SyncFiles.
+type SyncOptions struct {
+	// Checksum compares files by sha256 checksums instead of sizes and mtimes.
+	Checksum bool
+	// Delete removes remote files that do not exist locally.
+	Delete bool
+	// Includes are glob patterns of files to be synced, all files are synced if empty.
+	Includes []string
+	// Excludes are glob patterns of files and dirs not to be synced,
+	// they are never deleted on the remote host.
+	Excludes []string
+}
+
+// SyncStats counts files and symlinks of a sync.
+type SyncStats struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+}
+
+func (s *SyncStats) String() string {
+	return fmt.Sprintf(
+		"created: %d, updated: %d, deleted: %d, unchanged: %d",
+		s.Created, s.Updated, s.Deleted, s.Unchanged,
+	)
+}
+
+// syncEntry is a local file or dir to be synced.
+type syncEntry struct {
+	// rel is the slash separated path relative to the parent dir of the source.
+	rel  string
+	file string
+	info os.FileInfo
+}
+
+// SyncFiles to dstDir of remote host. Only files that differ from the remote
+// ones are copied, modes, mtimes and symlinks are preserved.
+func (c *Client) SyncFiles(
+	ctx context.Context,
+	host *Host,
+	srcFiles []string,
+	dstDir string,
+	options SyncOptions,
+) (*SyncStats, error) {
+	client, release, err := c.getClient(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ftpC, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, err
+	}
+	defer ftpC.Close()
+	defer closeOnDone(ctx, ftpC)()
+
+	if info, err := ftpC.Stat(dstDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("dest dir '%s' not exist", dstDir)
+	}
+
+	stats := &SyncStats{}
+
+	for _, f := range srcFiles {
+		err := c.syncFile(ctx, client, ftpC, filepath.Clean(expandHome(f)), dstDir, &options, stats)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// syncFile syncs file or dir src to dstDir/<base name of src>.
+//
+//nolint:funlen,gocyclo
+func (c *Client) syncFile(
+	ctx context.Context,
+	client *ssh.Client,
+	ftpC *sftp.Client,
+	src, dstDir string,
+	options *SyncOptions,
+	stats *SyncStats,
+) error {
+	baseDir := filepath.Dir(src)
+
+	var locals []syncEntry
+	err := filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(baseDir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if !options.match(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		locals = append(locals, syncEntry{rel: rel, file: file, info: info})
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	remotes, err := remoteEntries(ftpC, dstDir, path.Join(dstDir, filepath.Base(src)), options)
+	if err != nil {
+		return err
+	}
+
+	var remoteSums map[string]string
+	if options.Checksum {
+		var candidates []string
+		for _, l := range locals {
+			if r, ok := remotes[l.rel]; ok && l.info.Mode().IsRegular() && r.Mode().IsRegular() &&
+				r.Size() == l.info.Size() {
+				candidates = append(candidates, path.Join(dstDir, l.rel))
+			}
+		}
+
+		if remoteSums, err = c.remoteChecksums(ctx, client, ftpC, candidates); err != nil {
+			return err
+		}
+	}
+
+	// Modes and mtimes of dirs are set after their contents are synced.
+	var dirs []syncEntry
+
+	localRels := make(map[string]bool, len(locals))
+	for _, l := range locals {
+		localRels[l.rel] = true
+
+		dst := path.Join(dstDir, l.rel)
+		remote, exists := remotes[l.rel]
+
+		if exists && remote.IsDir() != l.info.IsDir() {
+			// Remote entries are deleted only with options.Delete.
+			if !options.Delete {
+				if remote.IsDir() {
+					return fmt.Errorf("remote '%s' is a dir, local is a file, add '--delete' to replace it", dst)
+				}
+
+				return fmt.Errorf("remote '%s' is a file, local is a dir, add '--delete' to replace it", dst)
+			}
+
+			log.Debugf("Sync: remove '%s' of different type", dst)
+
+			deleted, err := removeAll(ftpC, dst, remote)
+			if err != nil {
+				return err
+			}
+			stats.Deleted += deleted
+			exists = false
+
+			// Entries in the removed dir are not deleted again.
+			for rel := range remotes {
+				if strings.HasPrefix(rel, l.rel+"/") {
+					delete(remotes, rel)
+				}
+			}
+		}
+
+		switch {
+		case l.info.IsDir():
+			if !exists {
+				if err := ftpC.Mkdir(dst); err != nil {
+					return sftpError(err, dst)
+				}
+			}
+
+			dirs = append(dirs, l)
+
+			continue
+		case l.info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(l.file)
+			if err != nil {
+				return err
+			}
+
+			if exists && remote.Mode()&os.ModeSymlink != 0 {
+				if remoteLink, err := ftpC.ReadLink(dst); err == nil && remoteLink == link {
+					stats.Unchanged++
+					continue
+				}
+			}
+
+			if exists {
+				_ = ftpC.Remove(dst)
+			}
+
+			if err := ftpC.Symlink(link, dst); err != nil {
+				return sftpError(err, dst)
+			}
+		case l.info.Mode().IsRegular():
+			if exists && remote.Mode().IsRegular() && remote.Size() == l.info.Size() {
+				same, err := sameContent(l, remote, remoteSums[dst], options.Checksum)
+				if err != nil {
+					return err
+				}
+
+				if same {
+					if remote.Mode().Perm() == l.info.Mode().Perm() {
+						stats.Unchanged++
+						continue
+					}
+
+					log.Debugf("Sync: chmod '%s' to %s", dst, l.info.Mode().Perm())
+
+					if err := ftpC.Chmod(dst, l.info.Mode().Perm()); err != nil {
+						return sftpError(err, dst)
+					}
+					stats.Updated++
+
+					continue
+				}
+			}
+
+			if exists && remote.Mode()&os.ModeSymlink != 0 {
+				_ = ftpC.Remove(dst)
+			}
+
+			if err := pushFileBySFTP(ftpC, l.file, dst, l.info); err != nil {
+				return err
+			}
+		default:
+			log.Debugf("Sync: skip '%s' of mode %s", l.file, l.info.Mode())
+			continue
+		}
+
+		if exists {
+			log.Debugf("Sync: update '%s'", dst)
+			stats.Updated++
+		} else {
+			log.Debugf("Sync: create '%s'", dst)
+			stats.Created++
+		}
+	}
+
+	if options.Delete {
+		// Children are removed before their parent dirs.
+		var extra []string
+		for rel := range remotes {
+			if !localRels[rel] {
+				extra = append(extra, rel)
+			}
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(extra)))
+
+		for _, rel := range extra {
+			dst := path.Join(dstDir, rel)
+			remote := remotes[rel]
+
+			// Dirs containing excluded files are kept.
+			if remote.IsDir() {
+				if err := ftpC.RemoveDirectory(dst); err != nil {
+					log.Debugf("Sync: keep dir '%s': %s", dst, err)
+				}
+				continue
+			}
+
+			log.Debugf("Sync: delete '%s'", dst)
+
+			if err := ftpC.Remove(dst); err != nil {
+				return sftpError(err, dst)
+			}
+			stats.Deleted++
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dst := path.Join(dstDir, dirs[i].rel)
+		if err := ftpC.Chmod(dst, dirs[i].info.Mode().Perm()); err != nil {
+			return sftpError(err, dst)
+		}
+
+		if err := ftpC.Chtimes(dst, time.Now(), dirs[i].info.ModTime()); err != nil {
+			return sftpError(err, dst)
+		}
+	}
+
+	return nil
+}
+
+// match checks if the file or dir of slash separated path rel is synced.
+// Patterns match either rel or its base name.
+func (o *SyncOptions) match(rel string, isDir bool) bool {
+	for _, p := range o.Excludes {
+		if globMatch(p, rel) {
+			return false
+		}
+	}
+
+	if isDir || len(o.Includes) == 0 {
+		return true
+	}
+
+	for _, p := range o.Includes {
+		if globMatch(p, rel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func globMatch(pattern, rel string) bool {
+	if ok, _ := path.Match(pattern, rel); ok {
+		return true
+	}
+
+	ok, _ := path.Match(pattern, path.Base(rel))
+
+	return ok
+}
+
+// remoteEntries walks dst on the remote host, the keys of the returned map
+// are paths relative to dstDir. Entries not matched by options are skipped.
+func remoteEntries(ftpC *sftp.Client, dstDir, dst string, options *SyncOptions) (map[string]os.FileInfo, error) {
+	entries := make(map[string]os.FileInfo)
+
+	if _, err := ftpC.Lstat(dst); err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, sftpReadError(err, dst)
+	}
+
+	walker := ftpC.Walk(dst)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, sftpReadError(err, walker.Path())
+		}
+
+		rel := remoteRel(dstDir, walker.Path())
+		info := walker.Stat()
+
+		if !options.match(rel, info.IsDir()) {
+			if info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+
+		entries[rel] = info
+	}
+
+	return entries, nil
+}
+
+// remoteRel returns the path of file relative to dir, file is a cleaned path
+// in dir, such as the paths returned by sftp walker.
+func remoteRel(dir, file string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(file, path.Clean(dir)), "/")
+}
+
+// sameContent compares the local file with the remote one of the same size.
+func sameContent(local syncEntry, remote os.FileInfo, remoteSum string, checksum bool) (bool, error) {
+	if !checksum {
+		return local.info.ModTime().Unix() == remote.ModTime().Unix(), nil
+	}
+
+	if remoteSum == "" {
+		return false, nil
+	}
+
+	f, err := os.Open(local.file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)) == remoteSum, nil
+}
+
+// remoteChecksums gets sha256 checksums of files on the remote host by
+// 'sha256sum' if it exists, otherwise by reading files over sftp.
+func (c *Client) remoteChecksums(
+	ctx context.Context,
+	client *ssh.Client,
+	ftpC *sftp.Client,
+	files []string,
+) (map[string]string, error) {
+	sums := make(map[string]string, len(files))
+	if len(files) == 0 {
+		return sums, nil
+	}
+
+	if !c.hasCommand(client, "sha256sum") {
+		for _, f := range files {
+			file, err := ftpC.Open(f)
+			if err != nil {
+				return nil, sftpReadError(err, f)
+			}
+
+			h := sha256.New()
+			_, err = file.WriteTo(h)
+			file.Close()
+			if err != nil {
+				return nil, err
+			}
+
+			sums[f] = hex.EncodeToString(h.Sum(nil))
+		}
+
+		return sums, nil
+	}
+
+	// Keep command lines short.
+	const batchSize = 200
+
+	for start := 0; start < len(files); start += batchSize {
+		end := start + batchSize
+		if end > len(files) {
+			end = len(files)
+		}
+		batch := files[start:end]
+
+		quoted := make([]string, len(batch))
+		for i, f := range batch {
+			quoted[i] = shellQuote(f)
+		}
+
+		session, err := client.NewSession()
+		if err != nil {
+			return nil, err
+		}
+
+		// One line for each file in order, '-' if it can not be read.
+		out, err := func() ([]byte, error) {
+			defer session.Close()
+			defer closeOnDone(ctx, session)()
+
+			return session.Output(fmt.Sprintf(
+				`for f in %s; do sha256sum < "$f" 2>/dev/null || echo -; done`,
+				strings.Join(quoted, " "),
+			))
+		}()
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for _, f := range batch {
+			if !scanner.Scan() {
+				break
+			}
+
+			if fields := strings.Fields(scanner.Text()); len(fields) != 0 && fields[0] != "-" {
+				sums[f] = fields[0]
+			}
+		}
+	}
+
+	return sums, nil
+}
+
+// removeAll removes the remote file or dir, it returns the number of
+// removed files that are not dirs.
+func removeAll(ftpC *sftp.Client, file string, info os.FileInfo) (int, error) {
+	if !info.IsDir() {
+		return 1, sftpError(ftpC.Remove(file), file)
+	}
+
+	entries, err := ftpC.ReadDir(file)
+	if err != nil {
+		return 0, sftpReadError(err, file)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		n, err := removeAll(ftpC, path.Join(file, entry.Name()), entry)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, sftpError(ftpC.RemoveDirectory(file), file)
+}
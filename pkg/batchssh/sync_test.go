@@ -0,0 +1,165 @@
+package batchssh
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRemoteEntries(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{"app/conf/app.yaml", "app/bin/app", "app/app.log"} {
+		file = filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := filepath.ToSlash(root)
+	ftpC := newTestSFTPClient(t)
+
+	tests := []struct {
+		name    string
+		dstDir  string
+		options *SyncOptions
+		want    []string
+	}{
+		{
+			name:    "clean dir",
+			dstDir:  dir,
+			options: &SyncOptions{},
+			want:    []string{"app", "app/app.log", "app/bin", "app/bin/app", "app/conf", "app/conf/app.yaml"},
+		},
+		{
+			name:    "dir with trailing slash",
+			dstDir:  dir + "/",
+			options: &SyncOptions{},
+			want:    []string{"app", "app/app.log", "app/bin", "app/bin/app", "app/conf", "app/conf/app.yaml"},
+		},
+		{
+			name:    "unclean dir",
+			dstDir:  dir + "//./",
+			options: &SyncOptions{Excludes: []string{"*.log", "bin"}},
+			want:    []string{"app", "app/conf", "app/conf/app.yaml"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := remoteEntries(ftpC, tt.dstDir, dir+"/app", tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, 0, len(entries))
+			for rel := range entries {
+				got = append(got, rel)
+			}
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("remoteEntries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncOptionsMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		options SyncOptions
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		{name: "no patterns", rel: "app/app.yaml", want: true},
+		{name: "exclude base name", options: SyncOptions{Excludes: []string{"*.log"}}, rel: "app/app.log", want: false},
+		{name: "exclude path", options: SyncOptions{Excludes: []string{"app/*.log"}}, rel: "app/app.log", want: false},
+		{name: "exclude dir", options: SyncOptions{Excludes: []string{"bin"}}, rel: "app/bin", isDir: true, want: false},
+		{name: "include", options: SyncOptions{Includes: []string{"*.yaml"}}, rel: "app/app.yaml", want: true},
+		{name: "not included", options: SyncOptions{Includes: []string{"*.yaml"}}, rel: "app/app.log", want: false},
+		{name: "dirs are included", options: SyncOptions{Includes: []string{"*.yaml"}}, rel: "app/conf", isDir: true, want: true},
+		{
+			name:    "exclude wins",
+			options: SyncOptions{Includes: []string{"*.yaml"}, Excludes: []string{"secret.yaml"}},
+			rel:     "app/secret.yaml",
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.options.match(tt.rel, tt.isDir); got != tt.want {
+				t.Errorf("match(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncFileTypeChanged(t *testing.T) {
+	tests := []struct {
+		name     string
+		localDir bool
+		delete   bool
+		wantErr  string
+	}{
+		{name: "remote dir kept without delete", wantErr: "is a dir, local is a file"},
+		{name: "remote file kept without delete", localDir: true, wantErr: "is a file, local is a dir"},
+		{name: "remote dir replaced with delete", delete: true},
+		{name: "remote file replaced with delete", localDir: true, delete: true},
+	}
+
+	ftpC := newTestSFTPClient(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "app")
+			dst := t.TempDir()
+			remote := filepath.Join(dst, "app")
+
+			// local and remote 'app' are of different types.
+			localFile, remoteFile := src, filepath.Join(remote, "data")
+			if tt.localDir {
+				localFile, remoteFile = filepath.Join(src, "data"), remote
+			}
+
+			for _, file := range []string{localFile, remoteFile} {
+				if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(file, []byte("data"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			stats := &SyncStats{}
+			err := NewClient().syncFile(context.Background(), nil, ftpC, src, filepath.ToSlash(dst),
+				&SyncOptions{Delete: tt.delete}, stats)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("syncFile() error = %v, want %s", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("syncFile() error = %v", err)
+			}
+
+			// 'app' is of the local type only if it is replaced.
+			info, err := os.Stat(remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replaced := info.IsDir() == tt.localDir; replaced != tt.delete {
+				t.Errorf("remote 'app' replaced = %v, want %v", replaced, tt.delete)
+			}
+		})
+	}
+}
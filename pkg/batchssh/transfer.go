@@ -0,0 +1,373 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package batchssh
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/serialt/gosible/pkg/log"
+)
+
+// Methods of transferring files.
+const (
+	// TransferAuto uses tar if it exists on the target host, otherwise sftp.
+	TransferAuto = "auto"
+	// TransferSFTP walks local files and copies them one by one over sftp.
+	TransferSFTP = "sftp"
+	// TransferTar streams a tar archive to 'tar -x' over an exec session.
+	TransferTar = "tar"
+)
+
+// TransferMethods available.
+var TransferMethods = []string{
+	TransferAuto,
+	TransferSFTP,
+	TransferTar,
+}
+
+// pushFilesByTar streams srcFiles as a tar archive to 'tar -x' in dstDir.
+func (c *Client) pushFilesByTar(
+	ctx context.Context,
+	client *ssh.Client,
+	srcFiles []string,
+	dstDir string,
+	allowOverwrite bool,
+) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	defer closeOnDone(ctx, session)()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	if err := session.Start(pushTarScript(srcFiles, dstDir, allowOverwrite)); err != nil {
+		return err
+	}
+
+	remote := &remoteWriter{w: stdin}
+	tw := tar.NewWriter(remote)
+
+	var writeErr error
+	for _, f := range srcFiles {
+		if writeErr = writeTar(tw, f); writeErr != nil {
+			break
+		}
+	}
+
+	if writeErr == nil {
+		writeErr = tw.Close()
+	}
+	stdin.Close()
+
+	err = session.Wait()
+
+	// Errors of reading local files are reported rather than the errors of
+	// remote tar caused by the incomplete archive. Errors of writing to the
+	// remote tar are caused by remote tar failures reported by Wait.
+	if writeErr != nil && remote.err == nil && ctx.Err() == nil {
+		return writeErr
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(msg)
+		}
+
+		return err
+	}
+
+	return writeErr
+}
+
+// remoteWriter records the error of writing to the remote host.
+type remoteWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *remoteWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+
+	return n, err
+}
+
+// pushTarScript returns the remote script that checks dstDir and extracts
+// the tar archive from stdin in it.
+func pushTarScript(srcFiles []string, dstDir string, allowOverwrite bool) string {
+	var script strings.Builder
+
+	// Paths are passed to printf as quoted arguments, never in its format.
+	fmt.Fprintf(&script, `cd %s 2>/dev/null || { printf "dest dir '%%s' not exist\n" %s >&2; exit 2; };`,
+		shellQuote(dstDir), shellQuote(dstDir))
+
+	if !allowOverwrite {
+		var names []string
+		for _, f := range srcFiles {
+			names = append(names, shellQuote(filepath.Base(f)))
+		}
+
+		fmt.Fprintf(&script, `for f in %s; do if [ -e "$f" ] || [ -L "$f" ]; then `+
+			`printf "%%s/%%s alreay exists, you can add '-F' flag to overwrite it\n" %s "$f" >&2; exit 2; fi; done;`,
+			strings.Join(names, " "), shellQuote(strings.TrimSuffix(dstDir, "/")))
+	}
+
+	script.WriteString("tar -x -o -p -f -")
+
+	return script.String()
+}
+
+// writeTar writes file or dir src to tw, the names in the archive are
+// relative to the parent dir of src.
+func writeTar(tw *tar.Writer, src string) error {
+	src = filepath.Clean(expandHome(src))
+	baseDir := filepath.Dir(src)
+
+	return filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(baseDir, file)
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		// Files are owned by the login user on target hosts.
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+
+		return err
+	})
+}
+
+// pushFilesBySFTP walks srcFiles and copies them to dstDir over sftp.
+func (c *Client) pushFilesBySFTP(
+	ftpC *sftp.Client,
+	srcFiles []string,
+	dstDir string,
+	allowOverwrite bool,
+) error {
+	if info, err := ftpC.Stat(dstDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("dest dir '%s' not exist", dstDir)
+	}
+
+	for _, f := range srcFiles {
+		src := filepath.Clean(expandHome(f))
+		dst := path.Join(dstDir, filepath.Base(src))
+
+		if !allowOverwrite {
+			if _, err := ftpC.Lstat(dst); err == nil {
+				return fmt.Errorf("%s alreay exists, you can add '-F' flag to overwrite it", dst)
+			}
+		}
+
+		if err := pushBySFTP(ftpC, src, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func pushBySFTP(ftpC *sftp.Client, src, dst string) error {
+	type dirInfo struct {
+		dir  string
+		info os.FileInfo
+	}
+
+	// Modes and mtimes of dirs are set after their contents are copied,
+	// so that read-only dirs can be filled.
+	var dirs []dirInfo
+
+	err := filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		dstFile := path.Join(dst, filepath.ToSlash(rel))
+
+		switch {
+		case info.IsDir():
+			if err := ftpC.Mkdir(dstFile); err != nil {
+				if fi, err1 := ftpC.Stat(dstFile); err1 != nil || !fi.IsDir() {
+					return sftpError(err, dstFile)
+				}
+			}
+
+			dirs = append(dirs, dirInfo{dstFile, info})
+
+			return nil
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+
+			_ = ftpC.Remove(dstFile)
+
+			return sftpError(ftpC.Symlink(link, dstFile), dstFile)
+		case info.Mode().IsRegular():
+			return pushFileBySFTP(ftpC, file, dstFile, info)
+		default:
+			log.Debugf("Push: skip '%s' of mode %s", file, info.Mode())
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := ftpC.Chmod(dirs[i].dir, dirs[i].info.Mode().Perm()); err != nil {
+			return sftpError(err, dirs[i].dir)
+		}
+
+		if err := ftpC.Chtimes(dirs[i].dir, time.Now(), dirs[i].info.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func pushFileBySFTP(ftpC *sftp.Client, src, dst string, info os.FileInfo) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := ftpC.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return sftpError(err, dst)
+	}
+	defer dstFile.Close()
+
+	if _, err := dstFile.ReadFrom(srcFile); err != nil {
+		return err
+	}
+
+	if err := dstFile.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return ftpC.Chtimes(dst, time.Now(), info.ModTime())
+}
+
+// sftpError makes sftp errors of file readable.
+func sftpError(err error, file string) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("'%s' not exist", file)
+	}
+
+	if err, ok := err.(*sftp.StatusError); ok && err.Code == uint32(sftp.ErrSshFxPermissionDenied) {
+		return fmt.Errorf("no permission to write '%s'", file)
+	}
+
+	return err
+}
+
+// hasCommand checks if command exists on the target host.
+func (c *Client) hasCommand(client *ssh.Client, command string) bool {
+	session, err := client.NewSession()
+	if err != nil {
+		return false
+	}
+	defer session.Close()
+
+	return session.Run("command -v "+shellQuote(command)+" >/dev/null 2>&1") == nil
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
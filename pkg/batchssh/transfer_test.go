@@ -0,0 +1,301 @@
+package batchssh
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestSFTPClient returns a sftp client of a sftp server serving local
+// file system in process.
+func newTestSFTPClient(t *testing.T) *sftp.Client {
+	t.Helper()
+
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = server.Serve() }()
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// closing the server first ends the receiving loop of the client.
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+
+	return client
+}
+
+// newTestSrcDir creates dir/app with files, a sub dir and a symlink.
+func newTestSrcDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app")
+
+	if err := os.MkdirAll(filepath.Join(app, "conf"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(app, "conf", "app.yaml"), []byte("port: 80"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(app, "run.sh"), []byte("#!/bin/sh"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("conf/app.yaml", filepath.Join(app, "app.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	return app
+}
+
+func TestWriteTar(t *testing.T) {
+	src := newTestSrcDir(t)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := writeTar(tw, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	type entry struct {
+		typeflag byte
+		mode     int64
+		linkname string
+		content  string
+	}
+
+	got := make(map[string]entry)
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if header.Uid != 0 || header.Gid != 0 || header.Uname != "" || header.Gname != "" {
+			t.Errorf("owner of %s is kept: %d:%d %s:%s", header.Name, header.Uid, header.Gid, header.Uname, header.Gname)
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got[header.Name] = entry{header.Typeflag, header.Mode & 0o777, header.Linkname, string(content)}
+	}
+
+	want := map[string]entry{
+		"app/":              {typeflag: tar.TypeDir, mode: 0o755},
+		"app/conf/":         {typeflag: tar.TypeDir, mode: 0o755},
+		"app/conf/app.yaml": {typeflag: tar.TypeReg, mode: 0o600, content: "port: 80"},
+		"app/run.sh":        {typeflag: tar.TypeReg, mode: 0o755, content: "#!/bin/sh"},
+		"app/app.yaml":      {typeflag: tar.TypeSymlink, mode: 0o777, linkname: "conf/app.yaml"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tar entries = %v, want %v", got, want)
+	}
+}
+
+func TestPushFilesBySFTP(t *testing.T) {
+	src := newTestSrcDir(t)
+	ftpC := newTestSFTPClient(t)
+	c := NewClient()
+
+	dst := t.TempDir()
+
+	if err := c.pushFilesBySFTP(ftpC, []string{src}, filepath.ToSlash(dst), false); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{"app/conf/app.yaml": "port: 80", "app/run.sh": "#!/bin/sh"} {
+		p, err := ioutil.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != content {
+			t.Errorf("content of %s = %q, want %q", name, p, content)
+		}
+	}
+
+	if info, err := os.Stat(filepath.Join(dst, "app", "run.sh")); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("mode of run.sh is not kept: %v, %v", info, err)
+	}
+
+	if link, err := os.Readlink(filepath.Join(dst, "app", "app.yaml")); err != nil || link != "conf/app.yaml" {
+		t.Errorf("symlink app.yaml = %q, %v", link, err)
+	}
+
+	err := c.pushFilesBySFTP(ftpC, []string{src}, filepath.ToSlash(dst), false)
+	if err == nil || !strings.Contains(err.Error(), "alreay exists") {
+		t.Errorf("pushFilesBySFTP() error = %v, want already exists", err)
+	}
+
+	if err := c.pushFilesBySFTP(ftpC, []string{src}, filepath.ToSlash(dst), true); err != nil {
+		t.Errorf("pushFilesBySFTP() overwrite error = %v", err)
+	}
+
+	err = c.pushFilesBySFTP(ftpC, []string{src}, filepath.ToSlash(dst)+"/none", false)
+	if err == nil || !strings.Contains(err.Error(), "not exist") {
+		t.Errorf("pushFilesBySFTP() error = %v, want dest dir not exist", err)
+	}
+
+	// A read-only dir is filled before its mode is set.
+	readOnly := filepath.Join(src, "conf")
+	if err := os.Chmod(readOnly, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(readOnly, 0o755) })
+
+	dst = t.TempDir()
+	t.Cleanup(func() { _ = os.Chmod(filepath.Join(dst, "app", "conf"), 0o755) })
+
+	if err := c.pushFilesBySFTP(ftpC, []string{src}, filepath.ToSlash(dst), false); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "app", "conf"))
+	if err != nil || info.Mode().Perm() != 0o555 {
+		t.Errorf("mode of read-only dir is not kept: %v, %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "app", "conf", "app.yaml")); err != nil {
+		t.Errorf("file in read-only dir is not pushed: %v", err)
+	}
+}
+
+func TestPushTarScript(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+
+	cwd := t.TempDir()
+	dstDir := filepath.Join(cwd, "a \"b\" $(touch pwned1) `touch pwned2`")
+	if err := os.MkdirAll(filepath.Join(dstDir, "app"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		dstDir string
+		want   string
+	}{
+		{name: "dest dir not exist", dstDir: dstDir + "/none", want: "dest dir '" + dstDir + "/none' not exist\n"},
+		{
+			name:   "file exists",
+			dstDir: dstDir + "/",
+			want:   dstDir + "/app alreay exists, you can add '-F' flag to overwrite it\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+
+			cmd := exec.Command(sh, "-c", pushTarScript([]string{"/path/app"}, tt.dstDir, false))
+			cmd.Dir = cwd
+			cmd.Stderr = &stderr
+
+			if err := cmd.Run(); err == nil {
+				t.Error("script should fail")
+			}
+			if stderr.String() != tt.want {
+				t.Errorf("stderr = %q, want %q", stderr.String(), tt.want)
+			}
+		})
+	}
+
+	for _, name := range []string{"pwned1", "pwned2"} {
+		if _, err := os.Stat(filepath.Join(cwd, name)); err == nil {
+			t.Errorf("dest dir is executed by shell, %s is created", name)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+
+	for _, s := range []string{"", "a b", "it's", `"$HOME" $(id) ; rm -rf /`, "line1\nline2", `\'`} {
+		t.Run(s, func(t *testing.T) {
+			//nolint:gosec
+			out, err := exec.Command(sh, "-c", "printf %s "+shellQuote(s)).Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(out) != s {
+				t.Errorf("shell got %q, want %q", out, s)
+			}
+		})
+	}
+}
+
+func TestPushFilesByTarLocalError(t *testing.T) {
+	src := newTestSrcDir(t)
+
+	unreadable := filepath.Join(src, "secret")
+	if err := ioutil.WriteFile(unreadable, []byte("secret"), 0o000); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{name: "missing file", src: filepath.Join(src, "none"), wantErr: "no such file"},
+		{name: "unreadable file", src: unreadable, wantErr: "permission denied"},
+	}
+
+	// The remote tar reads the incomplete archive and fails.
+	client := newTestExecClient(t, func(_ string, ch ssh.Channel) {
+		_, _ = io.Copy(ioutil.Discard, ch)
+		_, _ = ch.Stderr().Write([]byte("tar: Unexpected EOF in archive\n"))
+		_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{2}))
+	}, nil)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "unreadable file" && os.Geteuid() == 0 {
+				t.Skip("root can read any file")
+			}
+
+			err := NewClient().pushFilesByTar(context.Background(), client, []string{tt.src}, "/tmp", true)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("pushFilesByTar() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// extensions of files that are not inventory files in inventory directory.
+var skipExtensions = []string{".retry", ".md", ".bak", ".orig"}
+
+// LoadDir loads inventory files in dir, hidden files, backup files and
+// directories are ignored. INI-like files, YAML/JSON files and outputs of
+// executable files are merged as one inventory, so that groups can be used
+// as children across files, and definitions of the same group are merged.
+//
+// Vars in 'group_vars/GROUP.yaml' and 'host_vars/ALIAS.yaml' override vars of
+// the group and the host in inventory files.
+//
+//nolint:gocyclo
+func LoadDir(dir string, options ...func(*Inventory)) (*Inventory, error) {
+	inv := New(options...)
+
+	var err error
+	if inv.groupVarsFiles, err = loadVarsFiles(filepath.Join(dir, groupVarsDir)); err != nil {
+		return nil, err
+	}
+
+	if inv.hostVarsFiles, err = loadVarsFiles(filepath.Join(dir, hostVarsDir)); err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := inv.newStructuredParser()
+
+	for _, entry := range entries {
+		if entry.IsDir() || skipFile(entry.Name()) {
+			continue
+		}
+
+		file := filepath.Join(dir, entry.Name())
+
+		if ok, _ := util.IsExecutable(file); ok {
+			content, err := executableOutput(file)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.parse(content); err != nil {
+				return nil, fmt.Errorf("invalid output of '%s %s': %w", file, dynamicArgList, err)
+			}
+
+			continue
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if FormatOf(file) == FormatINI {
+			err = p.parseINI(content)
+		} else {
+			err = p.parse(content)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid inventory file '%s': %w", file, err)
+		}
+	}
+
+	if err := p.build(); err != nil {
+		return nil, fmt.Errorf("invalid inventory directory '%s': %w", dir, err)
+	}
+
+	inv.buildAliasHostsMap()
+
+	return inv, nil
+}
+
+// skipFile reports whether the file in inventory directory is not an
+// inventory file or a vars file.
+func skipFile(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return true
+	}
+
+	return hasEntry(skipExtensions, strings.ToLower(filepath.Ext(name)))
+}
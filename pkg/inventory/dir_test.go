@@ -0,0 +1,208 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"01-hosts": `
+[web]
+web1 host=10.0.0.1 port=2222
+web2
+
+[prod:children]
+web
+db
+`,
+		"02-hosts.yaml": `
+db:
+  hosts:
+    db1:
+      env: db
+`,
+		"README.md":                    "# not an inventory file",
+		"group_vars/all.yaml":          "user: ops\nenv: dev\n",
+		"group_vars/prod.yml":          "env: prod\n",
+		"group_vars/web/main.yaml":     "port: 8022\nenv: web\n",
+		"group_vars/web/secret.yaml":   "password: ENC:web\n",
+		"host_vars/web2.yaml":          "env: special\nuser: root\n",
+		"host_vars/db1":                "password: plain\n",
+		"host_vars/.hidden.yaml":       "invalid: [",
+		"group_vars/web/main.yaml.bak": "invalid: [",
+	}
+
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decrypt := WithDecrypt(func(alias, name, value string) (string, error) {
+		return strings.TrimPrefix(value, "ENC:"), nil
+	})
+
+	inv, err := Load(dir, decrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Host{
+		{Alias: "web1", Host: "10.0.0.1", Port: 2222, User: "ops", Password: "web", Vars: map[string]interface{}{"env": "web"}},
+		{Alias: "web2", Host: "web2", Port: 8022, User: "root", Password: "web", Vars: map[string]interface{}{"env": "special"}},
+		{Alias: "db1", Host: "db1", User: "ops", Password: "plain", Vars: map[string]interface{}{"env": "db"}},
+	}
+
+	var got []*Host
+	for _, v := range inv.GroupHosts("prod") {
+		host, err := inv.Decrypt(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, host)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decrypted GroupHosts(prod) = %v, want %v", got, want)
+	}
+
+	// hosts are decrypted only by Decrypt.
+	if got := inv.Hosts(); len(got) != len(want) || got[0].Password != "ENC:web" {
+		t.Errorf("Hosts() = %v, want hosts not decrypted", got)
+	}
+
+	if got, want := inv.Children("prod"), []string{"web", "db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Children(prod) = %v, want %v", got, want)
+	}
+
+	if got, want := inv.HostGroups("db1"), []string{"prod", "db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("HostGroups(db1) = %v, want %v", got, want)
+	}
+
+	// vars are not decrypted.
+	wantVars := map[string]interface{}{"host": "10.0.0.1", "port": "2222", "user": "ops", "password": "ENC:web", "env": "web"}
+	if got := inv.HostVars("web1"); !reflect.DeepEqual(got, wantVars) {
+		t.Errorf("HostVars(web1) = %v, want %v", got, wantVars)
+	}
+}
+
+func TestLoadDirVarsFileNames(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"hosts": `
+10.0.0.1
+10.0.0.2
+db.example.com
+web.example.com
+`,
+		"host_vars/10.0.0.1":            "user: one\nport: 2201\n",
+		"host_vars/10.0.0.2.yaml":       "user: two\nport: 2202\n",
+		"host_vars/db.example.com":      "user: db\n",
+		"host_vars/web.example.com.yml": "user: web\n",
+	}
+
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inv, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Host{
+		{Alias: "10.0.0.1", Host: "10.0.0.1", Port: 2201, User: "one"},
+		{Alias: "10.0.0.2", Host: "10.0.0.2", Port: 2202, User: "two"},
+		{Alias: "db.example.com", Host: "db.example.com", User: "db"},
+		{Alias: "web.example.com", Host: "web.example.com", User: "web"},
+	}
+
+	if got := inv.Hosts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Hosts() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadFileAndDirINI(t *testing.T) {
+	content := `
+[web]
+web01 port=2201
+web02
+
+[prod]
+web01
+db01
+
+[web:vars]
+user=webuser
+
+[prod:vars]
+user=produser
+env=prod
+`
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fileInv, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dirInv, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web01 := &Host{Alias: "web01", Host: "web01", Port: 2201, User: "produser", Vars: map[string]interface{}{"env": "prod"}}
+	web02 := &Host{Alias: "web02", Host: "web02", User: "webuser"}
+	db01 := &Host{Alias: "db01", Host: "db01", User: "produser", Vars: map[string]interface{}{"env": "prod"}}
+
+	for name, inv := range map[string]*Inventory{"file": fileInv, "dir": dirInv} {
+		if got, want := inv.Hosts(), []*Host{web01, web02, db01}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Hosts() = %v, want %v", name, got, want)
+		}
+
+		if got, want := inv.GroupHosts("web"), []*Host{web01, web02}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: GroupHosts(web) = %v, want %v", name, got, want)
+		}
+
+		if got, want := inv.GroupHosts("prod"), []*Host{web01, db01}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: GroupHosts(prod) = %v, want %v", name, got, want)
+		}
+
+		// a host in more than one group is built once.
+		if inv.GroupHosts("web")[0] != inv.GroupHosts("prod")[0] {
+			t.Errorf("%s: web01 of group web and group prod are different hosts", name)
+		}
+	}
+
+	if got, want := fileInv.Groups(), dirInv.Groups(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Groups() of file = %v, of dir = %v", got, want)
+	}
+
+	if got, want := fileInv.HostVars("web01"), dirInv.HostVars("web01"); !reflect.DeepEqual(got, want) {
+		t.Errorf("HostVars(web01) of file = %v, of dir = %v", got, want)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package inventory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/serialt/gosible/pkg/log"
+)
+
+// arguments of dynamic inventory executable, same as Ansible.
+const (
+	dynamicArgList = "--list"
+	dynamicArgHost = "--host"
+)
+
+// LoadExecutable loads dynamic inventory from the JSON output of executable
+// file with argument '--list', which is compatible with Ansible dynamic
+// inventory:
+//
+//	{
+//	  "webserver": {"hosts": ["web1", "web2"], "vars": {"user": "ops"}, "children": ["canary"]},
+//	  "canary": ["web3"],
+//	  "_meta": {"hostvars": {"web1": {"ansible_host": "10.0.0.1", "env": "prod"}}}
+//	}
+//
+// If the output has no '_meta', vars of each host are got by '--host <host>'.
+// The inventory is cached in cacheFile for ttl, the cache is not used if
+// cacheFile is empty or ttl is 0.
+func LoadExecutable(file string, cacheFile string, ttl time.Duration, options ...func(*Inventory)) (*Inventory, error) {
+	if content := readCache(cacheFile, ttl); content != nil {
+		inv, err := LoadReader(bytes.NewReader(content), FormatYAML, options...)
+		if err == nil {
+			log.Debugf("Inventory: load '%s' from cache '%s'", file, cacheFile)
+			return inv, nil
+		}
+
+		log.Debugf("Inventory: invalid cache '%s': %s", cacheFile, err)
+	}
+
+	content, err := executableOutput(file)
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := LoadReader(bytes.NewReader(content), FormatYAML, options...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output of '%s %s': %w", file, dynamicArgList, err)
+	}
+
+	if cacheFile != "" && ttl > 0 {
+		if err := writeCache(cacheFile, content); err != nil {
+			log.Debugf("Inventory: write cache '%s' failed: %s", cacheFile, err)
+		} else {
+			log.Debugf("Inventory: cache '%s' in '%s' for %s", file, cacheFile, ttl)
+		}
+	}
+
+	return inv, nil
+}
+
+// executableOutput returns output of '--list' of executable file, with vars
+// of hosts in '_meta'.
+func executableOutput(file string) ([]byte, error) {
+	content, err := runExecutable(file, dynamicArgList)
+	if err != nil {
+		return nil, err
+	}
+
+	return addHostVars(file, content)
+}
+
+// addHostVars adds '_meta' with vars from '--host <host>' of each host, if
+// there is no '_meta' in the output of '--list'.
+func addHostVars(file string, content []byte) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf("invalid output of '%s %s': %w", file, dynamicArgList, err)
+	}
+
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return content, nil
+	}
+
+	doc := root.Content[0]
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value == metaGroup {
+			return content, nil
+		}
+	}
+
+	inv, err := LoadReader(bytes.NewReader(content), FormatYAML)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output of '%s %s': %w", file, dynamicArgList, err)
+	}
+
+	hostVars := make(map[string]map[string]interface{})
+	for _, alias := range inv.Aliases() {
+		out, err := runExecutable(file, dynamicArgHost, alias)
+		if err != nil {
+			return nil, err
+		}
+
+		vars := make(map[string]interface{})
+		if err := yaml.Unmarshal(out, &vars); err != nil {
+			return nil, fmt.Errorf("invalid output of '%s %s %s': %w", file, dynamicArgHost, alias, err)
+		}
+
+		if len(vars) != 0 {
+			hostVars[alias] = vars
+		}
+	}
+
+	var meta yaml.Node
+	if err := meta.Encode(map[string]interface{}{metaHostVars: hostVars}); err != nil {
+		return nil, err
+	}
+
+	doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: metaGroup}, &meta)
+
+	return yaml.Marshal(&root)
+}
+
+func runExecutable(file string, args ...string) ([]byte, error) {
+	bin := file
+	if !strings.ContainsRune(bin, filepath.Separator) {
+		bin = "." + string(filepath.Separator) + bin
+	}
+
+	out, err := exec.Command(bin, args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) != 0 {
+			err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(exitErr.Stderr))
+		}
+
+		return nil, fmt.Errorf(
+			"problem executing inventory file '%s %s': %s, if this is not a executable file, "+
+				"remove the executable bit from the file", file, strings.Join(args, " "), err)
+	}
+
+	return out, nil
+}
+
+// readCache returns content of cacheFile if it is modified within ttl.
+func readCache(cacheFile string, ttl time.Duration) []byte {
+	if cacheFile == "" || ttl <= 0 {
+		return nil
+	}
+
+	info, err := os.Stat(cacheFile)
+	if err != nil || time.Since(info.ModTime()) >= ttl {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(cacheFile)
+	if err != nil {
+		return nil
+	}
+
+	return content
+}
+
+// writeCache writes cacheFile atomically, it is only readable by the owner
+// because it may contain passwords.
+func writeCache(cacheFile string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(cacheFile), filepath.Base(cacheFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), cacheFile)
+}
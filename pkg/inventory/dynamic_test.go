@@ -0,0 +1,58 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadExecutable(t *testing.T) {
+	dir := t.TempDir()
+
+	script := filepath.Join(dir, "inventory.sh")
+	content := `#!/bin/sh
+echo "$@" >> "$0.calls"
+case "$1" in
+--list) echo '{"web": {"hosts": ["web1", "web2"], "vars": {"ansible_user": "ops"}}, "db": ["db1"]}' ;;
+--host) [ "$2" = web1 ] && echo '{"ansible_host": "10.0.0.1", "ansible_port": 2222, "env": "prod"}' || echo '{}' ;;
+esac
+`
+	if err := os.WriteFile(script, []byte(content), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	cacheFile := filepath.Join(dir, "cache", "inventory.yaml")
+
+	want := []*Host{
+		{Alias: "web1", Host: "10.0.0.1", Port: 2222, User: "ops", Vars: map[string]interface{}{"env": "prod"}},
+		{Alias: "web2", Host: "web2", User: "ops"},
+		{Alias: "db1", Host: "db1"},
+	}
+
+	for i := 0; i < 2; i++ {
+		inv, err := LoadExecutable(script, cacheFile, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := inv.Hosts(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Hosts() = %v, want %v", got, want)
+		}
+	}
+
+	calls, err := os.ReadFile(script + ".calls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second load is from cache.
+	if got, want := string(calls), "--list\n--host web1\n--host web2\n--host db1\n"; got != want {
+		t.Errorf("calls of executable = %q, want %q", got, want)
+	}
+
+	if _, err := Load(script); err != nil {
+		t.Errorf("Load() of executable failed: %s", err)
+	}
+}
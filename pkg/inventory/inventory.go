@@ -24,11 +24,15 @@ package inventory
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
-	"strconv"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
-	"github.com/go-project-pkg/expandhost"
+	"github.com/serialt/gosible/pkg/util"
 )
 
 type hostVarType int
@@ -42,6 +46,12 @@ type Host struct {
 	Password   string
 	Keys       []string
 	Passphrase string
+	// Proxy is the jump hosts like '[user@]host[:port],[user@]host[:port]',
+	// 'none' means connecting directly.
+	Proxy string
+	// Vars are user variables of the host and its groups, built-in variables
+	// above are not included. It is nil if there are no user variables.
+	Vars map[string]interface{}
 }
 
 const (
@@ -51,6 +61,7 @@ const (
 	hostVarPassword
 	hostVarKeys
 	hostVarPassphrase
+	hostVarProxy
 )
 
 // idenditfiers
@@ -75,19 +86,9 @@ var hostVarsMap = map[hostVarType]string{
 	hostVarPassword:   "password",
 	hostVarKeys:       "keys",
 	hostVarPassphrase: "passphrase",
+	hostVarProxy:      "proxy",
 }
 
-var (
-	groupOrder []string
-
-	groupMap         = make(map[string][]string)
-	groupVarMap      = make(map[string][]string)
-	groupChildrenMap = make(map[string][]string)
-
-	groupHostsMap = make(map[string][]*Host)
-	aliasHostsMap = make(map[string]*Host)
-)
-
 var hostVars []string
 
 func init() {
@@ -96,40 +97,239 @@ func init() {
 	}
 }
 
-// Parse inventory file.
-func Parse(inventoryFile string) error {
-	if err := buildRawGroups(inventoryFile); err != nil {
-		return err
+// Format of inventory.
+type Format string
+
+// Inventory formats.
+const (
+	FormatINI  Format = "ini"
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+)
+
+// FormatOf detects the format of inventory file by its extension, files with
+// extension '.yaml', '.yml' or '.json' are YAML/JSON inventory, others are
+// INI-like inventory.
+func FormatOf(inventoryFile string) Format {
+	switch strings.ToLower(filepath.Ext(inventoryFile)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	default:
+		return FormatINI
 	}
+}
 
-	if err := buildGroupHostsMap(); err != nil {
-		return err
+// Inventory holds groups and hosts of an inventory. It is not modified after
+// loaded, so it is safe for concurrent use. Hosts returned by its methods are
+// shared and must not be modified, use copies of them returned by Decrypt.
+type Inventory struct {
+	groupOrder []string
+
+	groupChildrenMap map[string][]string
+
+	groupHostsMap map[string][]*Host
+	aliasHostsMap map[string]*Host
+
+	// mergedVars are vars of hosts before they are decrypted.
+	mergedVars map[*Host]map[string]interface{}
+
+	// vars from group_vars and host_vars of inventory directory.
+	groupVarsFiles map[string]map[string]interface{}
+	hostVarsFiles  map[string]map[string]interface{}
+
+	decrypt     func(alias, name, value string) (string, error)
+	isEncrypted func(value string) bool
+}
+
+// New returns an empty inventory, hosts selected from it are all hosts not
+// in inventory. Options are used when loading hosts.
+func New(options ...func(*Inventory)) *Inventory {
+	inv := &Inventory{
+		groupChildrenMap: make(map[string][]string),
+		groupHostsMap:    make(map[string][]*Host),
+		aliasHostsMap:    make(map[string]*Host),
+		mergedVars:       make(map[*Host]map[string]interface{}),
 	}
 
-	buildAliasHostsMap()
+	for _, option := range options {
+		option(inv)
+	}
 
-	return nil
+	return inv
 }
 
-// GetAllHosts that from inventory file.
-func GetAllHosts() []*Host {
+// Load inventory file, its format is detected by FormatOf. Executable files
+// are loaded by LoadExecutable without cache, and directories by LoadDir.
+func Load(inventoryFile string, options ...func(*Inventory)) (*Inventory, error) {
+	if util.DirExists(inventoryFile) {
+		return LoadDir(inventoryFile, options...)
+	}
+
+	if ok, err := util.IsExecutable(inventoryFile); err == nil && ok {
+		return LoadExecutable(inventoryFile, "", 0, options...)
+	}
+
+	f, err := os.Open(inventoryFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	inv, err := LoadReader(f, FormatOf(inventoryFile), options...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory file '%s': %w", inventoryFile, err)
+	}
+
+	return inv, nil
+}
+
+// LoadReader loads inventory of format from r.
+func LoadReader(r io.Reader, format Format, options ...func(*Inventory)) (*Inventory, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := New(options...)
+
+	p := inv.newStructuredParser()
+
+	switch format {
+	case FormatYAML, FormatJSON:
+		err = p.parse(content)
+	case FormatINI:
+		err = p.parseINI(content)
+	default:
+		return nil, fmt.Errorf("invalid inventory format '%s', available formats: %s, %s, %s",
+			format, FormatINI, FormatYAML, FormatJSON)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.build(); err != nil {
+		return nil, err
+	}
+
+	inv.buildAliasHostsMap()
+
+	return inv, nil
+}
+
+// Groups returns names of groups in the order they are defined.
+func (inv *Inventory) Groups() []string {
+	return append([]string(nil), inv.groupOrder...)
+}
+
+// Hosts returns all hosts of the inventory.
+func (inv *Inventory) Hosts() []*Host {
 	var hosts []*Host
 
-	for _, v := range groupOrder {
-		hosts = append(hosts, groupHostsMap[v]...)
+	for _, v := range inv.groupOrder {
+		hosts = append(hosts, inv.groupHostsMap[v]...)
 	}
 
 	return DeDuplHosts(hosts)
 }
 
+// Aliases returns aliases of all hosts of the inventory.
+func (inv *Inventory) Aliases() []string {
+	var aliases []string
+
+	for _, v := range inv.Hosts() {
+		aliases = append(aliases, v.Alias)
+	}
+
+	return aliases
+}
+
+// GroupHosts returns hosts of the group, or nil if there is no such group.
+func (inv *Inventory) GroupHosts(groupName string) []*Host {
+	return inv.groupHostsMap[groupName]
+}
+
+// Children returns child groups of group.
+func (inv *Inventory) Children(groupName string) []string {
+	return inv.groupChildrenMap[groupName]
+}
+
+// HostGroups returns groups that host alias belongs to, directly or through
+// child groups.
+func (inv *Inventory) HostGroups(hostAlias string) []string {
+	var groups []string
+	for _, group := range inv.groupOrder {
+		for _, v := range inv.groupHostsMap[group] {
+			if v.Alias == hostAlias {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+
+	return groups
+}
+
+// HostVars returns merged vars of host alias including the built-in ones,
+// values are not decrypted. It returns nil if alias is not in inventory.
+func (inv *Inventory) HostVars(hostAlias string) map[string]interface{} {
+	host, ok := inv.aliasHostsMap[hostAlias]
+	if !ok {
+		return nil
+	}
+
+	return inv.mergedVars[host]
+}
+
+// HostByAlias returns host by its alias name(the first field), or nil if
+// there is no such host.
+func (inv *Inventory) HostByAlias(hostAlias string) *Host {
+	return inv.aliasHostsMap[hostAlias]
+}
+
+// std is the inventory of package level functions.
+var (
+	stdMu sync.RWMutex
+	std   = New()
+)
+
+func current() *Inventory {
+	stdMu.RLock()
+	defer stdMu.RUnlock()
+
+	return std
+}
+
+// Parse inventory file for package level functions, hosts of the previously
+// parsed inventory file are dropped. Use Load to get an Inventory instead.
+func Parse(inventoryFile string) error {
+	inv, err := Load(inventoryFile)
+	if err != nil {
+		return err
+	}
+
+	stdMu.Lock()
+	std = inv
+	stdMu.Unlock()
+
+	return nil
+}
+
+// GetAllHosts that from inventory file.
+func GetAllHosts() []*Host {
+	return current().Hosts()
+}
+
 // GetHostsByGroup get hosts by host group name.
 func GetHostsByGroup(groupName string) []*Host {
-	return groupHostsMap[groupName]
+	return current().GroupHosts(groupName)
 }
 
 // GetHostByAlias get host by its alias name(the first field).
 func GetHostByAlias(hostAlias string) *Host {
-	return aliasHostsMap[hostAlias]
+	return current().HostByAlias(hostAlias)
 }
 
 // DeDuplHosts deduplicate the hosts.
@@ -148,48 +348,34 @@ func DeDuplHosts(hosts []*Host) []*Host {
 	return set
 }
 
-func buildGroupHostsMap() error {
-	for group, rawHosts := range groupMap {
-		var hosts []*Host
-
-		for _, hostline := range rawHosts {
-			_hosts, err := buildHosts(hostline, group)
-			if err != nil {
-				return err
-			}
-
-			hosts = append(hosts, _hosts...)
-		}
-
-		groupHostsMap[group] = append(groupHostsMap[group], hosts...)
-	}
-
-	for group, children := range groupChildrenMap {
-		var hosts []*Host
-		for _, subGroup := range children {
-			hosts = append(hosts, groupHostsMap[subGroup]...)
+// buildAliasHostsMap maps aliases to hosts, if a host is in more than one
+// group, the one of the group defined last wins.
+func (inv *Inventory) buildAliasHostsMap() {
+	for _, group := range inv.groupOrder {
+		for _, v := range inv.groupHostsMap[group] {
+			inv.aliasHostsMap[v.Alias] = v
 		}
-
-		groupHostsMap[group] = hosts
 	}
-
-	return nil
 }
 
-func buildAliasHostsMap() {
-	for _, hosts := range groupHostsMap {
-		for _, v := range hosts {
-			aliasHostsMap[v.Alias] = v
-		}
-	}
+// rawINI holds lines of sections of INI-like content.
+type rawINI struct {
+	groupOrder       []string
+	groupMap         map[string][]string
+	groupVarMap      map[string][]string
+	groupChildrenMap map[string][]string
 }
 
-func buildRawGroups(inventoryFile string) error {
-	lines, err := parse(inventoryFile)
-	if err != nil {
-		return err
+//nolint:gocyclo
+func parseRawINI(content []byte) (*rawINI, error) {
+	raw := &rawINI{
+		groupMap:         make(map[string][]string),
+		groupVarMap:      make(map[string][]string),
+		groupChildrenMap: make(map[string][]string),
 	}
 
+	lines := parse(content)
+
 	noGroupFlag := 0
 	varGroupFlag := 0
 	childrenGroupFlag := 0
@@ -204,7 +390,7 @@ func buildRawGroups(inventoryFile string) error {
 	for _, v := range lines {
 		v = strings.TrimSpace(v)
 		if err := checkLine(v); err != nil {
-			return err
+			return nil, err
 		}
 
 		switch {
@@ -224,6 +410,9 @@ func buildRawGroups(inventoryFile string) error {
 			}
 			_groupChildrenName := strings.Split(v, groupSplit)[0]
 			groupChildrenName = strings.TrimPrefix(_groupChildrenName, groupSurroundLeft)
+			if !hasEntry(raw.groupOrder, groupChildrenName) {
+				raw.groupOrder = append(raw.groupOrder, groupChildrenName)
+			}
 			childrenGroupFlag = 1
 			noGroupFlag = 0
 			varGroupFlag = 0
@@ -235,7 +424,9 @@ func buildRawGroups(inventoryFile string) error {
 			groupName = strings.TrimPrefix(v, groupSurroundLeft)
 			groupName = strings.TrimSuffix(groupName, groupSurroundRight)
 
-			groupOrder = append(groupOrder, groupName)
+			if !hasEntry(raw.groupOrder, groupName) {
+				raw.groupOrder = append(raw.groupOrder, groupName)
+			}
 
 			noGroupFlag = 0
 			varGroupFlag = 0
@@ -243,25 +434,108 @@ func buildRawGroups(inventoryFile string) error {
 			continue
 		default:
 			if isFirstLine {
-				groupOrder = append(groupOrder, noGroupIdentifier)
+				raw.groupOrder = append(raw.groupOrder, noGroupIdentifier)
 				noGroupFlag = 1
 				isFirstLine = false
 			}
 			if noGroupFlag == 1 {
-				groupMap[noGroupIdentifier] = append(groupMap[noGroupIdentifier], v)
+				raw.groupMap[noGroupIdentifier] = append(raw.groupMap[noGroupIdentifier], v)
 			} else if varGroupFlag == 1 {
-				groupVarMap[groupVarName] = append(groupVarMap[groupVarName], v)
+				raw.groupVarMap[groupVarName] = append(raw.groupVarMap[groupVarName], v)
 			} else if childrenGroupFlag == 1 {
-				groupChildrenMap[groupChildrenName] = append(groupChildrenMap[groupChildrenName], v)
+				raw.groupChildrenMap[groupChildrenName] = append(raw.groupChildrenMap[groupChildrenName], v)
 			} else {
-				groupMap[groupName] = append(groupMap[groupName], v)
+				raw.groupMap[groupName] = append(raw.groupMap[groupName], v)
+			}
+		}
+	}
+
+	return raw, nil
+}
+
+// parseINI parses INI-like content into groups, vars of groups and hosts
+// are kept as strings.
+func (p *structuredParser) parseINI(content []byte) error {
+	raw, err := parseRawINI(content)
+	if err != nil {
+		return err
+	}
+
+	// groups are in the order of their sections rather than the order they
+	// are referred to as children.
+	for _, name := range raw.groupOrder {
+		p.group(name)
+	}
+
+	for _, name := range raw.groupOrder {
+		g := p.group(name)
+
+		for _, hostLine := range raw.groupMap[name] {
+			fields := strings.Fields(hostLine)
+
+			vars, err := parseINIVars(fields[1:], fmt.Sprintf("host entry '%s'", hostLine))
+			if err != nil {
+				return err
+			}
+
+			g.hosts = append(g.hosts, structuredHost{pattern: fields[0], vars: vars})
+		}
+
+		for _, child := range raw.groupChildrenMap[name] {
+			childGroup := p.group(child)
+
+			if !hasEntry(g.children, child) {
+				g.children = append(g.children, child)
 			}
+
+			if !hasEntry(childGroup.parents, name) {
+				childGroup.parents = append(childGroup.parents, name)
+			}
+		}
+	}
+
+	// groups with only vars are added in order of names.
+	varGroups := make([]string, 0, len(raw.groupVarMap))
+	for name := range raw.groupVarMap {
+		varGroups = append(varGroups, name)
+	}
+	sort.Strings(varGroups)
+
+	for _, name := range varGroups {
+		vars, err := parseINIVars(raw.groupVarMap[name], fmt.Sprintf("group vars '[%s:vars]'", name))
+		if err != nil {
+			return err
 		}
+
+		mergeVars(p.group(name).vars, vars)
 	}
 
 	return nil
 }
 
+func parseINIVars(items []string, where string) (map[string]interface{}, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	vars := make(map[string]interface{}, len(items))
+	for _, v := range items {
+		kv := strings.Split(v, hostVarSplit)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf(
+				"invalid host var format '%s' in %s, format must be: varName%svarValue",
+				v,
+				where,
+				hostVarSplit,
+			)
+		}
+
+		vars[kv[0]] = kv[1]
+	}
+
+	return vars, nil
+}
+
 func checkLine(line string) error {
 	if strings.HasPrefix(line, groupSurroundLeft) {
 		if !strings.HasSuffix(line, groupSurroundRight) {
@@ -290,12 +564,7 @@ func checkLine(line string) error {
 	return nil
 }
 
-func parse(file string) ([]string, error) {
-	content, err := ioutil.ReadFile(file)
-	if err != nil {
-		return nil, err
-	}
-
+func parse(content []byte) []string {
 	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
 
 	var newLines []string
@@ -307,152 +576,84 @@ func parse(file string) ([]string, error) {
 		newLines = append(newLines, l)
 	}
 
-	return newLines, nil
+	return newLines
 }
 
-//nolint:funlen,gocyclo
-func buildHosts(hostLine string, group string) ([]*Host, error) {
-	hostFields := strings.Fields(hostLine)
-
-	hostAlias := hostFields[0]
-
-	var (
-		host       string
-		port       int
-		user       string
-		password   string
-		keys       []string
-		passphrase string
-
-		err error
-	)
-
-	varsMap := make(map[string]string)
-	vars := groupVarMap[group]
-	for _, v := range vars {
-		kv := strings.Split(v, hostVarSplit)
-		if len(kv) != 2 {
-			return nil, fmt.Errorf(
-				"invalid host var format '%s' in group vars '[%s:vars]', format must be: varName%svarValue",
-				v,
-				group,
-				hostVarSplit,
-			)
-		}
-
-		varName := kv[0]
-		if !hasEntry(hostVars, varName) {
-			return nil, fmt.Errorf(
-				"indvalid host var '%s' in group vars '[%s:vars]', available vars: %s",
-				varName,
-				group,
-				hostVars,
-			)
+func hasEntry(items []string, item string) bool {
+	for _, v := range items {
+		if v == item {
+			return true
 		}
-
-		varsMap[kv[0]] = kv[1]
 	}
+	return false
+}
 
-	if _host, ok := varsMap[hostVarsMap[hostVarHost]]; ok {
-		host = _host
-	}
-	if _port, ok := varsMap[hostVarsMap[hostVarPort]]; ok {
-		portInt, err1 := strconv.Atoi(_port)
-		if err1 != nil {
-			return nil, fmt.Errorf("invalid port: %s", _port)
+// ancestors returns ancestor groups of group, farther ones first.
+func ancestors(group string, parents func(string) []string, visited map[string]bool) []string {
+	var groups []string
+
+	for _, parent := range parents(group) {
+		if visited[parent] {
+			continue
 		}
+		visited[parent] = true
 
-		port = portInt
-	}
-	if _user, ok := varsMap[hostVarsMap[hostVarUser]]; ok {
-		user = _user
-	}
-	if _password, ok := varsMap[hostVarsMap[hostVarPassword]]; ok {
-		password = _password
-	}
-	if _keys, ok := varsMap[hostVarsMap[hostVarKeys]]; ok {
-		keys = strings.Split(_keys, ",")
-	}
-	if _passphrase, ok := varsMap[hostVarsMap[hostVarPassphrase]]; ok {
-		passphrase = _passphrase
+		groups = append(groups, ancestors(parent, parents, visited)...)
+		groups = append(groups, parent)
 	}
 
-	if len(hostFields) > 1 {
-		for _, v := range hostFields[1:] {
-			items := strings.Split(v, hostVarSplit)
+	return groups
+}
 
-			if len(items) != 2 {
-				return nil, fmt.Errorf(
-					"indvalid host var format '%s' in host entry '%s', format must be: varName%svarValue",
-					v,
-					hostLine,
-					hostVarSplit,
-				)
-			}
+// checkCycles returns error if a group is a descendant of itself.
+func checkCycles(groups []string, children func(string) []string) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
 
-			hostVar := items[0]
-			varValue := items[1]
+	var (
+		path  []string
+		visit func(group string) error
+	)
 
-			switch hostVar {
-			case hostVarsMap[hostVarHost]:
-				host = varValue
-			case hostVarsMap[hostVarPort]:
-				port, err = strconv.Atoi(varValue)
-				if err != nil {
-					return nil, err
+	state := make(map[string]int)
+
+	visit = func(group string) error {
+		switch state[group] {
+		case visited:
+			return nil
+		case visiting:
+			for i, v := range path {
+				if v == group {
+					path = append(path[i:], group)
+					break
 				}
-			case hostVarsMap[hostVarUser]:
-				user = varValue
-			case hostVarsMap[hostVarPassword]:
-				password = varValue
-			case hostVarsMap[hostVarKeys]:
-				keys = strings.Split(varValue, ",")
-			case hostVarsMap[hostVarPassphrase]:
-				passphrase = varValue
-			default:
-				return nil, fmt.Errorf(
-					"indvalid host var '%s' in host entry '%s', available vars: %s",
-					hostVar,
-					hostLine,
-					hostVars,
-				)
 			}
+
+			return fmt.Errorf("cycle in children of groups: %s", strings.Join(path, " -> "))
 		}
-	}
 
-	aliasList, err := expandhost.PatternToHosts(hostAlias)
-	if err != nil {
-		return nil, err
-	}
+		state[group] = visiting
+		path = append(path, group)
 
-	var hosts []*Host
-	for _, v := range aliasList {
-		var _host string
-		if host == "" {
-			_host = v
-		} else {
-			_host = host
+		for _, child := range children(group) {
+			if err := visit(child); err != nil {
+				return err
+			}
 		}
 
-		hosts = append(hosts, &Host{
-			Alias:      v,
-			Host:       _host,
-			Port:       port,
-			User:       user,
-			Password:   password,
-			Keys:       keys,
-			Passphrase: passphrase,
-		})
-	}
+		path = path[:len(path)-1]
+		state[group] = visited
 
-	return hosts, nil
-}
+		return nil
+	}
 
-func hasEntry(items []string, item string) bool {
-	for _, v := range items {
-		if v == item {
-			return true
+	for _, group := range groups {
+		if err := visit(group); err != nil {
+			return err
 		}
 	}
-	return false
+
+	return nil
 }
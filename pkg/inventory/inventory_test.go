@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -202,3 +203,153 @@ func TestGetHostByAlias(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadReader(t *testing.T) {
+	inv1, err := LoadReader(strings.NewReader("[web]\nweb[1-2] user=ops\n"), FormatINI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inv2, err := LoadReader(strings.NewReader("db:\n  hosts: [db1]\n"), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := inv1.Aliases(), []string{"web1", "web2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Aliases() = %v, want %v", got, want)
+	}
+
+	if got, want := inv2.Groups(), []string{"db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Groups() = %v, want %v", got, want)
+	}
+
+	if got := inv1.GroupHosts("db"); got != nil {
+		t.Errorf("GroupHosts() = %v, want nil", got)
+	}
+
+	if got, want := inv1.HostByAlias("web2"), (&Host{Alias: "web2", Host: "web2", User: "ops"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("HostByAlias() = %v, want %v", got, want)
+	}
+
+	if _, err := LoadReader(strings.NewReader(""), Format("toml")); err == nil {
+		t.Error("LoadReader() with invalid format should fail")
+	}
+}
+
+func TestNestedChildren(t *testing.T) {
+	content := `# children defined before their groups
+[prod:children]
+dc1
+dc2
+
+[prod:vars]
+user=ops
+env=prod
+
+[dc1:children]
+web1
+db1
+
+[dc1:vars]
+port=2222
+dc=dc1
+
+[web1]
+web[01-02] env=canary
+
+[web1:vars]
+role=web
+
+[db1]
+db01 port=3306
+db01
+
+[dc2]
+web03
+
+[dc2:vars]
+user=admin
+`
+
+	inv, err := LoadReader(strings.NewReader(content), FormatINI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web01 := &Host{
+		Alias: "web01", Host: "web01", Port: 2222, User: "ops",
+		Vars: map[string]interface{}{"env": "canary", "dc": "dc1", "role": "web"},
+	}
+	web02 := &Host{
+		Alias: "web02", Host: "web02", Port: 2222, User: "ops",
+		Vars: map[string]interface{}{"env": "canary", "dc": "dc1", "role": "web"},
+	}
+	db01 := &Host{
+		Alias: "db01", Host: "db01", Port: 3306, User: "ops",
+		Vars: map[string]interface{}{"env": "prod", "dc": "dc1"},
+	}
+	web03 := &Host{
+		Alias: "web03", Host: "web03", User: "admin",
+		Vars: map[string]interface{}{"env": "prod"},
+	}
+
+	tests := []struct {
+		group string
+		want  []*Host
+	}{
+		{group: "prod", want: []*Host{web01, web02, db01, web03}},
+		{group: "dc1", want: []*Host{web01, web02, db01}},
+		{group: "web1", want: []*Host{web01, web02}},
+		{group: "db1", want: []*Host{db01}},
+		{group: "dc2", want: []*Host{web03}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.group, func(t *testing.T) {
+			if got := inv.GroupHosts(tt.group); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GroupHosts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := inv.Groups(), []string{"prod", "dc1", "web1", "db1", "dc2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Groups() = %v, want %v", got, want)
+	}
+}
+
+func TestChildrenCycle(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		format  Format
+		want    string
+	}{
+		{
+			name:    "ini",
+			content: "[a:children]\nb\n[b:children]\nc\n[c:children]\na\n",
+			format:  FormatINI,
+			want:    "cycle in children of groups: a -> b -> c -> a",
+		},
+		{
+			name:    "ini self",
+			content: "[a]\nhost1\n[a:children]\na\n",
+			format:  FormatINI,
+			want:    "cycle in children of groups: a -> a",
+		},
+		{
+			name:    "yaml",
+			content: "a:\n  children:\n    b:\n      children: [c]\nc:\n  children: [b]\n",
+			format:  FormatYAML,
+			want:    "cycle in children of groups: b -> c -> b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadReader(strings.NewReader(tt.content), tt.format)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("LoadReader() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
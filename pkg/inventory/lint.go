@@ -0,0 +1,701 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-project-pkg/expandhost"
+	"gopkg.in/yaml.v3"
+
+	"github.com/serialt/gosible/pkg/util"
+)
+
+// levels of lint issues.
+const (
+	LevelError   = "error"
+	LevelWarning = "warning"
+)
+
+// yamlErrorLineRegex gets line number from errors of yaml like
+// 'yaml: line 3: mapping values are not allowed in this context'.
+var yamlErrorLineRegex = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// Issue is a problem of inventory found by Lint.
+type Issue struct {
+	File string
+	// Line is 0 if the issue is not of a line.
+	Line    int
+	Level   string
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.Line == 0 {
+		return fmt.Sprintf("%s: %s: %s", i.File, i.Level, i.Message)
+	}
+
+	return fmt.Sprintf("%s:%d: %s: %s", i.File, i.Line, i.Level, i.Message)
+}
+
+// location of a definition in inventory files.
+type location struct {
+	file string
+	line int
+}
+
+func (l location) String() string {
+	return fmt.Sprintf("%s:%d", l.file, l.line)
+}
+
+type aliasDef struct {
+	group   string
+	loc     location
+	hasVars bool
+}
+
+type childRef struct {
+	parent string
+	child  string
+	loc    location
+}
+
+// linter collects definitions of all inventory files, so that references
+// across files are checked after all files are linted.
+type linter struct {
+	inv    *Inventory
+	issues []Issue
+
+	groupOrder []string
+	groups     map[string]location
+	children   map[string][]string
+	childRefs  []childRef
+	varGroups  []childRef
+
+	aliasOrder []string
+	aliases    map[string][]aliasDef
+}
+
+// WithEncrypted sets the function that reports whether a value is encrypted,
+// encrypted values are not checked by Lint.
+func WithEncrypted(isEncrypted func(value string) bool) func(*Inventory) {
+	return func(inv *Inventory) {
+		inv.isEncrypted = isEncrypted
+	}
+}
+
+// Lint checks inventory file or directory like Load, all issues are reported
+// instead of the first error: unparseable files and host patterns, undefined
+// children groups, cycles of children, duplicate aliases, invalid ports, and
+// unknown variables that look like misspelled built-in variables. Executable
+// files are checked by their outputs. It returns error only if the inventory
+// can not be read.
+func Lint(path string, options ...func(*Inventory)) ([]Issue, error) {
+	l := &linter{
+		inv:      New(options...),
+		groups:   make(map[string]location),
+		children: make(map[string][]string),
+		aliases:  make(map[string][]aliasDef),
+	}
+
+	if !util.DirExists(path) {
+		if err := l.lintFile(path); err != nil {
+			return nil, err
+		}
+
+		l.lintReferences(path, nil, nil)
+
+		return l.issues, nil
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || skipFile(entry.Name()) {
+			continue
+		}
+
+		if err := l.lintFile(filepath.Join(path, entry.Name())); err != nil {
+			return nil, err
+		}
+	}
+
+	groupVarsFiles, err := l.lintVarsFiles(filepath.Join(path, groupVarsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	hostVarsFiles, err := l.lintVarsFiles(filepath.Join(path, hostVarsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	l.lintReferences(path, groupVarsFiles, hostVarsFiles)
+
+	return l.issues, nil
+}
+
+func (l *linter) add(loc location, level string, format string, args ...interface{}) {
+	l.issues = append(l.issues, Issue{
+		File:    loc.file,
+		Line:    loc.line,
+		Level:   level,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// addYAMLError adds error of yaml at the line in the error message.
+func (l *linter) addYAMLError(file string, err error) {
+	loc := location{file: file}
+	msg := err.Error()
+
+	if m := yamlErrorLineRegex.FindStringSubmatch(msg); m != nil {
+		loc.line, _ = strconv.Atoi(m[1])
+		msg = strings.TrimPrefix(msg, m[0])
+	}
+	msg = strings.TrimPrefix(msg, "yaml: ")
+
+	l.add(loc, LevelError, "%s", msg)
+}
+
+func (l *linter) lintFile(file string) error {
+	if ok, _ := util.IsExecutable(file); ok {
+		content, err := executableOutput(file)
+		if err != nil {
+			l.add(location{file: file}, LevelError, "%s", err)
+			return nil
+		}
+
+		l.lintStructured(file+" "+dynamicArgList, content)
+
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	if FormatOf(file) == FormatINI {
+		l.lintINI(file, content)
+	} else {
+		l.lintStructured(file, content)
+	}
+
+	return nil
+}
+
+func (l *linter) defineGroup(name string, loc location) {
+	if _, ok := l.groups[name]; !ok {
+		l.groups[name] = loc
+		l.groupOrder = append(l.groupOrder, name)
+	}
+}
+
+func (l *linter) addChild(parent, child string, loc location) {
+	if !hasEntry(l.children[parent], child) {
+		l.children[parent] = append(l.children[parent], child)
+	}
+
+	l.childRefs = append(l.childRefs, childRef{parent: parent, child: child, loc: loc})
+}
+
+// addHosts checks host pattern and records aliases of it.
+func (l *linter) addHosts(pattern, group string, loc location, hasVars bool) {
+	aliases, err := expandhost.PatternToHosts(pattern)
+	if err != nil {
+		l.add(loc, LevelError, "invalid host pattern '%s': %s", pattern, err)
+		return
+	}
+
+	for _, alias := range aliases {
+		if _, ok := l.aliases[alias]; !ok {
+			l.aliasOrder = append(l.aliasOrder, alias)
+		}
+
+		l.aliases[alias] = append(l.aliases[alias], aliasDef{group: group, loc: loc, hasVars: hasVars})
+	}
+}
+
+// lintVar checks variable name and value of owner.
+func (l *linter) lintVar(loc location, owner, name string, value interface{}) {
+	isPort := name == hostVarsMap[hostVarPort] || name == "ansible_port" || name == "ansible_ssh_port"
+	if isPort && value != nil {
+		s := fmt.Sprint(value)
+		if l.inv.isEncrypted != nil && l.inv.isEncrypted(s) {
+			return
+		}
+
+		if port, err := strconv.Atoi(s); err != nil || port < 1 || port > 65535 {
+			l.add(loc, LevelError, "invalid port '%s' of %s, port must be between 1 and 65535", s, owner)
+		}
+
+		return
+	}
+
+	if hasEntry(hostVars, name) {
+		return
+	}
+
+	for _, v := range ansibleHostVars {
+		if v.name == name {
+			return
+		}
+	}
+
+	if similar := similarHostVar(name); similar != "" {
+		l.add(loc, LevelWarning, "unknown variable '%s' of %s, did you mean '%s'?", name, owner, similar)
+	}
+}
+
+// similarHostVar returns the built-in variable that name looks like a
+// misspelling of, or empty string.
+func similarHostVar(name string) string {
+	lower := strings.ToLower(name)
+
+	for _, v := range hostVars {
+		if lower == v || editDistance(lower, v) <= len(v)/3 {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// editDistance is the optimal string alignment distance of a and b, that
+// is Levenshtein distance that also counts transpositions like 'prot'.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = minInt(minInt(d[i-1][j]+1, d[i][j-1]+1), d[i-1][j-1]+cost)
+
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// lintINI checks INI-like inventory content.
+//
+//nolint:funlen,gocyclo
+func (l *linter) lintINI(file string, content []byte) {
+	group := noGroupIdentifier
+	prop := ""
+	invalidSection := false
+	hasHosts := false
+
+	for i, line := range strings.Split(string(content), "\n") {
+		if line == "" || strings.HasPrefix(line, comment) {
+			continue
+		}
+
+		line = strings.TrimSpace(line)
+		loc := location{file: file, line: i + 1}
+
+		if strings.HasPrefix(line, groupSurroundLeft) {
+			if err := checkLine(line); err != nil {
+				l.add(loc, LevelError, "%s", err)
+				invalidSection = true
+				continue
+			}
+
+			invalidSection = false
+
+			parts := strings.Split(strings.Trim(line, groupSurroundLeft+groupSurroundRight), groupSplit)
+			group, prop = parts[0], ""
+			if len(parts) == 2 {
+				prop = parts[1]
+			}
+
+			switch prop {
+			case groupVar:
+				l.varGroups = append(l.varGroups, childRef{child: group, loc: loc})
+			default:
+				l.defineGroup(group, loc)
+			}
+
+			continue
+		}
+
+		if invalidSection || line == "" {
+			continue
+		}
+
+		switch prop {
+		case groupVar:
+			kv := strings.Split(line, hostVarSplit)
+			if len(kv) != 2 {
+				l.add(loc, LevelError, "invalid var format '%s' in group vars '[%s:vars]', format must be: varName%svarValue",
+					line, group, hostVarSplit)
+				continue
+			}
+
+			l.lintVar(loc, fmt.Sprintf("group '%s'", group), kv[0], kv[1])
+		case groupChildren:
+			l.addChild(group, line, loc)
+		default:
+			if group == noGroupIdentifier && !hasHosts {
+				l.defineGroup(group, loc)
+			}
+			hasHosts = true
+
+			fields := strings.Fields(line)
+			for _, v := range fields[1:] {
+				kv := strings.Split(v, hostVarSplit)
+				if len(kv) != 2 {
+					l.add(loc, LevelError, "invalid host var format '%s' in host entry '%s', format must be: varName%svarValue",
+						v, line, hostVarSplit)
+					continue
+				}
+
+				l.lintVar(loc, fmt.Sprintf("host '%s'", fields[0]), kv[0], kv[1])
+			}
+
+			l.addHosts(fields[0], group, loc, len(fields) > 1)
+		}
+	}
+}
+
+// lintStructured checks YAML/JSON inventory content.
+func (l *linter) lintStructured(file string, content []byte) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		l.addYAMLError(file, err)
+		return
+	}
+
+	if len(root.Content) == 0 || isNull(root.Content[0]) {
+		return
+	}
+
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		l.add(location{file: file, line: doc.Line}, LevelError, "top level must be a map of groups")
+		return
+	}
+
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key, value := doc.Content[i], doc.Content[i+1]
+
+		if key.Value == metaGroup {
+			l.lintMeta(file, value)
+			continue
+		}
+
+		l.lintGroup(file, key, value, "")
+	}
+}
+
+func (l *linter) lintMeta(file string, node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != metaHostVars || node.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+
+		hostVars := node.Content[i+1]
+		for j := 0; j+1 < len(hostVars.Content); j += 2 {
+			l.lintVarsNode(file, fmt.Sprintf("host '%s'", hostVars.Content[j].Value), hostVars.Content[j+1])
+		}
+	}
+}
+
+// lintGroup checks group node, the group is defined if node is not null or
+// it is not a child group listed by name.
+//
+//nolint:gocyclo
+func (l *linter) lintGroup(file string, key, node *yaml.Node, parent string) {
+	name := key.Value
+	loc := location{file: file, line: key.Line}
+
+	if name == "" {
+		l.add(loc, LevelError, "empty group name")
+		return
+	}
+
+	if parent != "" {
+		l.addChild(parent, name, loc)
+	}
+
+	if parent == "" || !isNull(node) {
+		l.defineGroup(name, loc)
+	}
+
+	switch {
+	case isNull(node):
+		return
+	case node.Kind == yaml.SequenceNode:
+		l.lintHostsNode(file, name, node)
+		return
+	case node.Kind != yaml.MappingNode:
+		l.add(location{file: file, line: node.Line}, LevelError, "group '%s' must be a map of %s, %s, %s",
+			name, groupPropHosts, groupPropVars, groupPropChildren)
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+
+		switch k.Value {
+		case groupPropHosts:
+			l.lintHostsNode(file, name, v)
+		case groupPropVars:
+			l.lintVarsNode(file, fmt.Sprintf("group '%s'", name), v)
+		case groupPropChildren:
+			switch {
+			case isNull(v):
+			case v.Kind == yaml.SequenceNode:
+				for _, child := range v.Content {
+					l.lintGroup(file, child, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}, name)
+				}
+			case v.Kind == yaml.MappingNode:
+				for j := 0; j+1 < len(v.Content); j += 2 {
+					l.lintGroup(file, v.Content[j], v.Content[j+1], name)
+					l.defineGroup(v.Content[j].Value, location{file: file, line: v.Content[j].Line})
+				}
+			default:
+				l.add(location{file: file, line: v.Line}, LevelError, "children of group '%s' must be a map or a list", name)
+			}
+		default:
+			l.add(location{file: file, line: k.Line}, LevelError,
+				"invalid property '%s' of group '%s', available properties: %s, %s, %s",
+				k.Value, name, groupPropHosts, groupPropVars, groupPropChildren)
+		}
+	}
+}
+
+func (l *linter) lintHostsNode(file, group string, node *yaml.Node) {
+	switch {
+	case isNull(node):
+	case node.Kind == yaml.SequenceNode:
+		for _, v := range node.Content {
+			if v.Kind != yaml.ScalarNode {
+				l.add(location{file: file, line: v.Line}, LevelError, "invalid host of group '%s'", group)
+				continue
+			}
+
+			l.addHosts(v.Value, group, location{file: file, line: v.Line}, false)
+		}
+	case node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+
+			l.lintVarsNode(file, fmt.Sprintf("host '%s'", k.Value), v)
+			l.addHosts(k.Value, group, location{file: file, line: k.Line}, !isNull(v))
+		}
+	default:
+		l.add(location{file: file, line: node.Line}, LevelError, "hosts of group '%s' must be a map or a list", group)
+	}
+}
+
+func (l *linter) lintVarsNode(file, owner string, node *yaml.Node) {
+	if isNull(node) {
+		return
+	}
+
+	if node.Kind != yaml.MappingNode {
+		l.add(location{file: file, line: node.Line}, LevelError, "vars of %s must be a map", owner)
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+
+		var value interface{}
+		if v.Kind == yaml.ScalarNode && !isNull(v) {
+			value = v.Value
+		}
+
+		l.lintVar(location{file: file, line: k.Line}, owner, k.Value, value)
+	}
+}
+
+// lintVarsFiles checks vars files in dir like 'group_vars', it returns names
+// of groups or hosts and locations of their vars files.
+func (l *linter) lintVarsFiles(dir string) (map[string]location, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if util.DirExists(dir) {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	names := make(map[string]location)
+
+	for _, entry := range entries {
+		if skipFile(entry.Name()) {
+			continue
+		}
+
+		file := filepath.Join(dir, entry.Name())
+		name := varsName(entry.Name())
+
+		files := []string{file}
+		if entry.IsDir() {
+			name = entry.Name()
+
+			subEntries, err := ioutil.ReadDir(file)
+			if err != nil {
+				return nil, err
+			}
+
+			files = nil
+			for _, v := range subEntries {
+				if !v.IsDir() && !skipFile(v.Name()) {
+					files = append(files, filepath.Join(file, v.Name()))
+				}
+			}
+		}
+
+		names[name] = location{file: file}
+
+		owner := fmt.Sprintf("group '%s'", name)
+		if filepath.Base(dir) == hostVarsDir {
+			owner = fmt.Sprintf("host '%s'", name)
+		}
+
+		for _, v := range files {
+			content, err := ioutil.ReadFile(v)
+			if err != nil {
+				return nil, err
+			}
+
+			var root yaml.Node
+			if err := yaml.Unmarshal(content, &root); err != nil {
+				l.addYAMLError(v, err)
+				continue
+			}
+
+			if len(root.Content) != 0 {
+				l.lintVarsNode(v, owner, root.Content[0])
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// lintReferences checks children groups, cycles, duplicate aliases, and
+// vars files of unknown groups and hosts.
+//
+//nolint:gocyclo
+func (l *linter) lintReferences(path string, groupVarsFiles, hostVarsFiles map[string]location) {
+	for _, ref := range l.childRefs {
+		if _, ok := l.groups[ref.child]; !ok {
+			l.add(ref.loc, LevelWarning, "child group '%s' of group '%s' is not defined", ref.child, ref.parent)
+		}
+	}
+
+	for _, ref := range l.varGroups {
+		if _, ok := l.groups[ref.child]; !ok && ref.child != allGroup {
+			l.add(ref.loc, LevelWarning, "vars of group '%s' that is not defined", ref.child)
+		}
+	}
+
+	if err := checkCycles(l.groupOrder, func(group string) []string { return l.children[group] }); err != nil {
+		l.add(location{file: path}, LevelError, "%s", err)
+	}
+
+	for _, alias := range l.aliasOrder {
+		defs := l.aliases[alias]
+
+		for i := 1; i < len(defs); i++ {
+			for j := 0; j < i; j++ {
+				switch {
+				case defs[i].group == defs[j].group:
+					l.add(defs[i].loc, LevelWarning, "duplicate alias '%s' in group '%s', first defined at %s",
+						alias, defs[i].group, defs[j].loc)
+				case defs[i].hasVars && defs[j].hasVars:
+					l.add(defs[i].loc, LevelWarning,
+						"duplicate alias '%s' with vars, also defined at %s, vars of the group defined last win",
+						alias, defs[j].loc)
+				default:
+					continue
+				}
+
+				break
+			}
+		}
+	}
+
+	for _, name := range sortedNames(groupVarsFiles) {
+		if _, ok := l.groups[name]; !ok && name != allGroup {
+			l.add(groupVarsFiles[name], LevelWarning, "vars file of group '%s' that is not defined", name)
+		}
+	}
+
+	for _, name := range sortedNames(hostVarsFiles) {
+		if _, ok := l.aliases[name]; !ok {
+			l.add(hostVarsFiles[name], LevelWarning, "vars file of host '%s' that is not defined", name)
+		}
+	}
+}
+
+func sortedNames(m map[string]location) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,85 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"hosts": `[web]
+web[01-02] prot=22
+web01 port=99999
+web[05-]
+
+[web:vars]
+port=ENC:22
+
+[prod:children]
+web
+db
+missing
+
+[prod:children]
+prod
+`,
+		"db.yaml": `
+db:
+  hosts:
+    db1: {port: 0}
+    web02: {user: ops}
+  color: red
+`,
+		"bad.json":            `{"a": [}`,
+		"group_vars/web.yaml": "usr: ops\n",
+		"host_vars/web09":     "env: dev\n",
+	}
+
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	issues, err := Lint(dir, WithEncrypted(func(value string) bool {
+		return strings.HasPrefix(value, "ENC:")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, v := range issues {
+		got = append(got, strings.TrimPrefix(v.String(), dir+string(filepath.Separator)))
+	}
+
+	want := []string{
+		"bad.json: error: did not find expected node content",
+		"db.yaml:4: error: invalid port '0' of host 'db1', port must be between 1 and 65535",
+		"db.yaml:6: error: invalid property 'color' of group 'db', available properties: hosts, vars, children",
+		"hosts:2: warning: unknown variable 'prot' of host 'web[01-02]', did you mean 'port'?",
+		"hosts:3: error: invalid port '99999' of host 'web01', port must be between 1 and 65535",
+		"hosts:4: error: invalid host pattern 'web[05-]': strconv.Atoi: parsing \"\": invalid syntax",
+		"group_vars/web.yaml:1: warning: unknown variable 'usr' of group 'web', did you mean 'user'?",
+		"hosts:12: warning: child group 'missing' of group 'prod' is not defined",
+		dir + ": error: cycle in children of groups: prod -> prod",
+		"hosts:2: warning: duplicate alias 'web02' with vars, also defined at " + filepath.Join(dir, "db.yaml") + ":5, " +
+			"vars of the group defined last win",
+		"hosts:3: warning: duplicate alias 'web01' in group 'web', first defined at " + filepath.Join(dir, "hosts") + ":2",
+		"host_vars/web09: warning: vars file of host 'web09' that is not defined",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
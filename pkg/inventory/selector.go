@@ -0,0 +1,261 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/go-project-pkg/expandhost"
+)
+
+// prefixes of selector terms.
+const (
+	selectorIntersection = "&"
+	selectorExclusion    = "!"
+	selectorRegexp       = "~"
+)
+
+// Select hosts by patterns, each pattern is terms separated by ':' or ',':
+//
+//	web:db          hosts in web or db
+//	web:&prod       hosts in both web and prod
+//	web:!web03      hosts in web except web03
+//	web*.idc1.*     groups or hosts matching the wildcards
+//	~db[0-9]+       groups or hosts matching the regular expression
+//	node[01-03]     groups or hosts expanded by the host pattern
+//
+// Like wildcards, a regular expression must match the whole name of a group
+// or host, as if it was enclosed in '^(?:' and ')$'.
+//
+// Hosts of all union terms are selected first, then they are restricted by
+// intersection terms, and excluded by exclusion terms. If there are no union
+// terms, all hosts are restricted or excluded. A host that is not in
+// the inventory is selected by its name as is.
+func (inv *Inventory) Select(patterns ...string) ([]*Host, error) {
+	return inv.selectHosts(nil, patterns)
+}
+
+// Filter returns hosts that are selected by patterns, wildcards and regular
+// expressions also match hosts not in the inventory.
+func (inv *Inventory) Filter(hosts []*Host, patterns ...string) ([]*Host, error) {
+	selected, err := inv.selectHosts(hosts, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool, len(selected))
+	for _, v := range selected {
+		set[v.Alias] = true
+	}
+
+	var filtered []*Host
+	for _, v := range hosts {
+		if set[v.Alias] {
+			filtered = append(filtered, v)
+		}
+	}
+
+	return filtered, nil
+}
+
+func (inv *Inventory) selectHosts(extraHosts []*Host, patterns []string) ([]*Host, error) {
+	var (
+		union         []*Host
+		intersections [][]*Host
+		exclusions    []*Host
+		hasUnion      bool
+	)
+
+	for _, pattern := range patterns {
+		for _, term := range splitPattern(pattern) {
+			switch {
+			case strings.HasPrefix(term, selectorIntersection):
+				hosts, err := inv.matchTerm(term[1:], extraHosts)
+				if err != nil {
+					return nil, err
+				}
+				intersections = append(intersections, hosts)
+			case strings.HasPrefix(term, selectorExclusion):
+				hosts, err := inv.matchTerm(term[1:], extraHosts)
+				if err != nil {
+					return nil, err
+				}
+				exclusions = append(exclusions, hosts...)
+			default:
+				hosts, err := inv.matchTerm(term, extraHosts)
+				if err != nil {
+					return nil, err
+				}
+				union = append(union, hosts...)
+				hasUnion = true
+			}
+		}
+	}
+
+	if !hasUnion {
+		union = append(inv.Hosts(), extraHosts...)
+	}
+
+	selected := DeDuplHosts(union)
+
+	for _, hosts := range intersections {
+		selected = hostsIn(selected, hosts, true)
+	}
+
+	return hostsIn(selected, exclusions, false), nil
+}
+
+// matchTerm returns hosts matched by a term without prefix '&' or '!'.
+func (inv *Inventory) matchTerm(term string, extraHosts []*Host) ([]*Host, error) {
+	if term == "" {
+		return nil, nil
+	}
+
+	var re *regexp.Regexp
+
+	switch {
+	case strings.HasPrefix(term, selectorRegexp):
+		var err error
+		if re, err = regexp.Compile("^(?:" + term[1:] + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid regular expression '%s': %s", term[1:], err)
+		}
+	case strings.ContainsAny(term, "*?"):
+		re = wildcardRegexp(term)
+	default:
+		return inv.matchNames(term, extraHosts)
+	}
+
+	var hosts []*Host
+
+	for _, group := range inv.groupOrder {
+		if re.MatchString(group) {
+			hosts = append(hosts, inv.groupHostsMap[group]...)
+		}
+	}
+
+	for _, v := range append(inv.Hosts(), extraHosts...) {
+		if re.MatchString(v.Alias) {
+			hosts = append(hosts, v)
+		}
+	}
+
+	return hosts, nil
+}
+
+// matchNames returns hosts of groups or aliases expanded by host pattern.
+func (inv *Inventory) matchNames(pattern string, extraHosts []*Host) ([]*Host, error) {
+	names, err := expandhost.PatternToHosts(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host pattern: %s", err)
+	}
+
+	var hosts []*Host
+
+	for _, name := range names {
+		if groupHosts := inv.GroupHosts(name); groupHosts != nil {
+			hosts = append(hosts, groupHosts...)
+			continue
+		}
+
+		if host := inv.HostByAlias(name); host != nil {
+			hosts = append(hosts, host)
+			continue
+		}
+
+		host := &Host{Alias: name, Host: name}
+		for _, v := range extraHosts {
+			if v.Alias == name {
+				host = v
+				break
+			}
+		}
+
+		hosts = append(hosts, host)
+	}
+
+	return hosts, nil
+}
+
+// hostsIn returns hosts that are (or are not) in others.
+func hostsIn(hosts, others []*Host, in bool) []*Host {
+	set := make(map[string]bool, len(others))
+	for _, v := range others {
+		set[v.Alias] = true
+	}
+
+	var result []*Host
+	for _, v := range hosts {
+		if set[v.Alias] == in {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+// wildcardRegexp converts wildcards '*' and '?' to a regular expression that
+// matches the whole string.
+func wildcardRegexp(pattern string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+// splitPattern splits pattern into terms by ':' and ',' that are not in
+// brackets or braces, so that host patterns like 'foo[01-03,06]' are kept. IPv6
+// addresses are not split.
+func splitPattern(pattern string) []string {
+	pattern = strings.TrimSpace(pattern)
+	if net.ParseIP(pattern) != nil {
+		return []string{pattern}
+	}
+
+	var (
+		terms []string
+		depth int
+		start int
+	)
+
+	for i, c := range pattern {
+		switch c {
+		case '[', '{', '(':
+			depth++
+		case ']', '}', ')':
+			if depth > 0 {
+				depth--
+			}
+		case ':', ',':
+			if depth == 0 {
+				terms = append(terms, strings.TrimSpace(pattern[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	return append(terms, strings.TrimSpace(pattern[start:]))
+}
@@ -0,0 +1,138 @@
+package inventory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	content := `[web]
+web[01-03].idc1.sre.im
+web04.idc2.sre.im
+
+[db]
+db1
+db22
+
+[prod]
+web01.idc1.sre.im
+db1
+`
+
+	inv, err := LoadReader(strings.NewReader(content), FormatINI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{
+			name:     "union",
+			patterns: []string{"web04.idc2.sre.im:db"},
+			want:     []string{"web04.idc2.sre.im", "db1", "db22"},
+		},
+		{
+			name:     "union of patterns",
+			patterns: []string{"db1", "db22,web04.idc2.sre.im"},
+			want:     []string{"db1", "db22", "web04.idc2.sre.im"},
+		},
+		{
+			name:     "intersection",
+			patterns: []string{"web:db:&prod"},
+			want:     []string{"web01.idc1.sre.im", "db1"},
+		},
+		{
+			name:     "exclusion",
+			patterns: []string{"web:!web0[1-2].idc1.sre.im"},
+			want:     []string{"web03.idc1.sre.im", "web04.idc2.sre.im"},
+		},
+		{
+			name:     "exclusion only",
+			patterns: []string{"!web"},
+			want:     []string{"db1", "db22"},
+		},
+		{
+			name:     "wildcard",
+			patterns: []string{"web*.idc1.*"},
+			want:     []string{"web01.idc1.sre.im", "web02.idc1.sre.im", "web03.idc1.sre.im"},
+		},
+		{
+			name:     "wildcard of groups",
+			patterns: []string{"pro?"},
+			want:     []string{"web01.idc1.sre.im", "db1"},
+		},
+		{
+			name:     "regexp",
+			patterns: []string{"~^db[0-9]{2}$"},
+			want:     []string{"db22"},
+		},
+		{
+			name:     "regexp matches whole names",
+			patterns: []string{"~db[0-9]"},
+			want:     []string{"db1"},
+		},
+		{
+			name:     "regexp with alternation",
+			patterns: []string{"~d|db22"},
+			want:     []string{"db22"},
+		},
+		{
+			name:     "host pattern with commas",
+			patterns: []string{"web0[1,3].idc1.sre.im:new[1-2]"},
+			want:     []string{"web01.idc1.sre.im", "web03.idc1.sre.im", "new1", "new2"},
+		},
+		{
+			name:     "ipv6",
+			patterns: []string{"fe80::1"},
+			want:     []string{"fe80::1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, err := inv.Select(tt.patterns...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, v := range hosts {
+				got = append(got, v.Alias)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Select() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := inv.Select("~db("); err == nil {
+		t.Error("Select() with invalid regular expression should fail")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	inv, err := LoadReader(strings.NewReader("[web]\nweb1\nweb2\n"), FormatINI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := []*Host{
+		{Alias: "web2", Host: "web2"},
+		{Alias: "other1", Host: "other1"},
+		{Alias: "other2", Host: "other2"},
+	}
+
+	got, err := inv.Filter(hosts, "web:other*:!other2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := hosts[:2]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Filter() = %v, want %v", got, want)
+	}
+}
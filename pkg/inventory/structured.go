@@ -0,0 +1,435 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package inventory
+
+import (
+	"fmt"
+
+	"github.com/go-project-pkg/expandhost"
+	"gopkg.in/yaml.v3"
+)
+
+// allGroup holds vars of all hosts, and its hosts are all hosts of the
+// inventory.
+const allGroup = "all"
+
+// properties of groups in YAML/JSON inventory.
+const (
+	groupPropHosts    = "hosts"
+	groupPropVars     = "vars"
+	groupPropChildren = "children"
+)
+
+// metaGroup holds vars of hosts in Ansible dynamic inventory like
+// '{"_meta": {"hostvars": {"host1": {"port": 22}}}}'.
+const (
+	metaGroup    = "_meta"
+	metaHostVars = "hostvars"
+)
+
+type structuredGroup struct {
+	name     string
+	vars     map[string]interface{}
+	hosts    []structuredHost
+	children []string
+	parents  []string
+}
+
+type structuredHost struct {
+	pattern string
+	vars    map[string]interface{}
+}
+
+type structuredParser struct {
+	inv    *Inventory
+	order  []string
+	groups map[string]*structuredGroup
+
+	// hostVars from '_meta'.
+	hostVars map[string]map[string]interface{}
+}
+
+// newStructuredParser returns a parser of YAML/JSON inventory like:
+//
+//	webserver:
+//	  hosts:
+//	    node[01-03].sre.im:
+//	    web04: {host: 192.168.33.14, port: 8022, env: canary}
+//	  vars:
+//	    user: vagrant
+//	  children:
+//	    dbserver:
+//	      hosts: {192.168.1.10: null}
+func (inv *Inventory) newStructuredParser() *structuredParser {
+	return &structuredParser{
+		inv:      inv,
+		groups:   make(map[string]*structuredGroup),
+		hostVars: make(map[string]map[string]interface{}),
+	}
+}
+
+// parse content into groups, groups of more than one content are merged.
+func (p *structuredParser) parse(content []byte) error {
+	// JSON is valid YAML, and yaml.Node keeps the order of groups and hosts.
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return err
+	}
+
+	if len(root.Content) == 0 || isNull(root.Content[0]) {
+		return nil
+	}
+
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return p.errorf(doc, "top level must be a map of groups")
+	}
+
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value == metaGroup {
+			if err := p.parseMeta(doc.Content[i+1]); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := p.parseGroup(doc.Content[i].Value, doc.Content[i+1], ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *structuredParser) errorf(node *yaml.Node, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", node.Line, fmt.Sprintf(format, args...))
+}
+
+func (p *structuredParser) group(name string) *structuredGroup {
+	g, ok := p.groups[name]
+	if !ok {
+		g = &structuredGroup{name: name, vars: make(map[string]interface{})}
+		p.groups[name] = g
+		p.order = append(p.order, name)
+	}
+
+	return g
+}
+
+// parseGroup parses group node, definitions of the same group are merged.
+//
+//nolint:gocyclo
+func (p *structuredParser) parseGroup(name string, node *yaml.Node, parent string) error {
+	if name == "" {
+		return p.errorf(node, "empty group name")
+	}
+
+	g := p.group(name)
+
+	if parent != "" {
+		if !hasEntry(g.parents, parent) {
+			g.parents = append(g.parents, parent)
+		}
+
+		if pg := p.groups[parent]; !hasEntry(pg.children, name) {
+			pg.children = append(pg.children, name)
+		}
+	}
+
+	if isNull(node) {
+		return nil
+	}
+
+	// a list of hosts like Ansible dynamic inventory.
+	if node.Kind == yaml.SequenceNode {
+		return p.parseHosts(g, node)
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return p.errorf(node, "group '%s' must be a map of %s, %s, %s",
+			name, groupPropHosts, groupPropVars, groupPropChildren)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		switch key.Value {
+		case groupPropHosts:
+			if err := p.parseHosts(g, value); err != nil {
+				return err
+			}
+		case groupPropVars:
+			vars, err := p.parseVars(value)
+			if err != nil {
+				return err
+			}
+
+			for k, v := range vars {
+				g.vars[k] = v
+			}
+		case groupPropChildren:
+			if err := p.parseChildren(g, value); err != nil {
+				return err
+			}
+		default:
+			return p.errorf(key, "invalid property '%s' of group '%s', available properties: %s, %s, %s",
+				key.Value, name, groupPropHosts, groupPropVars, groupPropChildren)
+		}
+	}
+
+	return nil
+}
+
+// parseHosts parses a map of host patterns to host vars, or a list of host
+// patterns.
+func (p *structuredParser) parseHosts(g *structuredGroup, node *yaml.Node) error {
+	switch {
+	case isNull(node):
+	case node.Kind == yaml.SequenceNode:
+		for _, v := range node.Content {
+			if v.Kind != yaml.ScalarNode {
+				return p.errorf(v, "invalid host of group '%s'", g.name)
+			}
+
+			g.hosts = append(g.hosts, structuredHost{pattern: v.Value})
+		}
+	case node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			vars, err := p.parseVars(node.Content[i+1])
+			if err != nil {
+				return err
+			}
+
+			g.hosts = append(g.hosts, structuredHost{pattern: node.Content[i].Value, vars: vars})
+		}
+	default:
+		return p.errorf(node, "hosts of group '%s' must be a map or a list", g.name)
+	}
+
+	return nil
+}
+
+// parseMeta parses vars of hosts in '_meta'.
+func (p *structuredParser) parseMeta(node *yaml.Node) error {
+	if isNull(node) {
+		return nil
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return p.errorf(node, "%s must be a map of %s", metaGroup, metaHostVars)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != metaHostVars {
+			continue
+		}
+
+		hostVars := node.Content[i+1]
+		if isNull(hostVars) {
+			continue
+		}
+
+		if hostVars.Kind != yaml.MappingNode {
+			return p.errorf(hostVars, "%s must be a map of hosts to vars", metaHostVars)
+		}
+
+		for j := 0; j+1 < len(hostVars.Content); j += 2 {
+			vars, err := p.parseVars(hostVars.Content[j+1])
+			if err != nil {
+				return err
+			}
+
+			p.hostVars[hostVars.Content[j].Value] = vars
+		}
+	}
+
+	return nil
+}
+
+// parseChildren parses a map of child groups, or a list of child group names.
+func (p *structuredParser) parseChildren(g *structuredGroup, node *yaml.Node) error {
+	switch {
+	case isNull(node):
+	case node.Kind == yaml.SequenceNode:
+		for _, v := range node.Content {
+			if err := p.parseGroup(v.Value, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}, g.name); err != nil {
+				return err
+			}
+		}
+	case node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := p.parseGroup(node.Content[i].Value, node.Content[i+1], g.name); err != nil {
+				return err
+			}
+		}
+	default:
+		return p.errorf(node, "children of group '%s' must be a map or a list", g.name)
+	}
+
+	return nil
+}
+
+func (p *structuredParser) parseVars(node *yaml.Node) (map[string]interface{}, error) {
+	if isNull(node) {
+		return nil, nil
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil, p.errorf(node, "vars must be a map")
+	}
+
+	vars := make(map[string]interface{})
+	if err := node.Decode(&vars); err != nil {
+		return nil, p.errorf(node, "%s", err)
+	}
+
+	return vars, nil
+}
+
+func (p *structuredParser) children(name string) []string {
+	return p.groups[name].children
+}
+
+func (p *structuredParser) parents(name string) []string {
+	return p.groups[name].parents
+}
+
+// groupHosts returns aliases of hosts in group name and its descendants.
+func (p *structuredParser) groupHosts(name string, hostPatterns map[string][]string, visited map[string]bool) []string {
+	if visited[name] {
+		return nil
+	}
+	visited[name] = true
+
+	g := p.groups[name]
+
+	var aliases []string
+	for _, h := range g.hosts {
+		aliases = append(aliases, hostPatterns[h.pattern]...)
+	}
+
+	for _, child := range g.children {
+		aliases = append(aliases, p.groupHosts(child, hostPatterns, visited)...)
+	}
+
+	return aliases
+}
+
+// build hosts of groups. Vars of a host are merged in order: vars of group
+// 'all', vars of groups the host belongs to (ancestor groups first, and
+// groups defined later override earlier ones), vars of the host itself, vars
+// from host_vars. Vars from group_vars override vars of the same group.
+//
+//nolint:funlen,gocyclo
+func (p *structuredParser) build() error {
+	if err := checkCycles(p.order, p.children); err != nil {
+		return err
+	}
+
+	var hostOrder []string
+
+	hostGroups := make(map[string][]string)
+	hostVarsMap := make(map[string]map[string]interface{})
+	hostPatterns := make(map[string][]string)
+
+	for _, name := range p.order {
+		for _, h := range p.groups[name].hosts {
+			aliases, ok := hostPatterns[h.pattern]
+			if !ok {
+				var err error
+				if aliases, err = expandhost.PatternToHosts(h.pattern); err != nil {
+					return fmt.Errorf("invalid host pattern '%s' in group '%s': %w", h.pattern, name, err)
+				}
+				hostPatterns[h.pattern] = aliases
+			}
+
+			for _, alias := range aliases {
+				if _, ok := hostVarsMap[alias]; !ok {
+					hostOrder = append(hostOrder, alias)
+					hostVarsMap[alias] = make(map[string]interface{})
+				}
+
+				if !hasEntry(hostGroups[alias], name) {
+					hostGroups[alias] = append(hostGroups[alias], name)
+				}
+
+				for k, v := range h.vars {
+					hostVarsMap[alias][k] = v
+				}
+			}
+		}
+	}
+
+	hosts := make(map[string]*Host, len(hostOrder))
+	for _, alias := range hostOrder {
+		vars := make(map[string]interface{})
+		if all, ok := p.groups[allGroup]; ok {
+			mergeVars(vars, all.vars)
+		}
+		mergeVars(vars, p.inv.groupVarsFiles[allGroup])
+
+		applied := map[string]bool{allGroup: true}
+		for _, name := range hostGroups[alias] {
+			for _, group := range append(ancestors(name, p.parents, map[string]bool{name: true}), name) {
+				if !applied[group] {
+					applied[group] = true
+					mergeVars(vars, p.groups[group].vars)
+					mergeVars(vars, p.inv.groupVarsFiles[group])
+				}
+			}
+		}
+
+		mergeVars(vars, hostVarsMap[alias])
+		mergeVars(vars, p.hostVars[alias])
+
+		host, err := p.inv.hostFromVars(alias, vars, p.inv.hostVarsFiles[alias])
+		if err != nil {
+			return err
+		}
+		hosts[alias] = host
+	}
+
+	for _, name := range p.order {
+		var groupHosts []*Host
+
+		aliases := p.groupHosts(name, hostPatterns, make(map[string]bool))
+		if name == allGroup {
+			aliases = hostOrder
+		}
+
+		for _, alias := range aliases {
+			groupHosts = append(groupHosts, hosts[alias])
+		}
+
+		p.inv.groupOrder = append(p.inv.groupOrder, name)
+		p.inv.groupHostsMap[name] = DeDuplHosts(groupHosts)
+		p.inv.groupChildrenMap[name] = p.groups[name].children
+	}
+
+	return nil
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
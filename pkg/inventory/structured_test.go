@@ -0,0 +1,146 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	if err := Parse("hosts_example.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := []interface{}{"web", "nginx"}
+	node2 := &Host{
+		Alias: "alias_name_node2",
+		Host:  "192.168.33.12",
+		Port:  8022,
+		User:  "wangwu",
+		Keys: []string{
+			"~/.ssh/id_dsa",
+			"~/.ssh/id_rsa",
+		},
+		Vars: map[string]interface{}{"env": "prod", "tags": tags, "weight": 10},
+	}
+	node06 := &Host{
+		Alias: "node06.sre.im",
+		Host:  "node06.sre.im",
+		Port:  22,
+		User:  "wangwu",
+		Vars:  map[string]interface{}{"env": "prod", "tags": tags},
+	}
+	node07 := &Host{
+		Alias: "node07.sre.im",
+		Host:  "node07.sre.im",
+		Port:  22,
+		User:  "lisi",
+		Vars:  map[string]interface{}{"env": "canary", "tags": tags},
+	}
+	db := &Host{
+		Alias:    "192.168.1.10",
+		Host:     "192.168.1.10",
+		Port:     22,
+		User:     "vagrant2",
+		Password: "abcdefg",
+		Vars:     map[string]interface{}{"env": "dev"},
+	}
+
+	tests := []struct {
+		name  string
+		group string
+		want  []*Host
+	}{
+		{name: "all", group: "all", want: []*Host{node2, node06, node07, db}},
+		{name: "group with children", group: "webserver", want: []*Host{node2, node06, node07}},
+		{name: "child group", group: "canary", want: []*Host{node07}},
+		{name: "group of children list", group: "project1", want: []*Host{db, node2, node06, node07}},
+		{name: "no group", group: "xxxgroup", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetHostsByGroup(tt.group); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetHostsByGroup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := GetAllHosts(); !reflect.DeepEqual(got, []*Host{node2, node06, node07, db}) {
+		t.Errorf("GetAllHosts() = %v", got)
+	}
+
+	if got := GetHostByAlias("node07.sre.im"); !reflect.DeepEqual(got, node07) {
+		t.Errorf("GetHostByAlias() = %v, want %v", got, node07)
+	}
+}
+
+func TestParseJSONAndINIVars(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []*Host
+	}{
+		{
+			name: "json",
+			file: "hosts.json",
+			content: `{
+	"web": {
+		"hosts": {"web[1-2]": {"port": "2222"}, "web3": null},
+		"vars": {"user": "ops", "role": "web", "keys": "a.key,b.key"}
+	}
+}`,
+			want: []*Host{
+				{
+					Alias: "web1", Host: "web1", Port: 2222, User: "ops", Keys: []string{"a.key", "b.key"},
+					Vars: map[string]interface{}{"role": "web"},
+				},
+				{
+					Alias: "web2", Host: "web2", Port: 2222, User: "ops", Keys: []string{"a.key", "b.key"},
+					Vars: map[string]interface{}{"role": "web"},
+				},
+				{
+					Alias: "web3", Host: "web3", User: "ops", Keys: []string{"a.key", "b.key"},
+					Vars: map[string]interface{}{"role": "web"},
+				},
+			},
+		},
+		{
+			name: "ini",
+			file: "hosts.txt",
+			content: `[web]
+web1 port=2222 role=lb
+web2
+
+[web:vars]
+user=ops
+role=web
+`,
+			want: []*Host{
+				{Alias: "web1", Host: "web1", Port: 2222, User: "ops", Vars: map[string]interface{}{"role": "lb"}},
+				{Alias: "web2", Host: "web2", User: "ops", Vars: map[string]interface{}{"role": "web"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(file, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Parse(file); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := GetAllHosts(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetAllHosts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// directories of vars files in inventory directory.
+const (
+	groupVarsDir = "group_vars"
+	hostVarsDir  = "host_vars"
+)
+
+// ansibleHostVars are Ansible variables that are the same as the built-in
+// variables, they are used if the built-in ones are not set, and the former
+// ones win.
+var ansibleHostVars = []struct {
+	name    string
+	hostVar hostVarType
+}{
+	{"ansible_host", hostVarHost},
+	{"ansible_ssh_host", hostVarHost},
+	{"ansible_port", hostVarPort},
+	{"ansible_ssh_port", hostVarPort},
+	{"ansible_user", hostVarUser},
+	{"ansible_ssh_user", hostVarUser},
+	{"ansible_password", hostVarPassword},
+	{"ansible_ssh_pass", hostVarPassword},
+	{"ansible_ssh_private_key_file", hostVarKeys},
+}
+
+// WithDecrypt sets the function that decrypts values of built-in variables
+// like vault ciphertexts, it returns the value as is if it is not encrypted.
+// Values are decrypted by Inventory.Decrypt, not when the inventory is loaded.
+func WithDecrypt(decrypt func(alias, name, value string) (string, error)) func(*Inventory) {
+	return func(inv *Inventory) {
+		inv.decrypt = decrypt
+	}
+}
+
+func mergeVars(dst, src map[string]interface{}) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// hostFromVars builds host from vars merged in order, vars other than the
+// built-in ones are kept in Host.Vars. Values are not decrypted.
+func (inv *Inventory) hostFromVars(alias string, varsList ...map[string]interface{}) (*Host, error) {
+	vars := make(map[string]interface{})
+	for _, v := range varsList {
+		mergeVars(vars, v)
+	}
+
+	for _, ansibleVar := range ansibleHostVars {
+		v, ok := vars[ansibleVar.name]
+		if !ok {
+			continue
+		}
+
+		delete(vars, ansibleVar.name)
+
+		if name := hostVarsMap[ansibleVar.hostVar]; vars[name] == nil {
+			vars[name] = v
+		}
+	}
+
+	merged := make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		if v != nil {
+			merged[k] = v
+		}
+	}
+
+	host, err := inv.buildHost(alias, merged, false)
+	if err != nil {
+		return nil, err
+	}
+	inv.mergedVars[host] = merged
+
+	return host, nil
+}
+
+// Decrypt returns a copy of host whose built-in variables are decrypted by
+// the function set by WithDecrypt, host itself is not modified. A host not
+// in the inventory is copied as is.
+func (inv *Inventory) Decrypt(host *Host) (*Host, error) {
+	vars, ok := inv.mergedVars[host]
+	if !ok {
+		h := *host
+		h.Keys = append([]string(nil), host.Keys...)
+
+		if host.Vars != nil {
+			h.Vars = make(map[string]interface{}, len(host.Vars))
+			mergeVars(h.Vars, host.Vars)
+		}
+
+		return &h, nil
+	}
+
+	return inv.buildHost(host.Alias, vars, true)
+}
+
+// buildHost builds host from merged vars, values of built-in variables are
+// decrypted if decrypt is true. Otherwise a port that is not a number is
+// left 0 if it may be decrypted later.
+//
+//nolint:gocyclo
+func (inv *Inventory) buildHost(alias string, vars map[string]interface{}, decrypt bool) (*Host, error) {
+	host := &Host{Alias: alias, Host: alias}
+
+	for k, v := range vars {
+		var (
+			value string
+			err   error
+		)
+
+		if hasEntry(hostVars, k) && k != hostVarsMap[hostVarKeys] {
+			value = fmt.Sprint(v)
+
+			if decrypt {
+				if value, err = inv.decryptValue(alias, k, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		switch k {
+		case hostVarsMap[hostVarHost]:
+			if value != "" {
+				host.Host = value
+			}
+		case hostVarsMap[hostVarPort]:
+			if host.Port, err = strconv.Atoi(value); err != nil && !decrypt && inv.decrypt != nil {
+				err = nil
+			}
+		case hostVarsMap[hostVarUser]:
+			host.User = value
+		case hostVarsMap[hostVarPassword]:
+			host.Password = value
+		case hostVarsMap[hostVarKeys]:
+			if host.Keys, err = stringList(v); err != nil || !decrypt {
+				break
+			}
+
+			for i := range host.Keys {
+				if host.Keys[i], err = inv.decryptValue(alias, k, host.Keys[i]); err != nil {
+					return nil, err
+				}
+			}
+		case hostVarsMap[hostVarPassphrase]:
+			host.Passphrase = value
+		case hostVarsMap[hostVarProxy]:
+			host.Proxy = value
+		default:
+			if host.Vars == nil {
+				host.Vars = make(map[string]interface{})
+			}
+			host.Vars[k] = v
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid host var '%s' of '%s': %v", k, alias, v)
+		}
+	}
+
+	return host, nil
+}
+
+func (inv *Inventory) decryptValue(alias, name, value string) (string, error) {
+	if inv.decrypt == nil {
+		return value, nil
+	}
+
+	return inv.decrypt(alias, name, value)
+}
+
+// stringList converts a comma separated string or a list to strings.
+func stringList(v interface{}) ([]string, error) {
+	switch value := v.(type) {
+	case string:
+		return strings.Split(value, ","), nil
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid item: %v", item)
+			}
+			list = append(list, s)
+		}
+
+		return list, nil
+	default:
+		return nil, fmt.Errorf("invalid list: %v", v)
+	}
+}
+
+// loadVarsFiles loads vars of groups or hosts from dir like 'group_vars',
+// vars of 'NAME' are from file 'NAME', 'NAME.yaml', 'NAME.yml', 'NAME.json',
+// or files in directory 'NAME'.
+func loadVarsFiles(dir string) (map[string]map[string]interface{}, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	varsFiles := make(map[string]map[string]interface{})
+
+	for _, entry := range entries {
+		if skipFile(entry.Name()) {
+			continue
+		}
+
+		file := filepath.Join(dir, entry.Name())
+		name := varsName(entry.Name())
+
+		var files []string
+		if entry.IsDir() {
+			name = entry.Name()
+
+			subEntries, err := ioutil.ReadDir(file)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, v := range subEntries {
+				if !v.IsDir() && !skipFile(v.Name()) {
+					files = append(files, filepath.Join(file, v.Name()))
+				}
+			}
+		} else {
+			files = []string{file}
+		}
+
+		if varsFiles[name] == nil {
+			varsFiles[name] = make(map[string]interface{})
+		}
+
+		for _, v := range files {
+			vars, err := loadVarsFile(v)
+			if err != nil {
+				return nil, err
+			}
+
+			mergeVars(varsFiles[name], vars)
+		}
+	}
+
+	return varsFiles, nil
+}
+
+// varsName is the group or host name of vars file, only extensions '.yaml',
+// '.yml' and '.json' are removed, so that names like '10.0.0.1' and
+// 'db.example.com' are kept.
+func varsName(file string) string {
+	switch ext := filepath.Ext(file); strings.ToLower(ext) {
+	case ".yaml", ".yml", ".json":
+		return strings.TrimSuffix(file, ext)
+	default:
+		return file
+	}
+}
+
+func loadVarsFile(file string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]interface{})
+	if err := yaml.Unmarshal(content, &vars); err != nil {
+		return nil, fmt.Errorf("invalid vars file '%s': %w", file, err)
+	}
+
+	return vars, nil
+}
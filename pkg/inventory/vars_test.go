@@ -0,0 +1,90 @@
+package inventory
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecrypt(t *testing.T) {
+	content := `[web]
+web1 host=ENC:10.0.0.1 port=ENC:2222 password=ENC:secret keys=ENC:/path/key
+web2 password=BAD:secret
+`
+
+	errDecrypt := errors.New("wrong password")
+	decrypt := WithDecrypt(func(alias, name, value string) (string, error) {
+		if strings.HasPrefix(value, "BAD:") {
+			return "", errDecrypt
+		}
+
+		return strings.TrimPrefix(value, "ENC:"), nil
+	})
+
+	// values are not decrypted when loading, even if some can not be.
+	inv, err := LoadReader(strings.NewReader(content), FormatINI, decrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web1 := inv.HostByAlias("web1")
+	if web1.Port != 0 || web1.Password != "ENC:secret" {
+		t.Errorf("host is decrypted when loading: %#v", *web1)
+	}
+
+	got, err := inv.Decrypt(web1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Host{Alias: "web1", Host: "10.0.0.1", Port: 2222, Password: "secret", Keys: []string{"/path/key"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decrypt() = %#v, want %#v", *got, *want)
+	}
+
+	if _, err := inv.Decrypt(inv.HostByAlias("web2")); !errors.Is(err, errDecrypt) {
+		t.Errorf("Decrypt() error = %v, want %v", err, errDecrypt)
+	}
+
+	other := &Host{Alias: "other", Host: "other", Port: 22}
+	if got, err := inv.Decrypt(other); err != nil || got == other || !reflect.DeepEqual(got, other) {
+		t.Errorf("Decrypt() of host not in inventory = %v, %v, want a copy", got, err)
+	}
+
+	if _, err := LoadReader(strings.NewReader("web1 port=ENC:2222\n"), FormatINI); err == nil {
+		t.Error("LoadReader() of invalid port without decrypt function should fail")
+	}
+}
+
+func TestDecryptCopies(t *testing.T) {
+	inv, err := LoadReader(strings.NewReader("web1 port=22 keys=/path/key env=prod\n"), FormatINI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web1 := inv.HostByAlias("web1")
+	other := &Host{Alias: "other", Keys: []string{"/path/key"}, Vars: map[string]interface{}{"env": "prod"}}
+
+	for _, host := range []*Host{web1, other} {
+		t.Run(host.Alias, func(t *testing.T) {
+			want := &Host{Alias: host.Alias, Host: host.Host, Port: host.Port, User: host.User, Keys: []string{"/path/key"},
+				Vars: map[string]interface{}{"env": "prod"}}
+
+			// modify the copy as sshtask fills defaults of hosts.
+			v, err := inv.Decrypt(host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.Port, v.User, v.Keys[0], v.Vars["env"] = 2222, "root", "/other/key", "dev"
+
+			if !reflect.DeepEqual(host, want) {
+				t.Errorf("host is modified through its copy: %#v", *host)
+			}
+
+			if v, err := inv.Decrypt(host); err != nil || !reflect.DeepEqual(v, want) {
+				t.Errorf("Decrypt() = %v, %v, want %v", v, err, want)
+			}
+		})
+	}
+}
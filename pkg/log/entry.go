@@ -25,6 +25,7 @@ package log
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"time"
 
 	"github.com/fatih/color"
@@ -101,7 +102,54 @@ func (e *entry) print(colorName colorType) {
 		}
 	}
 
+	e.Logger.mu.Lock()
 	fmt.Fprintln(e.Logger.Out, entry)
+	e.Logger.mu.Unlock()
+}
+
+// stream prints a line of remote output prefixed by hostname, each host has
+// its own color.
+func (e *entry) stream() {
+	e.Data["level"] = "INFO"
+	e.Data["time"] = time.Now().Format(timeFormat)
+
+	entry := ""
+	if e.Logger.JSONFormat {
+		entryByte, _ := json.Marshal(e.Data)
+		entry = string(entryByte)
+	} else {
+		hostname, _ := e.Data["hostname"].(string)
+
+		// color turns itself off if the output is not a terminal or
+		// NO_COLOR is set.
+		prefix := hostColor(hostname).Sprint(hostname + " |")
+
+		entry = fmt.Sprintf("%s %s", prefix, e.Data["line"])
+	}
+
+	e.Logger.mu.Lock()
+	fmt.Fprintln(e.Logger.Out, entry)
+	e.Logger.mu.Unlock()
+}
+
+var hostColors = []*color.Color{
+	color.New(color.FgCyan),
+	color.New(color.FgGreen),
+	color.New(color.FgYellow),
+	color.New(color.FgBlue),
+	color.New(color.FgMagenta),
+	color.New(color.FgHiCyan),
+	color.New(color.FgHiGreen),
+	color.New(color.FgHiYellow),
+	color.New(color.FgHiBlue),
+	color.New(color.FgHiMagenta),
+}
+
+func hostColor(hostname string) *color.Color {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(hostname))
+
+	return hostColors[h.Sum32()%uint32(len(hostColors))]
 }
 
 // Debugf ...
@@ -34,6 +34,7 @@ var (
 	Infof  = std.Infof
 	Warnf  = std.Warnf
 	Errorf = std.Errorf
+	Stream = std.Stream
 
 	WithFields = std.WithFields
 )
@@ -25,6 +25,7 @@ package log
 import (
 	"io"
 	"os"
+	"sync"
 )
 
 type exitFunc func(int)
@@ -39,6 +40,9 @@ type Logger struct {
 	JSONFormat bool
 	Condense   bool
 	ExitFunc   exitFunc
+
+	// mu makes each entry written to Out entirely.
+	mu sync.Mutex
 }
 
 // New logger
@@ -82,3 +86,14 @@ func (l *Logger) Errorf(format string, args ...interface{}) {
 	entry := newEntry(l)
 	entry.Errorf(format, args...)
 }
+
+// Stream outputs a line of output of remote host as soon as it arrives.
+func (l *Logger) Stream(hostname, stream, line string) {
+	entry := newEntry(l)
+	entry.Data = Fields{
+		"hostname": hostname,
+		"stream":   stream,
+		"line":     line,
+	}
+	entry.stream()
+}
@@ -0,0 +1,507 @@
+/*
+Copyright © 2021 windvalley
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package sshconfig reads hosts settings from OpenSSH client config files
+// like '~/.ssh/config'.
+package sshconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/serialt/gosible/pkg/log"
+)
+
+// maxIncludeDepth is the max depth of nested 'Include' like OpenSSH.
+const maxIncludeDepth = 16
+
+// keywords of ssh_config, they are case-insensitive.
+const (
+	keywordHost         = "host"
+	keywordMatch        = "match"
+	keywordInclude      = "include"
+	keywordHostName     = "hostname"
+	keywordPort         = "port"
+	keywordUser         = "user"
+	keywordIdentityFile = "identityfile"
+	keywordProxyJump    = "proxyjump"
+)
+
+// criteria of 'Match'.
+const (
+	criterionAll          = "all"
+	criterionCanonical    = "canonical"
+	criterionFinal        = "final"
+	criterionHost         = "host"
+	criterionOriginalHost = "originalhost"
+	criterionUser         = "user"
+	criterionLocalUser    = "localuser"
+)
+
+// Host is settings of a host from ssh_config, zero values are not set.
+type Host struct {
+	HostName      string
+	Port          int
+	User          string
+	IdentityFiles []string
+	ProxyJump     string
+}
+
+// Config is a parsed ssh_config. Like OpenSSH, for each setting of a host,
+// the first obtained value is used, except that identity files are added
+// up.
+type Config struct {
+	blocks []*block
+}
+
+// block is options under a 'Host' or 'Match' line. Options before the first
+// 'Host' or 'Match' line of a file apply to all hosts matched by parent.
+type block struct {
+	hosts   []string
+	match   []criterion
+	isMatch bool
+
+	// parent is the block that includes the file of this block.
+	parent *block
+
+	options []option
+}
+
+type criterion struct {
+	name   string
+	arg    string
+	negate bool
+}
+
+type option struct {
+	keyword string
+	args    []string
+}
+
+// Load ssh_config file, files of 'Include' are loaded recursively.
+func Load(file string) (*Config, error) {
+	c := &Config{}
+	if err := c.parseFile(expandTilde(file), nil, 0); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Parse ssh_config from r, relative paths of 'Include' are in '~/.ssh'.
+func Parse(r io.Reader) (*Config, error) {
+	c := &Config{}
+	if err := c.parse(r, "", nil, 0); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) parseFile(file string, parent *block, depth int) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.parse(f, file, parent, depth)
+}
+
+//nolint:funlen,gocyclo
+func (c *Config) parse(r io.Reader, file string, parent *block, depth int) error {
+	current := &block{parent: parent}
+	c.blocks = append(c.blocks, current)
+
+	errorf := func(line int, format string, args ...interface{}) error {
+		msg := fmt.Sprintf(format, args...)
+		if file == "" {
+			return fmt.Errorf("line %d: %s", line, msg)
+		}
+
+		return fmt.Errorf("%s line %d: %s", file, line, msg)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		keyword, args, err := splitLine(scanner.Text())
+		if err != nil {
+			return errorf(lineNum, "%s", err)
+		}
+
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case keywordHost:
+			if len(args) == 0 {
+				return errorf(lineNum, "missing host patterns")
+			}
+
+			current = &block{hosts: args, parent: parent}
+			c.blocks = append(c.blocks, current)
+		case keywordMatch:
+			criteria, err := parseMatch(args)
+			if err != nil {
+				return errorf(lineNum, "%s", err)
+			}
+
+			current = &block{match: criteria, isMatch: true, parent: parent}
+			c.blocks = append(c.blocks, current)
+		case keywordInclude:
+			if len(args) == 0 {
+				return errorf(lineNum, "missing files to include")
+			}
+
+			if depth >= maxIncludeDepth {
+				return errorf(lineNum, "too many nested includes (max %d)", maxIncludeDepth)
+			}
+
+			for _, pattern := range args {
+				pattern = expandTilde(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(os.Getenv("HOME"), ".ssh", pattern)
+				}
+
+				files, err := filepath.Glob(pattern)
+				if err != nil {
+					return errorf(lineNum, "invalid include pattern '%s': %s", pattern, err)
+				}
+
+				for _, v := range files {
+					if err := c.parseFile(v, current, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+
+			// options after 'Include' come after options of the included
+			// files, under the same 'Host' or 'Match'.
+			next := *current
+			next.options = nil
+			current = &next
+			c.blocks = append(c.blocks, current)
+		default:
+			if len(args) == 0 {
+				return errorf(lineNum, "missing value of '%s'", keyword)
+			}
+
+			if keyword == keywordPort {
+				if port, err := strconv.Atoi(args[0]); err != nil || port < 1 || port > 65535 {
+					return errorf(lineNum, "invalid port '%s'", args[0])
+				}
+			}
+
+			current.options = append(current.options, option{keyword: keyword, args: args})
+		}
+	}
+
+	return scanner.Err()
+}
+
+// splitLine splits a line like 'Keyword value' or 'Keyword=value' into the
+// lowercase keyword and arguments, arguments may be quoted by '"'.
+func splitLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+
+	keyword := strings.ToLower(line[:end])
+
+	rest := strings.TrimSpace(line[end:])
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+
+	var (
+		args    []string
+		arg     strings.Builder
+		inQuote bool
+		hasArg  bool
+	)
+
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuote:
+			if hasArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				hasArg = false
+			}
+		case r == '#' && !inQuote && !hasArg:
+			return keyword, args, nil
+		default:
+			arg.WriteRune(r)
+			hasArg = true
+		}
+	}
+
+	if inQuote {
+		return "", nil, fmt.Errorf("unbalanced quotes in '%s'", line)
+	}
+
+	if hasArg {
+		args = append(args, arg.String())
+	}
+
+	return keyword, args, nil
+}
+
+func parseMatch(args []string) ([]criterion, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing criteria of 'Match'")
+	}
+
+	var criteria []criterion
+	for i := 0; i < len(args); i++ {
+		cr := criterion{name: strings.ToLower(args[i])}
+		if strings.HasPrefix(cr.name, "!") {
+			cr.negate = true
+			cr.name = cr.name[1:]
+		}
+
+		switch cr.name {
+		case criterionAll, criterionCanonical, criterionFinal:
+		default:
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("missing argument of 'Match %s'", cr.name)
+			}
+			i++
+			cr.arg = args[i]
+		}
+
+		criteria = append(criteria, cr)
+	}
+
+	return criteria, nil
+}
+
+// Host returns settings of alias. Tokens like '%h' and '%r' in HostName and
+// IdentityFile are expanded, and '~' in IdentityFile is expanded to the home
+// directory.
+//
+//nolint:gocyclo
+func (c *Config) Host(alias string) *Host {
+	h := &Host{}
+
+	for _, b := range c.blocks {
+		if !c.matches(b, alias, h) {
+			continue
+		}
+
+		for _, opt := range b.options {
+			value := opt.args[0]
+
+			switch opt.keyword {
+			case keywordHostName:
+				if h.HostName == "" {
+					h.HostName = expandTokens(value, map[byte]string{'h': alias})
+				}
+			case keywordPort:
+				if h.Port == 0 {
+					h.Port, _ = strconv.Atoi(value)
+				}
+			case keywordUser:
+				if h.User == "" {
+					h.User = value
+				}
+			case keywordIdentityFile:
+				h.IdentityFiles = append(h.IdentityFiles, opt.args...)
+			case keywordProxyJump:
+				if h.ProxyJump == "" {
+					h.ProxyJump = value
+				}
+			}
+		}
+	}
+
+	home := os.Getenv("HOME")
+	tokens := map[byte]string{
+		'd': home,
+		'u': os.Getenv("USER"),
+		'h': h.HostName,
+		'n': alias,
+		'r': h.User,
+		'p': strconv.Itoa(h.Port),
+	}
+	if h.HostName == "" {
+		tokens['h'] = alias
+	}
+	if h.User == "" {
+		tokens['r'] = tokens['u']
+	}
+	if h.Port == 0 {
+		tokens['p'] = "22"
+	}
+
+	for i, v := range h.IdentityFiles {
+		h.IdentityFiles[i] = expandTokens(expandTilde(v), tokens)
+	}
+
+	return h
+}
+
+// matches reports whether block b applies to alias, h holds settings
+// obtained so far which are used by 'Match host' and 'Match user'.
+func (c *Config) matches(b *block, alias string, h *Host) bool {
+	if b.parent != nil && !c.matches(b.parent, alias, h) {
+		return false
+	}
+
+	if !b.isMatch {
+		return b.hosts == nil || matchList(strings.ToLower(alias), b.hosts)
+	}
+
+	hostName := alias
+	if h.HostName != "" {
+		hostName = h.HostName
+	}
+
+	user := h.User
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+
+	for _, cr := range b.match {
+		var ok bool
+
+		switch cr.name {
+		case criterionAll:
+			ok = true
+		case criterionHost:
+			ok = matchList(strings.ToLower(hostName), strings.Split(cr.arg, ","))
+		case criterionOriginalHost:
+			ok = matchList(strings.ToLower(alias), strings.Split(cr.arg, ","))
+		case criterionUser:
+			ok = matchList(user, strings.Split(cr.arg, ","))
+		case criterionLocalUser:
+			ok = matchList(os.Getenv("USER"), strings.Split(cr.arg, ","))
+		default:
+			log.Debugf("SSH Config: 'Match %s' is not supported, the block is skipped", cr.name)
+			return false
+		}
+
+		if ok == cr.negate {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchList reports whether s matches the patterns, it does not match if it
+// matches any negated pattern like '!pattern'.
+func matchList(s string, patterns []string) bool {
+	matched := false
+
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if matchPattern(s, strings.ToLower(pattern[1:])) {
+				return false
+			}
+
+			continue
+		}
+
+		if matchPattern(s, strings.ToLower(pattern)) {
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// matchPattern matches s against pattern with wildcards '*' and '?'.
+func matchPattern(s, pattern string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchPattern(s[i:], pattern[1:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+
+		s, pattern = s[1:], pattern[1:]
+	}
+
+	return len(s) == 0
+}
+
+// expandTokens expands tokens like '%h' by values, and '%%' to '%'.
+func expandTokens(s string, values map[byte]string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		if v, ok := values[s[i]]; ok {
+			b.WriteString(v)
+		} else if s[i] == '%' {
+			b.WriteByte('%')
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+func expandTilde(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return os.Getenv("HOME") + path[1:]
+	}
+
+	return path
+}
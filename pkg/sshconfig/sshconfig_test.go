@@ -0,0 +1,144 @@
+package sshconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHost(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USER", "alice")
+
+	sshDir := filepath.Join(home, ".ssh")
+	if err := os.MkdirAll(filepath.Join(sshDir, "config.d"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"config": `
+# comment
+Include config.d/*.conf
+
+Host db? !db9
+    HostName %h.db.example.com
+    Port 2201
+
+Host web*
+    Include web
+    User www
+
+Match host *.db.example.com user alice
+    User dba
+    IdentityFile ~/.ssh/id_%r
+
+Match originalhost db9
+    ProxyJump none
+
+Host *
+    User = "default user"
+    IdentityFile %d/.ssh/id_%h
+    ProxyJump bastion
+`,
+		"config.d/10-bastion.conf": `
+Host bastion
+    HostName 10.0.0.1
+    Port 2222
+`,
+		"web": `
+Port 8022
+IdentityFile ~/.ssh/web
+`,
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(sshDir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := Load("~/.ssh/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		alias string
+		want  *Host
+	}{
+		{
+			"db1",
+			&Host{
+				HostName:      "db1.db.example.com",
+				Port:          2201,
+				User:          "dba",
+				IdentityFiles: []string{home + "/.ssh/id_dba", home + "/.ssh/id_db1.db.example.com"},
+				ProxyJump:     "bastion",
+			},
+		},
+		{
+			"db9",
+			&Host{User: "default user", IdentityFiles: []string{home + "/.ssh/id_db9"}, ProxyJump: "none"},
+		},
+		{
+			"web01",
+			&Host{
+				Port:          8022,
+				User:          "www",
+				IdentityFiles: []string{home + "/.ssh/web", home + "/.ssh/id_web01"},
+				ProxyJump:     "bastion",
+			},
+		},
+		{
+			"bastion",
+			&Host{
+				HostName:      "10.0.0.1",
+				Port:          2222,
+				User:          "default user",
+				IdentityFiles: []string{home + "/.ssh/id_10.0.0.1"},
+				ProxyJump:     "bastion",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := c.Host(tt.alias); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Host(%s) = %+v, want %+v", tt.alias, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{"Host\n", "line 1: missing host patterns"},
+		{"Host a\n  Port abc\n", "line 2: invalid port 'abc'"},
+		{"Match host\n", "line 1: missing argument of 'Match host'"},
+		{`User "a b`, "unbalanced quotes"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader(tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.content, err, tt.err)
+		}
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config")
+
+	if err := os.WriteFile(file, []byte("Include "+file+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(file); err == nil || !strings.Contains(err.Error(), "too many nested includes") {
+		t.Errorf("Load() error = %v, want too many nested includes", err)
+	}
+}
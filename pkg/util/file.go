@@ -23,7 +23,9 @@ THE SOFTWARE.
 package util
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -59,3 +61,66 @@ func rebuildPath(path string) string {
 
 	return path
 }
+
+// IsExecutable reports whether the file has any executable bit.
+func IsExecutable(file string) (bool, error) {
+	f, err := os.Stat(rebuildPath(file))
+	if err != nil {
+		return false, err
+	}
+
+	return !f.IsDir() && f.Mode().Perm()&0111 != 0, nil
+}
+
+// WriteFileAtomic replaces the content of an existing file by writing a
+// temporary file in the same directory and renaming it over the file, so
+// the file is never left half written. The mode of the file is kept, and
+// symbolic links are followed.
+func WriteFileAtomic(file string, content []byte) error {
+	file, err := filepath.EvalSymlinks(rebuildPath(file))
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpFile := f.Name()
+
+	if err := writeAndSync(f, content, fi.Mode().Perm()); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	if err := os.Rename(tmpFile, file); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	return nil
+}
+
+func writeAndSync(f *os.File, content []byte, mode os.FileMode) error {
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -31,6 +31,9 @@ import (
 )
 
 // Zip a dir or file.
+//
+// Deprecated: files are pushed and fetched by sftp or tar streams without
+// zip, Zip will be removed in a future release.
 func Zip(pathToZip, zipName string) error {
 	file, err := os.Create(zipName)
 	if err != nil {
@@ -92,6 +95,9 @@ func Zip(pathToZip, zipName string) error {
 }
 
 // Unzip a zip file.
+//
+// Deprecated: files are pushed and fetched by sftp or tar streams without
+// zip, Unzip will be removed in a future release.
 func Unzip(zipName, dstDir string) error {
 	archive, err := zip.OpenReader(zipName)
 	if err != nil {